@@ -0,0 +1,264 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"check_restic/pkg/checkrestic"
+	"github.com/pkg/sftp"
+)
+
+// objectStore is the repository storage of the 'local', 'rest', 's3', 'azure',
+// 'gcs' and 'rclone' backends, as set up by setupBackend. It is shared by all repositories, so
+// that access tokens are only obtained once.
+var objectStore checkrestic.FS
+
+// objectStoreLocation describes the server, container or bucket of
+// objectStore, it is empty for the local backend. It replaces the ssh host of the repositories,
+// e.g. in the output and the state file.
+var objectStoreLocation string
+
+// setupBackend validates the options of the backend and prepares the access
+// to an object store.
+func setupBackend() error {
+	client, transport := newHTTPClient(time.Minute)
+	switch *backendName {
+	case "sftp":
+		return nil
+
+	case "local":
+		objectStore = checkrestic.LocalFS{}
+
+	case "rest":
+		if *restURL == "" {
+			return fmt.Errorf("The option 'rest-url' needs to be set for the rest backend.")
+		}
+		u, err := url.Parse(*restURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("The option 'rest-url' needs to be a valid http or https URL.")
+		}
+		rfs := checkrestic.RESTFS{Client: client}
+		// like restic, accept the credentials as part of the URL, but keep
+		// them out of messages
+		if u.User != nil {
+			rfs.User = u.User.Username()
+			rfs.Password, _ = u.User.Password()
+			u.User = nil
+		}
+		if *restUser != "" {
+			rfs.User = *restUser
+		}
+		if *restPasswordFile != "" {
+			data, err := os.ReadFile(*restPasswordFile)
+			if err != nil {
+				return fmt.Errorf("Unable to read the REST password file: %s", err)
+			}
+			rfs.Password = strings.TrimRight(string(data), "\r\n")
+		}
+		if *insecureTLS {
+			transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		}
+		rfs.URL = u.String()
+		objectStore, objectStoreLocation = rfs, u.Host
+
+	case "rclone":
+		if *rcloneRemote == "" {
+			return fmt.Errorf("The option 'rclone-remote' needs to be set for the rclone backend.")
+		}
+		if _, err := exec.LookPath(*rcloneProgram); err != nil {
+			return fmt.Errorf("The rclone backend needs rclone to be installed: %s", err)
+		}
+		rclone = &rcloneServer{}
+		client.Transport = rclone.transport()
+		// rclone serves the remote itself, so the host is never used
+		objectStore, objectStoreLocation = checkrestic.RESTFS{Client: client, URL: "http://rclone/"}, *rcloneRemote
+
+	case "s3":
+		if *s3Bucket == "" {
+			return fmt.Errorf("The option 's3-bucket' needs to be set for the s3 backend.")
+		}
+		region := *s3Region
+		if region == "" {
+			region = os.Getenv("AWS_REGION")
+		}
+		if region == "" {
+			region = os.Getenv("AWS_DEFAULT_REGION")
+		}
+		if region == "" {
+			region = "us-east-1"
+		}
+		sfs := checkrestic.S3FS{Client: client, Bucket: *s3Bucket, Prefix: *s3Prefix}
+		location := *s3Bucket
+		if *s3Endpoint != "" {
+			u, err := url.Parse(*s3Endpoint)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("The option 's3-endpoint' needs to be a valid http or https URL.")
+			}
+			// compatible services like MinIO rarely have wildcard DNS
+			sfs.Endpoint, sfs.PathStyle = *s3Endpoint, true
+			location = u.Host + "/" + *s3Bucket
+		} else {
+			sfs.Endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+		}
+
+		creds := checkrestic.S3Credentials{
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}
+		if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+			name := *s3CredentialsFile
+			if name == "" {
+				name = os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+			}
+			if name == "" {
+				home, _ := os.UserHomeDir()
+				name = path.Join(home, ".aws", "credentials")
+			}
+			profile := os.Getenv("AWS_PROFILE")
+			if profile == "" {
+				profile = "default"
+			}
+			var err error
+			if creds, err = checkrestic.LoadS3Credentials(name, profile); err != nil {
+				return fmt.Errorf("Unable to load the S3 credentials, set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY or use 's3-credentials-file': %s", err)
+			}
+		}
+		sfs.Authorize = checkrestic.S3SigV4(creds, region)
+		if *s3Prefix != "" {
+			location += "/" + strings.Trim(*s3Prefix, "/")
+		}
+		objectStore, objectStoreLocation = sfs, location
+
+	case "azure":
+		if *azureContainer == "" {
+			return fmt.Errorf("The option 'azure-container' needs to be set for the azure backend.")
+		}
+		afs := checkrestic.AzureFS{Client: client, Container: *azureContainer}
+		account := *azureAccount
+		if s := os.Getenv("AZURE_STORAGE_CONNECTION_STRING"); s != "" {
+			cs, err := checkrestic.ParseAzureConnectionString(s)
+			if err != nil {
+				return fmt.Errorf("Invalid AZURE_STORAGE_CONNECTION_STRING: %s", err)
+			}
+			if account == "" {
+				account = cs.Account
+			}
+			afs.Endpoint = cs.Endpoint
+			var aerr error
+			if cs.SAS != "" {
+				afs.Authorize, aerr = checkrestic.AzureSAS(cs.SAS)
+			} else if cs.Key != "" {
+				afs.Authorize, aerr = checkrestic.AzureSharedKey(account, cs.Key)
+			}
+			if aerr != nil {
+				return fmt.Errorf("Invalid AZURE_STORAGE_CONNECTION_STRING: %s", aerr)
+			}
+		}
+		if account == "" && afs.Endpoint == "" {
+			return fmt.Errorf("The option 'azure-account' or the environment variable AZURE_STORAGE_CONNECTION_STRING needs to be set for the azure backend.")
+		}
+		if afs.Endpoint == "" {
+			afs.Endpoint = fmt.Sprintf("https://%s.blob.core.windows.net", account)
+		}
+		location := account
+		if location == "" {
+			location = strings.TrimPrefix(strings.TrimPrefix(afs.Endpoint, "https://"), "http://")
+		}
+		if afs.Authorize == nil {
+			var err error
+			if key := os.Getenv("AZURE_ACCOUNT_KEY"); key != "" {
+				afs.Authorize, err = checkrestic.AzureSharedKey(account, key)
+			} else if sas := os.Getenv("AZURE_ACCOUNT_SAS"); sas != "" {
+				afs.Authorize, err = checkrestic.AzureSAS(sas)
+			} else {
+				afs.Authorize = checkrestic.AzureManagedIdentity(client, os.Getenv("AZURE_CLIENT_ID"))
+			}
+			if err != nil {
+				return fmt.Errorf("Invalid Azure credentials: %s", err)
+			}
+		}
+		objectStore, objectStoreLocation = afs, location+"/"+*azureContainer
+
+	case "gcs":
+		if *gcsBucket == "" {
+			return fmt.Errorf("The option 'gcs-bucket' needs to be set for the gcs backend.")
+		}
+		gfs := checkrestic.GCSFS{Client: client, Bucket: *gcsBucket}
+		var err error
+		if host := os.Getenv("STORAGE_EMULATOR_HOST"); host != "" {
+			// emulators do not check any credentials
+			gfs.Endpoint = "http://" + host
+		} else if *gcsKeyFile != "" {
+			var key []byte
+			if key, err = os.ReadFile(*gcsKeyFile); err == nil {
+				gfs.Authorize, err = checkrestic.GCSServiceAccount(client, key)
+			}
+		} else {
+			gfs.Authorize, err = checkrestic.GCSDefaultCredentials(client)
+		}
+		if err != nil {
+			return fmt.Errorf("Unable to load the Google Cloud credentials: %s", err)
+		}
+		objectStore, objectStoreLocation = gfs, *gcsBucket
+
+	default:
+		return fmt.Errorf("The option 'backend' needs to be one of 'sftp', 'local', 'rest', 's3', 'azure', 'gcs' or 'rclone'.")
+	}
+
+	// these only make sense for, or are implemented via, ssh and sftp
+	for name, set := range map[string]bool{
+		"self-test":        *selfTest,
+		"check-writable":   *checkWritable,
+		"check-free-space": checksFreeSpace(),
+		"proxy-command":    *proxyCommand != "",
+		"proxy-jump":       *proxyJump != "",
+		"pkcs11-lib":       *pkcs11Lib != "",
+	} {
+		if set {
+			return fmt.Errorf("The option '%s' is only supported by the sftp backend.", name)
+		}
+	}
+	return nil
+}
+
+// restProtocol reports whether the backend speaks the REST protocol of
+// restic, which does not report modification times.
+func restProtocol() bool {
+	return *backendName == "rest" || *backendName == "rclone"
+}
+
+// openFS returns the storage of the repository. The sftp session is only set
+// for the sftp backend, the returned function releases it.
+func openFS(repo repository) (checkrestic.FS, *sftp.Client, func(), error) {
+	if objectStore != nil {
+		return objectStore, nil, func() {}, nil
+	}
+	client, disconnect, err := connect(repo)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return checkrestic.SFTPFS{Client: client}, client, disconnect, nil
+}
+
+// repoMissing reports whether the repository does not exist, or at least not
+// its snapshots. Any other error, e.g. a lack of permissions or a lost
+// connection, does not count as missing. Object stores have no directories,
+// so the config file is looked for instead.
+func repoMissing(fsys checkrestic.FS, repoPath string) bool {
+	var err error
+	if s, ok := fsys.(checkrestic.StatFS); ok {
+		_, err = s.Stat(path.Join(repoPath, "snapshots"))
+	} else {
+		_, err = fsys.ReadAt(path.Join(repoPath, "config"), 0, 1)
+	}
+	return errors.Is(err, fs.ErrNotExist)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"check_restic/pkg/checkrestic"
+)
+
+// runBench connects to every repository and lists its snapshots 'bench'
+// times in a row, then prints the distribution of the timings and the amount
+// of data exchanged. Depending on 'reuse-connections', all cycles share a
+// single connection or each of them opens its own, which shows the cost of
+// the ssh handshake. It stops at the first error, e.g. a failed login.
+func runBench() (int, string) {
+	mode := "on"
+	if !*reuseConns {
+		mode = "off"
+	}
+
+	var b strings.Builder
+	for _, repo := range repos {
+		received, sent := atomic.LoadInt64(&bytesReceived), atomic.LoadInt64(&bytesSent)
+		var connects, lists, totals []time.Duration
+		for i := 1; i <= *benchCycles; i++ {
+			start := time.Now()
+			fsys, _, disconnect, err := openFS(repo)
+			if err != nil {
+				return UNKNOWN, fmt.Sprintf("%s: cycle %d: %s\n", getStatusStr(UNKNOWN), i, err)
+			}
+			connected := time.Now()
+			_, _, err = checkrestic.ListSnapshotFilesConcurrently(fsys, repo.Path, *repoLayout, *listConcurrency)
+			disconnect()
+			if err != nil {
+				return UNKNOWN, fmt.Sprintf("%s: cycle %d: %s\n", getStatusStr(UNKNOWN), i, err)
+			}
+			done := time.Now()
+			connects = append(connects, connected.Sub(start))
+			lists = append(lists, done.Sub(connected))
+			totals = append(totals, done.Sub(start))
+		}
+
+		if len(repos) > 1 {
+			fmt.Fprintf(&b, "[%s]\n", repo.Label)
+		}
+		fmt.Fprintf(&b, "%d cycles, connection reuse %s\n", *benchCycles, mode)
+		w := tabwriter.NewWriter(&b, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(w, "\tmin\tmedian\tp95\tmax\n")
+		for _, row := range []struct {
+			name      string
+			durations []time.Duration
+		}{{"connect", connects}, {"list", lists}, {"total", totals}} {
+			d := row.durations
+			sort.Slice(d, func(a, b int) bool { return d[a] < d[b] })
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", row.name, d[0].Round(time.Microsecond), percentile(d, 50).Round(time.Microsecond),
+				percentile(d, 95).Round(time.Microsecond), d[len(d)-1].Round(time.Microsecond))
+		}
+		w.Flush()
+		fmt.Fprintf(&b, "transferred %s received, %s sent\n",
+			formatBytes(atomic.LoadInt64(&bytesReceived)-received), formatBytes(atomic.LoadInt64(&bytesSent)-sent))
+	}
+	return OK, b.String()
+}
+
+// percentile returns the p-th percentile of the sorted durations using the
+// nearest-rank method.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"check_restic/pkg/checkrestic"
+)
+
+// checkCadence compares the intervals between the latest 'cadence-window'
+// snapshots, newest first, with the cadence thresholds: their average, or
+// with 'cadence-by=max' the longest of them. Unlike the age of the latest
+// snapshot, this notices backups which still run, but less often than they
+// should, e.g. daily instead of hourly.
+func checkCadence(res *result, snapshots []*checkrestic.Snapshot) subResult {
+	sub := subResult{Name: "cadence"}
+	n := *cadenceWindow
+	if len(snapshots) < n {
+		n = len(snapshots)
+	}
+	if n < 2 {
+		return sub
+	}
+
+	var total, longest time.Duration
+	var longestEnd time.Time
+	for i := 0; i < n-1; i++ {
+		gap := snapshotTime(snapshots[i]).Sub(snapshotTime(snapshots[i+1]))
+		total += gap
+		if gap > longest {
+			longest, longestEnd = gap, snapshotTime(snapshots[i])
+		}
+	}
+	gap := total / time.Duration(n-1)
+	what := fmt.Sprintf("average interval of the latest %d snapshots", n)
+	if *cadenceBy == "max" {
+		gap = longest
+		what = fmt.Sprintf("longest interval between the latest %d snapshots", n)
+	}
+	res.CadenceGap = &gap
+
+	var limit time.Duration
+	switch {
+	case *cadenceCritical > 0 && gap > *cadenceCritical:
+		sub.Status, limit = CRITICAL, *cadenceCritical
+	case *cadenceWarning > 0 && gap > *cadenceWarning:
+		sub.Status, limit = WARNING, *cadenceWarning
+	default:
+		return sub
+	}
+	sub.Message = fmt.Sprintf("%s is %s, expected at most %s", what, gap.Round(agePrecision), limit)
+	if *cadenceBy == "max" {
+		sub.Message += fmt.Sprintf(" (until %s)", longestEnd.Local().Format("2006-01-02 15:04 MST"))
+	}
+	return sub
+}
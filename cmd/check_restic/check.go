@@ -0,0 +1,460 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"check_restic/pkg/checkrestic"
+	"github.com/pkg/sftp"
+)
+
+// repoCheck holds the data shared by the checks of a single repository. Since
+// most checks only need some of it, the repository is only decrypted once a
+// check requires it, and at most once.
+type repoCheck struct {
+	repo repository
+	fs   checkrestic.FS
+	// client is only set for the sftp backend.
+	client *sftp.Client
+	files  []os.FileInfo
+	layout string
+
+	r         *checkrestic.Repo
+	cfg       *checkrestic.Config
+	snapshots []*checkrestic.Snapshot
+}
+
+func (c *repoCheck) open() (*checkrestic.Repo, error) {
+	if c.r == nil {
+		r, err := checkrestic.OpenRepo(c.fs, c.repo.Path, repoPassword(c.repo))
+		if err != nil {
+			return nil, err
+		}
+		r.SnapshotLayout = c.layout
+		r.DecodeConcurrency = *decodeConcurrency
+		c.r = r
+	}
+	return c.r, nil
+}
+
+// config returns the decrypted config of the repository.
+func (c *repoCheck) config() (*checkrestic.Config, error) {
+	if c.cfg == nil {
+		r, err := c.open()
+		if err != nil {
+			return nil, err
+		}
+		cfg, err := r.Config()
+		if err != nil {
+			return nil, err
+		}
+		c.cfg = cfg
+	}
+	return c.cfg, nil
+}
+
+// decoded returns all snapshots of the repository, decrypted.
+func (c *repoCheck) decoded() ([]*checkrestic.Snapshot, error) {
+	if c.snapshots == nil {
+		r, err := c.open()
+		if err != nil {
+			return nil, err
+		}
+		start := time.Now()
+		snapshots, err := r.SnapshotsContext(runCtx, c.files)
+		debugf("parsing", "decoded the snapshots", "repository", c.repo.Path, "snapshots", len(snapshots), "took", time.Since(start), "error", err)
+		if err != nil {
+			return nil, err
+		}
+		c.snapshots = snapshots
+	}
+	return c.snapshots, nil
+}
+
+// snapshotTime returns the time of the snapshot as selected by the
+// 'newest-by' option.
+func snapshotTime(sn *checkrestic.Snapshot) time.Time {
+	return checkrestic.SnapshotTime(sn, newestBy)
+}
+
+func checkRepository(repo repository) result {
+	res := result{Repo: repo, Snapshots: -1}
+	retried := 0
+	done := func(status int, msg string) result {
+		res.Status, res.Message, res.Retries = status, msg+retriesNote(retried), retried
+		return res
+	}
+
+	var c *repoCheck
+	var snapshots []*checkrestic.Snapshot
+	if *useResticBinary {
+		var err error
+		retried, err = retry("listing the snapshots", func() error {
+			snapshots, err = resticSnapshots(repo)
+			return err
+		})
+		if errors.Is(err, errNoResticRepo) && *exitOKOnMissing {
+			return done(OK, "repository not yet present, ignored")
+		}
+		if err != nil {
+			return done(UNKNOWN, err.Error())
+		}
+		res.Snapshots = len(snapshots)
+		if len(snapshots) == 0 {
+			return done(emptyRepoStatus, "no snapshots found")
+		}
+		c = &repoCheck{repo: repo, snapshots: snapshots}
+	} else {
+		// connecting and listing are retried together, since the listing
+		// fails if the connection was lost
+		var fsys checkrestic.FS
+		var client *sftp.Client
+		var disconnect func()
+		var files []os.FileInfo
+		var layout string
+		var listErr error
+		var err error
+		retried, err = retry("listing the snapshots", func() error {
+			// release the connection of the previous attempt
+			if disconnect != nil {
+				disconnect()
+			}
+			var err error
+			listErr = nil
+			fsys, client, disconnect, err = openFS(repo)
+			if err != nil {
+				return err
+			}
+			// get a list of all snapshots in the restic repository
+			start := time.Now()
+			files, layout, listErr = checkrestic.ListSnapshotFilesConcurrently(fsys, repo.Path, *repoLayout, *listConcurrency)
+			debugf("listing", "listed the snapshots", "repository", repo.Path, "files", len(files), "layout", layout, "took", time.Since(start), "error", listErr)
+			return listErr
+		})
+		if errors.Is(err, errHostKeyChanged) {
+			return done(CRITICAL, err.Error())
+		}
+		if err != nil && err != listErr {
+			// only connecting can fail before the listing
+			return done(connectErrorStatus, err.Error())
+		}
+		defer disconnect()
+
+		if *checkStructure {
+			problems, err := structureProblems(fsys, repo.Path)
+			if err != nil {
+				return done(UNKNOWN, err.Error())
+			}
+			if len(problems) > 0 && !(*exitOKOnMissing && repoMissing(fsys, repo.Path)) {
+				return done(CRITICAL, "repository appears corrupted or uninitialized: "+strings.Join(problems, ", "))
+			}
+		}
+
+		if (listErr != nil || len(files) == 0) && *exitOKOnMissing && repoMissing(fsys, repo.Path) {
+			return done(OK, "repository not yet present, ignored")
+		}
+		if listErr != nil {
+			return done(UNKNOWN, listErr.Error())
+		}
+		if len(files) == 0 {
+			res.Snapshots = 0
+			return done(emptyRepoStatus, "no snapshots found")
+		}
+		files = checkrestic.SnapshotFiles(files)
+		c = &repoCheck{repo: repo, fs: fsys, client: client, files: files, layout: layout}
+
+		res.Snapshots = len(files)
+		if len(files) == 0 {
+			return done(emptyRepoStatus, "no valid snapshot files found")
+		}
+
+		// only decrypt the snapshots if their times, hosts, tags or paths are
+		// needed
+		snapshots = checkrestic.ListedSnapshots(files)
+		if newestBy != "modtime" || *heartbeatTag != "" || *pathsChange || checksRetention() || repo.filtersSnapshots() {
+			snapshots, err = c.decoded()
+			if err != nil {
+				return done(UNKNOWN, err.Error())
+			}
+		}
+	}
+
+	// with a 'schedule' the thresholds for the age are optional, those which
+	// are not set are negative
+	checker := checkrestic.Checker{
+		Thresholds:   checkrestic.Thresholds{Warning: repo.Warning, Critical: repo.Critical},
+		NewestBy:     newestBy,
+		AgePrecision: agePrecision,
+		Hosts:        repo.hostFilter(),
+		Tags:         repo.tagFilter(),
+		Paths:        snapshotPaths,
+	}
+	if *heartbeatTag != "" {
+		// the heartbeat is checked on its own and must not make the real
+		// backups look fresh
+		checker.ExcludeTags = []string{*heartbeatTag}
+	}
+	cres, err := checker.Check(runCtx, checkrestic.SnapshotList(snapshots))
+	if err != nil {
+		return done(UNKNOWN, err.Error())
+	}
+	if cres.Latest == nil {
+		if *heartbeatTag != "" && cres.Count == 0 && len(snapshots) > 0 && !repo.filtersSnapshots() {
+			return done(cres.Status, fmt.Sprintf("only heartbeat snapshots tagged '%s' found", *heartbeatTag))
+		}
+		return done(cres.Status, cres.Message)
+	}
+	latest := cres.Latest
+	res.Oldest = cres.Oldest
+	res.LatestID = latest.ID
+	res.Latest = cres.LatestTime
+	res.Age = cres.Age
+	if len(latest.Paths) > 0 {
+		res.LatestHost, res.LatestTags, res.LatestPaths = latest.Hostname, latest.Tags, latest.Paths
+	}
+	if cres.Age < 0 {
+		return done(cres.Status, cres.Message)
+	}
+	msg := cres.Message + snapshotDetails(latest)
+	if *showHeadroom && cres.Status == OK && repo.Warning > 0 {
+		res.Headroom = repo.Warning - res.Age
+		msg += fmt.Sprintf(" (%s until WARNING)", res.Headroom.Round(agePrecision))
+	}
+
+	// every check reports on its own, so that one which cannot be performed
+	// does not hide the results of the others
+	checks := []subResult{{Name: "age", Status: cres.Status, Message: msg}}
+
+	if backupSchedule != nil {
+		checks = append(checks, checkSchedule(&res, res.Latest, time.Now()))
+	}
+
+	if *cadenceWindow > 0 {
+		checks = append(checks, checkCadence(&res, cres.Snapshots))
+	}
+
+	if *heartbeatTag != "" {
+		hbStatus, hbMsg := checkHeartbeat(snapshots)
+		checks = append(checks, subResult{Name: "heartbeat", Status: hbStatus, Message: hbMsg})
+	}
+
+	if *requireOlderThan > 0 {
+		oldest := time.Now().Sub(res.Oldest)
+		if oldest <= *requireOlderThan {
+			checks = append(checks, subResult{Name: "retention", Status: CRITICAL,
+				Message: fmt.Sprintf("oldest snapshot created %s ago, expected one older than %s", oldest.Round(agePrecision), *requireOlderThan)})
+		} else {
+			checks = append(checks, subResult{Name: "retention"})
+		}
+	}
+
+	if *maxOldest > 0 {
+		oldest := time.Now().Sub(res.Oldest)
+		if oldest > *maxOldest {
+			checks = append(checks, subResult{Name: "max-oldest", Status: WARNING,
+				Message: fmt.Sprintf("oldest snapshot created %s ago, expected none older than %s, 'restic forget' may not be running", oldest.Round(agePrecision), *maxOldest)})
+		} else {
+			checks = append(checks, subResult{Name: "max-oldest"})
+		}
+	}
+
+	if checksRetention() {
+		checks = append(checks, checkRetention(cres.Snapshots))
+	}
+
+	if *minSnapshotsWarn > 0 || *minSnapshotsCrit > 0 {
+		sub := subResult{Name: "snapshot-count"}
+		if cres.Count < *minSnapshotsCrit {
+			sub.Status = CRITICAL
+			sub.Message = fmt.Sprintf("%d snapshots, expected at least %d", cres.Count, *minSnapshotsCrit)
+		} else if cres.Count < *minSnapshotsWarn {
+			sub.Status = WARNING
+			sub.Message = fmt.Sprintf("%d snapshots, expected at least %d", cres.Count, *minSnapshotsWarn)
+		}
+		checks = append(checks, sub)
+	}
+
+	if *checkLocks {
+		checks = append(checks, c.checkLocks(&res))
+	}
+
+	if *indexLagWarning > 0 {
+		checks = append(checks, c.checkIndex())
+	}
+
+	if *integrityPath != "" {
+		checks = append(checks, c.checkIntegrity(&res))
+	}
+
+	if *warnEmptySnapshot {
+		empty, err := c.snapshotIsEmpty(latest.ID)
+		if err != nil {
+			checks = append(checks, failed("empty-snapshot", err))
+		} else if empty {
+			checks = append(checks, subResult{Name: "empty-snapshot", Status: WARNING, Message: "latest snapshot does not contain any files"})
+		} else {
+			checks = append(checks, subResult{Name: "empty-snapshot"})
+		}
+	}
+
+	if *expectRepoVersion > 0 {
+		cfg, err := c.config()
+		if err != nil {
+			checks = append(checks, failed("repo-version", err))
+		} else {
+			res.RepoVersion = cfg.Version
+			sub := subResult{Name: "repo-version", Message: fmt.Sprintf("repository version %d", cfg.Version)}
+			if cfg.Version != *expectRepoVersion {
+				sub.Status = WARNING
+				sub.Message += fmt.Sprintf(" (expected %d)", *expectRepoVersion)
+			}
+			checks = append(checks, sub)
+		}
+	}
+
+	if *detectReinit {
+		cfg, err := c.config()
+		if err != nil {
+			checks = append(checks, failed("repo-id", err))
+		} else {
+			// compared with the previous run once the state is at hand
+			res.Identity = &repoIdentity{ID: cfg.ID, ChunkerPolynomial: cfg.ChunkerPolynomial}
+			checks = append(checks, subResult{Name: "repo-id"})
+		}
+	}
+
+	if repo.checksHosts() {
+		hostStatus, violations, err := c.checkHosts()
+		if err != nil {
+			checks = append(checks, failed("hosts", err))
+		} else {
+			checks = append(checks, subResult{Name: "hosts", Status: hostStatus, Message: strings.Join(violations, "; ")})
+		}
+	}
+
+	if len(requiredPaths) > 0 {
+		pathStatus, violations, err := c.checkRequiredPaths()
+		if err != nil {
+			checks = append(checks, failed("paths", err))
+		} else {
+			checks = append(checks, subResult{Name: "paths", Status: pathStatus, Message: strings.Join(violations, "; ")})
+		}
+	}
+
+	if *groupBy == "host" {
+		hosts, err := c.summarizeHosts()
+		if err != nil {
+			checks = append(checks, failed("group-by", err))
+		} else {
+			res.PerHost = hosts
+			checks = append(checks, subResult{Name: "group-by"})
+		}
+	}
+
+	if *checkWritable {
+		if problem := c.probeWritable(); problem != "" {
+			checks = append(checks, subResult{Name: "writable", Status: CRITICAL, Message: problem})
+		} else {
+			checks = append(checks, subResult{Name: "writable"})
+		}
+	}
+
+	if checksFreeSpace() {
+		space, err := c.queryFreeSpace()
+		if err != nil {
+			checks = append(checks, failed("free-space", err))
+		} else {
+			res.FreeSpace = &space
+			checks = append(checks, checkFreeSpace(space))
+		}
+	}
+
+	if measuresSize() {
+		size, sampled, err := c.estimateDataSize(*sizeSamplePct)
+		if err != nil {
+			checks = append(checks, failed("size", err))
+		} else {
+			res.DataSize, res.DataSizeSampled = size, sampled
+			checks = append(checks, checkSize(size, sampled))
+		}
+	}
+
+	if checksFuture() {
+		futureStatus, futureMsg, err := c.checkFutureSnapshots(time.Now())
+		if err != nil {
+			checks = append(checks, failed("future", err))
+		} else {
+			checks = append(checks, subResult{Name: "future", Status: futureStatus, Message: futureMsg})
+		}
+	}
+
+	if *timeDrift > 0 {
+		drifted, driftMsg, err := c.checkTimeDrift()
+		if err != nil {
+			checks = append(checks, failed("time-drift", err))
+		} else if drifted {
+			checks = append(checks, subResult{Name: "time-drift", Status: WARNING, Message: driftMsg})
+		} else {
+			checks = append(checks, subResult{Name: "time-drift"})
+		}
+	}
+	res.Checks = checks
+	return done(aggregate(checks))
+}
+
+// shortID abbreviates a snapshot id the same way restic does.
+func shortID(id string) string {
+	return checkrestic.ShortID(id)
+}
+
+// snapshotIsEmpty decrypts the snapshot with the given id and reports whether
+// its tree lacks any files.
+func (c *repoCheck) snapshotIsEmpty(id string) (bool, error) {
+	r, err := c.open()
+	if err != nil {
+		return false, err
+	}
+	sn, err := r.Snapshot(id)
+	if err != nil {
+		return false, err
+	}
+	return r.TreeIsEmpty(sn.Tree)
+}
+
+// checkTimeDrift compares the modification time of every snapshot file with
+// the time recorded inside the snapshot. A median difference above the
+// 'warn-on-time-drift' threshold indicates that the files were copied in a way
+// that did not preserve their modification times, in which case the message
+// describes the drift.
+func (c *repoCheck) checkTimeDrift() (bool, string, error) {
+	snapshots, err := c.decoded()
+	if err != nil {
+		return false, "", err
+	}
+
+	drifts := make([]time.Duration, 0, len(snapshots))
+	for _, sn := range snapshots {
+		drift := sn.ModTime.Sub(sn.Time)
+		if drift < 0 {
+			drift = -drift
+		}
+		drifts = append(drifts, drift)
+	}
+	sort.Slice(drifts, func(a, b int) bool {
+		return drifts[a] < drifts[b]
+	})
+	median := drifts[len(drifts)/2]
+	if len(drifts)%2 == 0 {
+		median = (drifts[len(drifts)/2-1] + median) / 2
+	}
+
+	if median <= *timeDrift {
+		return false, "", nil
+	}
+	return true, fmt.Sprintf("snapshot file modification times differ from the snapshot times by up to %s (median %s), consider '-newest-by=snapshot-time'",
+		drifts[len(drifts)-1].Round(time.Second), median.Round(time.Second)), nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"check_restic/pkg/checkrestic"
+	"check_restic/pkg/checkrestic/checkrestictest"
+)
+
+const testPassword = "secret"
+
+// testRepository writes a repository with the snapshots to a temporary
+// directory and sets up checking it with the local backend.
+func testRepository(t *testing.T, snapshots ...checkrestictest.Snapshot) repository {
+	t.Helper()
+	r, err := checkrestictest.WriteRepo(t.TempDir(), testPassword, false, snapshots...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	savedStore, savedPassword, savedNewestBy := objectStore, password, newestBy
+	objectStore, password, newestBy = checkrestic.LocalFS{}, testPassword, "snapshot-time"
+	t.Cleanup(func() { objectStore, password, newestBy = savedStore, savedPassword, savedNewestBy })
+	return repository{Path: r.Path, Label: r.Path, Warning: 24 * time.Hour, Critical: 48 * time.Hour}
+}
+
+// setFlag sets a string option for the test.
+func setFlag(t *testing.T, p *string, v string) {
+	t.Helper()
+	saved := *p
+	*p = v
+	t.Cleanup(func() { *p = saved })
+}
+
+// assertNoLatest fails unless the machine-readable outputs omit the age and
+// time of the latest snapshot of the result.
+func assertNoLatest(t *testing.T, res result) {
+	t.Helper()
+	if res.hasLatest() {
+		t.Fatalf("result has a latest snapshot: %+v", res)
+	}
+	doc := jsonDocument([]result{res})
+	if r := doc.Repositories[0]; r.AgeSeconds != nil || r.LatestSnapshotTime != nil || r.LatestSnapshotID != "" {
+		t.Errorf("JSON reports a latest snapshot: %+v", r)
+	}
+	now := time.Now()
+	for name, out := range map[string]string{
+		"influx": formatInflux([]result{res}, now),
+		"sensu":  formatSensu([]result{res}, now),
+	} {
+		if strings.Contains(out, "age_seconds") {
+			t.Errorf("%s reports an age: %s", name, out)
+		}
+	}
+	if out := formatCSV([]result{res}); strings.Contains(out, "0001-01-01") {
+		t.Errorf("CSV reports a latest snapshot: %s", out)
+	}
+	if out := formatPrometheus([]result{res}, true, now, 0); strings.Contains(out, "restic_latest_snapshot_age_seconds{") {
+		t.Errorf("exporter reports a latest snapshot: %s", out)
+	}
+}
+
+func TestCheckNoSnapshotMatchesFilter(t *testing.T) {
+	repo := testRepository(t,
+		checkrestictest.Snapshot{Time: time.Now().Add(-time.Hour), Hostname: "web1"},
+		checkrestictest.Snapshot{Time: time.Now().Add(-2 * time.Hour), Hostname: "web1"},
+	)
+	saved := snapshotHosts
+	snapshotHosts = stringList{"db1"}
+	defer func() { snapshotHosts = saved }()
+
+	res := checkRepository(repo)
+	if res.Status != CRITICAL || !strings.Contains(res.Message, "no snapshots matching the filter found") {
+		t.Fatalf("got %s: %s", getStatusStr(res.Status), res.Message)
+	}
+	assertNoLatest(t, res)
+}
+
+func TestCheckOnlyHeartbeatSnapshots(t *testing.T) {
+	repo := testRepository(t,
+		checkrestictest.Snapshot{Time: time.Now().Add(-time.Minute), Hostname: "web1", Tags: []string{"heartbeat"}},
+		checkrestictest.Snapshot{Time: time.Now().Add(-6 * time.Minute), Hostname: "web1", Tags: []string{"heartbeat"}},
+	)
+	setFlag(t, heartbeatTag, "heartbeat")
+	savedWarn, savedCrit := *heartbeatWarning, *heartbeatCritical
+	*heartbeatWarning, *heartbeatCritical = 10*time.Minute, 20*time.Minute
+	defer func() { *heartbeatWarning, *heartbeatCritical = savedWarn, savedCrit }()
+
+	res := checkRepository(repo)
+	if res.Status != CRITICAL || !strings.Contains(res.Message, "only heartbeat snapshots tagged 'heartbeat' found") {
+		t.Fatalf("got %s: %s", getStatusStr(res.Status), res.Message)
+	}
+	assertNoLatest(t, res)
+}
+
+func TestCheckAgePrecision(t *testing.T) {
+	repo := testRepository(t, checkrestictest.Snapshot{Time: time.Now().Add(-100*time.Minute - 20*time.Second), Hostname: "web1"})
+	saved, savedHeadroom := agePrecision, *showHeadroom
+	defer func() { agePrecision, *showHeadroom = saved, savedHeadroom }()
+	*showHeadroom = true
+
+	tests := []struct {
+		precision time.Duration
+		want      string
+	}{
+		{time.Minute, "created 1h40m0s ago (22h20m0s until WARNING)"},
+		{time.Hour, "created 2h0m0s ago (22h0m0s until WARNING)"},
+	}
+	for _, tt := range tests {
+		agePrecision = tt.precision
+		res := checkRepository(repo)
+		if res.Status != OK || !strings.Contains(res.Message, tt.want) {
+			t.Errorf("precision %s: got %s: %s", tt.precision, getStatusStr(res.Status), res.Message)
+		}
+		if res.Age < 100*time.Minute {
+			t.Errorf("precision %s: the age %s was rounded", tt.precision, res.Age)
+		}
+	}
+}
+
+func TestCheckEmptyRepoStatus(t *testing.T) {
+	empty := testRepository(t)
+	invalid := testRepository(t)
+	if err := os.WriteFile(filepath.Join(invalid.Path, "snapshots", "upload.tmp"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	saved := emptyRepoStatus
+	defer func() { emptyRepoStatus = saved }()
+	for _, status := range []int{WARNING, CRITICAL} {
+		emptyRepoStatus = status
+		for repo, message := range map[*repository]string{&empty: "no snapshots found", &invalid: "no valid snapshot files found"} {
+			res := checkRepository(*repo)
+			if res.Status != status || !strings.Contains(res.Message, message) || res.Snapshots != 0 {
+				t.Errorf("on-empty-repo=%s: got %s: %s", getStatusStr(status), getStatusStr(res.Status), res.Message)
+			}
+		}
+	}
+}
+
+func TestCheckTimeout(t *testing.T) {
+	repo := testRepository(t, checkrestictest.Snapshot{Time: time.Now().Add(-time.Hour), Hostname: "web1"})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	saved := runCtx
+	runCtx = ctx
+	defer func() { runCtx = saved }()
+
+	res := checkRepository(repo)
+	if res.Status != UNKNOWN || !strings.Contains(res.Message, context.Canceled.Error()) {
+		t.Errorf("got %s: %s", getStatusStr(res.Status), res.Message)
+	}
+}
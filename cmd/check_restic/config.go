@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config describes the file passed via the 'config' option or one of the files
+// in the 'config-dir' directory. Global values act as defaults for every
+// repository, while Repositories maps a repository path to the settings
+// overriding those defaults for that repository only. Repository is a shorthand
+// for a single repository using the global values only. The backend is shared
+// by all repositories, so it can only be set globally. HostThresholds lists
+// rules in the format of the 'host-threshold' option. Host is the ssh host
+// while Hosts are the hostnames of the snapshots to consider, like repeated
+// 'snapshot-host' options.
+type Config struct {
+	Repository   string                `yaml:"repository"`
+	Backend      string                `yaml:"backend"`
+	Warning      Duration              `yaml:"warning"`
+	Critical     Duration              `yaml:"critical"`
+	Host         string                `yaml:"host"`
+	User         string                `yaml:"user"`
+	Port         string                `yaml:"port"`
+	PasswordFile string                `yaml:"password-file"`
+	Tags         []string              `yaml:"tags"`
+	Hosts        []string              `yaml:"snapshot-hosts"`
+	Inactive     bool                  `yaml:"inactive"`
+	Repositories map[string]RepoConfig `yaml:"repositories"`
+
+	HostThresholds hostThresholds `yaml:"host-thresholds"`
+
+	path string
+}
+
+// RepoConfig holds the per-repository overrides of a Config. Zero values fall
+// back to the global defaults. Tags behave like repeated 'snapshot-tag'
+// options and Hosts like repeated 'snapshot-host' options, unlike Host, which
+// is the ssh host.
+type RepoConfig struct {
+	Warning      Duration `yaml:"warning"`
+	Critical     Duration `yaml:"critical"`
+	Host         string   `yaml:"host"`
+	User         string   `yaml:"user"`
+	Port         string   `yaml:"port"`
+	PasswordFile string   `yaml:"password-file"`
+	Tags         []string `yaml:"tags"`
+	Hosts        []string `yaml:"snapshot-hosts"`
+	Inactive     bool     `yaml:"inactive"`
+
+	HostThresholds hostThresholds `yaml:"host-thresholds"`
+}
+
+// Duration is a time.Duration that additionally accepts a 'd' suffix for days
+// when read from a config file, e.g. "8d" or "1d12h".
+type Duration time.Duration
+
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	v, err := parseDuration(value.Value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// parseDuration behaves like time.ParseDuration but also understands a leading
+// number of days.
+func parseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if i := strings.Index(s, "d"); i > 0 {
+		days, err := strconv.Atoi(s[:i])
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		rest := time.Duration(0)
+		if s[i+1:] != "" {
+			rest, err = time.ParseDuration(s[i+1:])
+			if err != nil {
+				return 0, fmt.Errorf("invalid duration %q", s)
+			}
+		}
+		return time.Duration(days)*24*time.Hour + rest, nil
+	}
+	return time.ParseDuration(s)
+}
+
+func loadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	// reject misspelled keys rather than silently ignoring them
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	cfg.path = path
+	return &cfg, nil
+}
+
+// loadConfigDir loads every YAML file in dir, skipping hidden files, and
+// returns the repositories of all of them in the order of their file names.
+func loadConfigDir(dir string, def repository, set map[string]bool) ([]repository, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var repos []repository
+	for _, entry := range entries {
+		name := entry.Name()
+		ext := filepath.Ext(name)
+		if strings.HasPrefix(name, ".") || entry.IsDir() || (ext != ".yaml" && ext != ".yml") {
+			continue
+		}
+		cfg, err := loadConfig(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		cfgRepos, err := cfg.repositories(def, set)
+		if err != nil {
+			return nil, err
+		}
+		repos = append(repos, cfgRepos...)
+	}
+	return repos, nil
+}
+
+// repositories returns the effective settings of every repository in the
+// config, sorted by path. The global values of the config replace those of def
+// unless the corresponding option was explicitly given on the command line,
+// which is reported by set. The backend of the config replaces the 'backend'
+// option in the same way.
+func (cfg *Config) repositories(def repository, set map[string]bool) ([]repository, error) {
+	if err := cfg.useBackend(set); err != nil {
+		return nil, err
+	}
+	if cfg.Warning != 0 && !set["warning"] {
+		def.Warning = time.Duration(cfg.Warning)
+	}
+	if cfg.Critical != 0 && !set["critical"] {
+		def.Critical = time.Duration(cfg.Critical)
+	}
+	if cfg.Host != "" && !set["host"] {
+		def.Host = cfg.Host
+	}
+	if cfg.User != "" && !set["user"] {
+		def.User = cfg.User
+	}
+	if cfg.Port != "" && !set["port"] {
+		def.Port = cfg.Port
+	}
+	if cfg.PasswordFile != "" && !set["password-file"] && !set["password-command"] {
+		def.PasswordFile = cfg.PasswordFile
+	}
+	if len(cfg.Tags) > 0 && !set["snapshot-tag"] {
+		def.Tags = cfg.Tags
+	}
+	if len(cfg.Hosts) > 0 && !set["snapshot-host"] {
+		def.Hosts = cfg.Hosts
+	}
+	if len(cfg.HostThresholds) > 0 && !set["host-threshold"] {
+		def.HostThresholds = cfg.HostThresholds
+	}
+	if cfg.Inactive {
+		def.Inactive = true
+	}
+
+	repos := make([]repository, 0, len(cfg.Repositories)+1)
+	if cfg.Repository != "" {
+		if _, ok := cfg.Repositories[cfg.Repository]; !ok {
+			repo := def
+			repo.Path = cfg.Repository
+			repos = append(repos, repo)
+		}
+	}
+	for path, rc := range cfg.Repositories {
+		if path == "" {
+			return nil, fmt.Errorf("%s: empty repository path", cfg.path)
+		}
+		repo := def
+		repo.Path = path
+		if rc.Warning != 0 {
+			repo.Warning = time.Duration(rc.Warning)
+		}
+		if rc.Critical != 0 {
+			repo.Critical = time.Duration(rc.Critical)
+		}
+		if rc.Host != "" {
+			repo.Host = rc.Host
+		}
+		if rc.User != "" {
+			repo.User = rc.User
+		}
+		if rc.Port != "" {
+			repo.Port = rc.Port
+		}
+		if rc.PasswordFile != "" {
+			repo.PasswordFile = rc.PasswordFile
+		}
+		if len(rc.Tags) > 0 {
+			repo.Tags = rc.Tags
+		}
+		if len(rc.Hosts) > 0 {
+			repo.Hosts = rc.Hosts
+		}
+		if len(rc.HostThresholds) > 0 {
+			repo.HostThresholds = rc.HostThresholds
+		}
+		if rc.Inactive {
+			repo.Inactive = true
+		}
+		repos = append(repos, repo)
+	}
+	sort.Slice(repos, func(a, b int) bool {
+		return repos[a].Path < repos[b].Path
+	})
+	return repos, nil
+}
+
+// configBackend is the backend set by the config, if any.
+var configBackend string
+
+// useBackend replaces the 'backend' option by the backend of the config,
+// unless it was given on the command line. All files need to agree on it.
+func (cfg *Config) useBackend(set map[string]bool) error {
+	if cfg.Backend == "" || set["backend"] {
+		return nil
+	}
+	if configBackend != "" && configBackend != cfg.Backend {
+		return fmt.Errorf("%s: the backend %q differs from %q set by another file, all repositories need to use the same backend", cfg.path, cfg.Backend, configBackend)
+	}
+	configBackend = cfg.Backend
+	*backendName = cfg.Backend
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"check_restic/pkg/checkrestic/checkrestictest"
+)
+
+func TestConfigSnapshotHosts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	data := `host: backup.example.com
+snapshot-hosts: [web1, web2]
+repositories:
+  /srv/restic/web: {}
+  /srv/restic/db:
+    host: db-backup.example.com
+    snapshot-hosts: [db1]
+`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		set  map[string]bool
+		want map[string]string
+	}{
+		{nil, map[string]string{"/srv/restic/db": "db1", "/srv/restic/web": "web1 web2"}},
+		// the options replace the global snapshot hosts only
+		{map[string]bool{"snapshot-host": true}, map[string]string{"/srv/restic/db": "db1", "/srv/restic/web": ""}},
+	}
+	for _, tt := range tests {
+		repos, err := cfg.repositories(repository{}, tt.set)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, repo := range repos {
+			if got := strings.Join(repo.Hosts, " "); got != tt.want[repo.Path] {
+				t.Errorf("set %v: %s has snapshot hosts %q, want %q", tt.set, repo.Path, got, tt.want[repo.Path])
+			}
+			if repo.Host == "" {
+				t.Errorf("set %v: %s has no ssh host", tt.set, repo.Path)
+			}
+		}
+	}
+}
+
+func TestCheckConfigSnapshotHosts(t *testing.T) {
+	repo := testRepository(t,
+		checkrestictest.Snapshot{Time: time.Now().Add(-time.Hour), Hostname: "web1"},
+		checkrestictest.Snapshot{Time: time.Now().Add(-30 * time.Hour), Hostname: "db1"},
+	)
+	saved := snapshotHosts
+	snapshotHosts = stringList{"web1"}
+	defer func() { snapshotHosts = saved }()
+
+	if res := checkRepository(repo); res.Status != OK {
+		t.Errorf("with the options: got %s: %s", getStatusStr(res.Status), res.Message)
+	}
+	repo.Hosts = []string{"db1"}
+	if res := checkRepository(repo); res.Status != WARNING {
+		t.Errorf("with the config: got %s: %s", getStatusStr(res.Status), res.Message)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// submitter pushes the result of a repository as a passive check result of
+// the service on the host.
+type submitter func(host, service string, res result) error
+
+// runDaemon checks the repositories every 'daemon-interval' and submits the
+// results as passive checks, forever. Unlike between separate runs, the ssh
+// connections and rclone processes are kept open from one round to the next,
+// the pool replaces those which died in the meantime.
+func runDaemon() (int, string) {
+	submit, err := newSubmitter()
+	if err != nil {
+		return UNKNOWN, formatError(*output, err.Error())
+	}
+	ticker := time.NewTicker(*daemonInterval)
+	defer ticker.Stop()
+	for {
+		daemonRound(submit)
+		<-ticker.C
+	}
+}
+
+// daemonRound checks all repositories once and submits their results. The
+// 'timeout' cancels everything else of a round which takes too long and
+// closes its connections, which makes the checks waiting on them fail.
+func daemonRound(submit submitter) {
+	// the machine given by 'only-if-reachable' may have come online since
+	sourceProbe = sync.Once{}
+	if *timeout > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		defer cancel()
+		runCtx = ctx
+		timer := time.AfterFunc(*timeout, func() {
+			verbosef("round timed out after %s, closing the connections", *timeout)
+			connections.close()
+			rclone.close()
+		})
+		defer timer.Stop()
+	}
+
+	start := time.Now()
+	_, results, err := checkAll(checkRepository, start)
+	if err != nil {
+		verbosef("%s", err)
+		return
+	}
+	if *statusFile != "" {
+		if err := writeStatusFile(*statusFile, results, start); err != nil {
+			verbosef("unable to write status file: %s", err)
+		}
+	}
+	for i, res := range results {
+		// the names are taken from the repository as configured, since the
+		// result may be redacted
+		host, service := submitNames(repos[i])
+		if err := submit(host, service, res); err != nil {
+			verbosef("unable to submit the result of %s to %s: %s", service, host, err)
+			continue
+		}
+		verbosef("submitted %s for %s on %s", getStatusStr(res.Status), service, host)
+	}
+}
+
+// submitNames returns the host and service the result of the repository is
+// submitted for.
+func submitNames(repo repository) (string, string) {
+	host := *submitHost
+	if host == "" {
+		host = repo.Host
+	}
+	if host == "" {
+		host, _ = os.Hostname()
+	}
+	return host, strings.ReplaceAll(*submitService, "{label}", repo.Label)
+}
+
+// newSubmitter returns the submitter of 'submit'.
+func newSubmitter() (submitter, error) {
+	client, transport := newHTTPClient(30 * time.Second)
+	if *submitCAFile != "" {
+		pem, err := os.ReadFile(*submitCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read the CA file: %s", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("The option 'submit-ca-file' needs to name a file of PEM encoded certificates.")
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	secret := ""
+	if *submitPasswordFile != "" {
+		data, err := os.ReadFile(*submitPasswordFile)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read the submit password file: %s", err)
+		}
+		secret = strings.TrimSpace(string(data))
+	}
+	base := strings.TrimSuffix(*submitURL, "/")
+
+	switch *submitTo {
+	case "icinga2":
+		// the result turns stale if the daemon stops submitting
+		ttl := int(2*daemonInterval.Seconds() + timeout.Seconds())
+		return func(host, service string, res result) error {
+			body, err := json.Marshal(map[string]interface{}{
+				"type":             "Service",
+				"service":          host + "!" + service,
+				"exit_status":      res.Status,
+				"plugin_output":    res.Message,
+				"performance_data": perfdataList([]result{res}),
+				"check_source":     submitSource(),
+				"ttl":              ttl,
+			})
+			if err != nil {
+				return err
+			}
+			req, err := http.NewRequest(http.MethodPost, base+"/v1/actions/process-check-result", bytes.NewReader(body))
+			if err != nil {
+				return err
+			}
+			req.Header.Set("Accept", "application/json")
+			req.Header.Set("Content-Type", "application/json")
+			req.SetBasicAuth(*submitUser, secret)
+			return submitRequest(client, req)
+		}, nil
+	case "nrdp":
+		return func(host, service string, res result) error {
+			out := res.Message
+			if perf := perfdata([]result{res}); perf != "" {
+				out += " | " + perf
+			}
+			type checkResult struct {
+				CheckResult struct {
+					Type      string `json:"type"`
+					CheckType string `json:"checktype"`
+				} `json:"checkresult"`
+				Hostname    string `json:"hostname"`
+				Servicename string `json:"servicename"`
+				State       string `json:"state"`
+				Output      string `json:"output"`
+			}
+			var cr checkResult
+			// checktype 1 marks a passive check
+			cr.CheckResult.Type, cr.CheckResult.CheckType = "service", "1"
+			cr.Hostname, cr.Servicename, cr.State, cr.Output = host, service, strconv.Itoa(res.Status), out
+			data, err := json.Marshal(map[string][]checkResult{"checkresults": {cr}})
+			if err != nil {
+				return err
+			}
+			form := url.Values{"token": {secret}, "cmd": {"submitcheck"}, "JSONDATA": {string(data)}}
+			req, err := http.NewRequest(http.MethodPost, base+"/", strings.NewReader(form.Encode()))
+			if err != nil {
+				return err
+			}
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			return submitRequest(client, req)
+		}, nil
+	}
+	return nil, fmt.Errorf("The option 'submit' needs to be one of 'icinga2' or 'nrdp'.")
+}
+
+// submitSource returns the name of this machine as the source of the check
+// results.
+func submitSource() string {
+	name, _ := os.Hostname()
+	return name
+}
+
+// submitRequest sends the request and returns the error the endpoint
+// responded with, if any.
+func submitRequest(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode >= 300 {
+		if msg := strings.TrimSpace(string(body)); msg != "" {
+			return fmt.Errorf("%s: %s", resp.Status, msg)
+		}
+		return fmt.Errorf("%s", resp.Status)
+	}
+	// NRDP reports failures with a successful status
+	var nrdp struct {
+		Result struct {
+			Status  int    `json:"status"`
+			Message string `json:"message"`
+		} `json:"result"`
+	}
+	if json.Unmarshal(body, &nrdp) == nil && nrdp.Result.Status < 0 {
+		return fmt.Errorf("%s", nrdp.Result.Message)
+	}
+	return nil
+}
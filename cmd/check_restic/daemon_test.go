@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"check_restic/pkg/checkrestic/checkrestictest"
+)
+
+func TestDaemonRoundTimeout(t *testing.T) {
+	repo := testRepository(t, checkrestictest.Snapshot{Time: time.Now().Add(-time.Hour), Hostname: "web1"})
+	savedRepos, savedTimeout, savedCtx := repos, *timeout, runCtx
+	defer func() { repos, *timeout, runCtx = savedRepos, savedTimeout, savedCtx }()
+	repos = []repository{repo}
+	// expired before decoding the snapshots
+	*timeout = time.Nanosecond
+
+	var submitted []result
+	daemonRound(func(host, service string, res result) error {
+		submitted = append(submitted, res)
+		return nil
+	})
+	if len(submitted) != 1 || submitted[0].Status != UNKNOWN || !strings.Contains(submitted[0].Message, context.DeadlineExceeded.Error()) {
+		t.Errorf("submitted %+v", submitted)
+	}
+
+	// the next round starts over
+	*timeout = time.Minute
+	submitted = nil
+	daemonRound(func(host, service string, res result) error {
+		submitted = append(submitted, res)
+		return nil
+	})
+	if len(submitted) != 1 || submitted[0].Status != OK {
+		t.Errorf("submitted %+v", submitted)
+	}
+	if runCtx.Err() == nil {
+		t.Error("the context of the round was not canceled after it")
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"check_restic/pkg/checkrestic"
+)
+
+// verbosity is the level of the 'verbose' option. Like a boolean option it
+// may be given without a value, which means 1.
+type verbosity int
+
+func (v *verbosity) String() string {
+	return strconv.Itoa(int(*v))
+}
+
+func (v *verbosity) Set(s string) error {
+	switch s {
+	case "true":
+		*v = 1
+	case "false":
+		*v = 0
+	default:
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return errors.New("expected a level of 0 or more")
+		}
+		*v = verbosity(n)
+	}
+	return nil
+}
+
+func (v *verbosity) IsBoolFlag() bool {
+	return true
+}
+
+var verbose verbosity
+
+// snapshotDetails describes the latest snapshot for the message, depending
+// on the 'verbose' level: its host and tags from level 1 and its paths from
+// level 2. It is empty unless the snapshot was decoded.
+func snapshotDetails(s *checkrestic.Snapshot) string {
+	if verbose == 0 || len(s.Paths) == 0 {
+		return ""
+	}
+	var details []string
+	if s.Hostname != "" {
+		details = append(details, "host "+s.Hostname)
+	}
+	if len(s.Tags) > 0 {
+		details = append(details, "tags "+strings.Join(s.Tags, ","))
+	}
+	if verbose >= 2 {
+		details = append(details, "paths "+strings.Join(s.Paths, ", "))
+	}
+	if len(details) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (%s)", strings.Join(details, "; "))
+}
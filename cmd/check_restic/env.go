@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// envPrefix starts the names of the environment variables setting the
+// options, e.g. CHECK_RESTIC_HOST for 'host'. They keep credentials and
+// hostnames out of the process list and the command definitions of the
+// monitoring system.
+const envPrefix = "CHECK_RESTIC_"
+
+// envVar returns the name of the environment variable of the option.
+func envVar(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// fromEnv records the options taken from the environment by applyEnv.
+var fromEnv = make(map[string]bool)
+
+// applyEnv sets every option which was not given on the command line from
+// its environment variable, if that is set and not empty. Repeatable options
+// take a single value this way. Once set, the options count as given, e.g.
+// for overriding the global values of the config.
+func applyEnv() error {
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	var err error
+	flag.VisitAll(func(f *flag.Flag) {
+		value := os.Getenv(envVar(f.Name))
+		if err != nil || given[f.Name] || value == "" {
+			return
+		}
+		if setErr := flag.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("The environment variable '%s' has the invalid value %q: %s", envVar(f.Name), value, setErr)
+			return
+		}
+		fromEnv[f.Name] = true
+	})
+	return err
+}
+
+func init() {
+	flag.Usage = func() {
+		out := flag.CommandLine.Output()
+		fmt.Fprintf(out, "Usage of %s:\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprintf(out, "\nEvery option may also be set via an environment variable, e.g. %s for 'host' or %s for 'warning'; the command line takes precedence.\n", envVar("host"), envVar("warning"))
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// exporter serves the results of the latest round of checks as Prometheus
+// metrics.
+type exporter struct {
+	mu      sync.Mutex
+	metrics string
+}
+
+func (e *exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	metrics := e.metrics
+	e.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	fmt.Fprint(w, metrics)
+}
+
+// runExporter checks the repositories every 'listen-interval' and serves the
+// results on /metrics of 'listen' until the server fails.
+func runExporter() (int, string) {
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return UNKNOWN, formatError(*output, fmt.Sprintf("unable to listen: %s", err))
+	}
+	e := &exporter{}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", e)
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	failed := make(chan error, 1)
+	go func() { failed <- server.Serve(ln) }()
+
+	ticker := time.NewTicker(*listenInterval)
+	defer ticker.Stop()
+	for {
+		e.round()
+		select {
+		case err := <-failed:
+			return UNKNOWN, formatError(*output, fmt.Sprintf("unable to serve metrics: %s", err))
+		case <-ticker.C:
+		}
+	}
+}
+
+// round checks all repositories once and replaces the metrics.
+func (e *exporter) round() {
+	defer connections.close()
+	defer rclone.close()
+	// the machine given by 'only-if-reachable' may have come online since
+	sourceProbe = sync.Once{}
+	if *timeout > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		defer cancel()
+		runCtx = ctx
+	}
+
+	start := time.Now()
+	_, results, err := checkAll(checkRepository, start)
+	if err != nil {
+		verbosef("%s", err)
+		results = nil
+	} else if *statusFile != "" {
+		if err := writeStatusFile(*statusFile, results, start); err != nil {
+			verbosef("unable to write status file: %s", err)
+		}
+	}
+	metrics := formatPrometheus(results, err == nil, start, time.Since(start))
+
+	e.mu.Lock()
+	e.metrics = metrics
+	e.mu.Unlock()
+}
+
+// prometheusLabelEscaper escapes label values in the text exposition format.
+var prometheusLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatPrometheus renders the results in the Prometheus text exposition
+// format, along with when and how long the round ran. Metrics which are
+// unknown for a repository are left out for it.
+func formatPrometheus(results []result, ok bool, start time.Time, took time.Duration) string {
+	type metric struct {
+		name, help, kind string
+		value            func(res result) (float64, bool)
+	}
+	metrics := []metric{
+		{"restic_check_success", "Whether the check of the repository returned OK.", "gauge", func(res result) (float64, bool) {
+			if res.Status == OK {
+				return 1, true
+			}
+			return 0, true
+		}},
+		{"restic_check_status", "Status of the check, 0 is OK, 1 WARNING, 2 CRITICAL and 3 UNKNOWN.", "gauge", func(res result) (float64, bool) {
+			return float64(res.Status), true
+		}},
+		{"restic_snapshot_count", "Number of snapshots in the repository.", "gauge", func(res result) (float64, bool) {
+			return float64(res.Snapshots), res.Snapshots >= 0
+		}},
+		{"restic_latest_snapshot_timestamp_seconds", "Time of the latest snapshot.", "gauge", func(res result) (float64, bool) {
+			return float64(res.Latest.Unix()), res.hasLatest()
+		}},
+		{"restic_latest_snapshot_age_seconds", "Age of the latest snapshot.", "gauge", func(res result) (float64, bool) {
+			return res.Age.Seconds(), res.hasLatest()
+		}},
+		{"restic_data_size_bytes", "Estimated size of the repository data.", "gauge", func(res result) (float64, bool) {
+			return float64(res.DataSize), res.DataSizeSampled > 0
+		}},
+		{"restic_free_space_bytes", "Space available on the filesystem holding the repository.", "gauge", func(res result) (float64, bool) {
+			if res.FreeSpace == nil {
+				return 0, false
+			}
+			return float64(res.FreeSpace.Available), true
+		}},
+		{"restic_lock_count", "Number of locks in the repository.", "gauge", func(res result) (float64, bool) {
+			if res.Locks == nil {
+				return 0, false
+			}
+			return float64(res.Locks.Count), true
+		}},
+		{"restic_stale_lock_count", "Number of stale locks in the repository.", "gauge", func(res result) (float64, bool) {
+			if res.Locks == nil {
+				return 0, false
+			}
+			return float64(res.Locks.Stale), true
+		}},
+	}
+
+	var b strings.Builder
+	for _, m := range metrics {
+		header := false
+		for _, res := range results {
+			v, known := m.value(res)
+			if !known {
+				continue
+			}
+			if !header {
+				fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+				header = true
+			}
+			fmt.Fprintf(&b, "%s{repository=\"%s\",host=\"%s\"} %s\n", m.name,
+				prometheusLabelEscaper.Replace(res.Repo.Path), prometheusLabelEscaper.Replace(res.Repo.Host), strconv.FormatFloat(v, 'f', -1, 64))
+		}
+	}
+
+	success := 0
+	if ok {
+		success = 1
+	}
+	fmt.Fprintf(&b, "# HELP restic_exporter_round_success Whether the state could be loaded for the latest round of checks.\n# TYPE restic_exporter_round_success gauge\nrestic_exporter_round_success %d\n", success)
+	fmt.Fprintf(&b, "# HELP restic_exporter_round_timestamp_seconds Time the latest round of checks started.\n# TYPE restic_exporter_round_timestamp_seconds gauge\nrestic_exporter_round_timestamp_seconds %d\n", start.Unix())
+	fmt.Fprintf(&b, "# HELP restic_exporter_round_duration_seconds Duration of the latest round of checks.\n# TYPE restic_exporter_round_duration_seconds gauge\nrestic_exporter_round_duration_seconds %s\n", strconv.FormatFloat(took.Seconds(), 'f', -1, 64))
+	return b.String()
+}
@@ -0,0 +1,55 @@
+package main
+
+import "strings"
+
+// stringList implements flag.Value for repeatable options taking any string.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, " ")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// snapshotHosts, snapshotTags and snapshotPaths hold the values of the
+// 'snapshot-host', 'snapshot-tag' and 'snapshot-path' options, which restrict
+// the snapshots considered for the age of the latest one.
+var snapshotHosts, snapshotTags, snapshotPaths stringList
+
+// filtersSnapshots reports whether any of the snapshot filters is set for any
+// of the repositories.
+func filtersSnapshots() bool {
+	for _, repo := range repos {
+		if repo.filtersSnapshots() {
+			return true
+		}
+	}
+	return false
+}
+
+// filtersSnapshots reports whether any of the snapshot filters is set for the
+// repository.
+func (repo repository) filtersSnapshots() bool {
+	return len(repo.hostFilter()) > 0 || len(repo.tagFilter()) > 0 || len(snapshotPaths) > 0
+}
+
+// tagFilter returns the tags of the config for the repository, or else those
+// of the 'snapshot-tag' options.
+func (repo repository) tagFilter() []string {
+	if len(repo.Tags) > 0 {
+		return repo.Tags
+	}
+	return snapshotTags
+}
+
+// hostFilter returns the snapshot hosts of the config for the repository, or
+// else those of the 'snapshot-host' options.
+func (repo repository) hostFilter() []string {
+	if len(repo.Hosts) > 0 {
+		return repo.Hosts
+	}
+	return snapshotHosts
+}
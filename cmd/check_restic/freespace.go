@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// freeSpace is the space of the filesystem holding the repository, in bytes.
+type freeSpace struct {
+	Available int64 `json:"available"`
+	Total     int64 `json:"total"`
+}
+
+// freeLimit is the threshold of 'free-warning' or 'free-critical', either a
+// number of bytes or a percentage of the size of the filesystem. The zero
+// value is not set.
+type freeLimit struct {
+	Bytes   int64
+	Percent float64
+}
+
+// freeWarning and freeCritical are the parsed 'free-warning' and
+// 'free-critical' options.
+var freeWarning, freeCritical freeLimit
+
+// parseFreeLimit parses a size like '50G' or a percentage like '10%'.
+func parseFreeLimit(s string) (freeLimit, error) {
+	if strings.HasSuffix(s, "%") {
+		pct, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(s, "%")), 64)
+		if err != nil || pct <= 0 || pct >= 100 {
+			return freeLimit{}, fmt.Errorf("invalid percentage %q", s)
+		}
+		return freeLimit{Percent: pct}, nil
+	}
+	size, err := parseSize(s)
+	if err != nil || size <= 0 {
+		return freeLimit{}, fmt.Errorf("invalid size %q", s)
+	}
+	return freeLimit{Bytes: size}, nil
+}
+
+// bytes returns the limit in bytes for a filesystem of the given size, 0 if
+// it is not set.
+func (l freeLimit) bytes(total int64) int64 {
+	if l.Percent > 0 {
+		return int64(l.Percent * float64(total) / 100)
+	}
+	return l.Bytes
+}
+
+// String formats the limit as it was given.
+func (l freeLimit) String() string {
+	if l.Percent > 0 {
+		return strconv.FormatFloat(l.Percent, 'f', -1, 64) + "%"
+	}
+	return formatBytes(l.Bytes)
+}
+
+// queryFreeSpace asks the server for the space of the filesystem holding the
+// repository. This needs the statvfs@openssh.com extension, which OpenSSH
+// and most other servers support.
+func (c *repoCheck) queryFreeSpace() (freeSpace, error) {
+	st, err := c.client.StatVFS(c.repo.Path)
+	if err != nil {
+		return freeSpace{}, fmt.Errorf("unable to query the free space: %s", err)
+	}
+	// the blocks reserved for root are not available to the backups
+	return freeSpace{Available: int64(st.Bavail * st.Frsize), Total: int64(st.TotalSpace())}, nil
+}
+
+// checkFreeSpace compares the available space with 'free-warning' and
+// 'free-critical', since the next backups fail once the disk is full.
+func checkFreeSpace(space freeSpace) subResult {
+	sub := subResult{Name: "free-space", Message: fmt.Sprintf("%s free", formatBytes(space.Available))}
+	if space.Total > 0 {
+		sub.Message = fmt.Sprintf("%s of %s free (%.1f%%)", formatBytes(space.Available), formatBytes(space.Total), float64(space.Available)*100/float64(space.Total))
+	}
+	if limit := freeCritical.bytes(space.Total); limit > 0 && space.Available < limit {
+		sub.Status = CRITICAL
+		sub.Message += fmt.Sprintf(", expected at least %s", freeCritical)
+	} else if limit := freeWarning.bytes(space.Total); limit > 0 && space.Available < limit {
+		sub.Status = WARNING
+		sub.Message += fmt.Sprintf(", expected at least %s", freeWarning)
+	}
+	return sub
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// checkFutureSnapshots counts the snapshots whose recorded time is ahead of
+// the local clock by more than 'clock-skew'. While a single one may be caused
+// by transient skew, several of them point to a client with a wrong clock, so
+// the hosts which created them are named.
+func (c *repoCheck) checkFutureSnapshots(now time.Time) (int, string, error) {
+	snapshots, err := c.decoded()
+	if err != nil {
+		return UNKNOWN, "", err
+	}
+
+	count := 0
+	seen := make(map[string]bool)
+	var hosts []string
+	for _, sn := range snapshots {
+		if sn.Time.Sub(now) <= *clockSkew {
+			continue
+		}
+		count++
+		if sn.Hostname != "" && !seen[sn.Hostname] {
+			seen[sn.Hostname] = true
+			hosts = append(hosts, sn.Hostname)
+		}
+	}
+
+	status := OK
+	if *criticalFutureCount > 0 && count >= *criticalFutureCount {
+		status = CRITICAL
+	} else if *warnFutureCount > 0 && count >= *warnFutureCount {
+		status = WARNING
+	}
+	if status == OK {
+		return OK, "", nil
+	}
+	msg := fmt.Sprintf("%d snapshots have future timestamps, check client clocks", count)
+	if len(hosts) > 0 {
+		sort.Strings(hosts)
+		msg += fmt.Sprintf(" (%s)", strings.Join(hosts, ", "))
+	}
+	return status, msg, nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+
+	"check_restic/pkg/checkrestic"
+)
+
+// checkHeartbeat evaluates the latest snapshot tagged 'heartbeat-tag' against
+// the heartbeat thresholds. Such snapshots of a tiny backup made every few
+// minutes show that the client is alive independently of the real backups,
+// which may run only once a day. The snapshots need to be decoded.
+func checkHeartbeat(snapshots []*checkrestic.Snapshot) (int, string) {
+	checker := checkrestic.Checker{
+		Thresholds:   checkrestic.Thresholds{Warning: *heartbeatWarning, Critical: *heartbeatCritical},
+		NewestBy:     newestBy,
+		AgePrecision: agePrecision,
+		Tags:         []string{*heartbeatTag},
+	}
+	res, err := checker.Check(runCtx, checkrestic.SnapshotList(snapshots))
+	if err != nil {
+		return UNKNOWN, fmt.Sprintf("unable to check the heartbeat: %s", err)
+	}
+	if res.Latest == nil {
+		return CRITICAL, fmt.Sprintf("no heartbeat snapshot tagged '%s' found", *heartbeatTag)
+	}
+	if res.Age < 0 {
+		return CRITICAL, "latest heartbeat snapshot is in the future"
+	}
+	return res.Status, fmt.Sprintf("heartbeat %s created %s ago", shortID(res.Latest.ID), res.Age.Round(agePrecision))
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+// runOnChange runs the 'on-change-command' for a repository whose status
+// changed. Its output is passed to stderr so it cannot garble the output of
+// the check, and failures are only logged since they must not affect the
+// result.
+func runOnChange(res result) {
+	cmd := exec.Command("sh", "-c", *onChangeCommand)
+	cmd.Env = append(os.Environ(),
+		"CHECK_RESTIC_STATUS="+getStatusStr(res.Status),
+		"CHECK_RESTIC_REPO="+res.Repo.Label,
+		"CHECK_RESTIC_MESSAGE="+res.Message,
+	)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		verbosef("on-change command for %s failed: %s", res.Repo.Path, err)
+		return
+	}
+	verbosef("ran on-change command for %s", res.Repo.Path)
+}
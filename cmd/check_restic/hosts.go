@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"check_restic/pkg/checkrestic"
+	"gopkg.in/yaml.v3"
+)
+
+// hostThreshold is the warning and critical threshold for the age of the
+// latest snapshot of a single host.
+type hostThreshold struct {
+	Warning  time.Duration
+	Critical time.Duration
+}
+
+// parseHostThreshold parses 'warning[,critical]'. A single duration is used
+// for both thresholds.
+func parseHostThreshold(s string) (hostThreshold, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) > 2 {
+		return hostThreshold{}, fmt.Errorf("invalid threshold %q", s)
+	}
+	var t hostThreshold
+	var err error
+	if t.Warning, err = parseDuration(parts[0]); err != nil {
+		return hostThreshold{}, err
+	}
+	t.Critical = t.Warning
+	if len(parts) == 2 {
+		if t.Critical, err = parseDuration(parts[1]); err != nil {
+			return hostThreshold{}, err
+		}
+	}
+	if t.Warning <= 0 || t.Critical < t.Warning {
+		return hostThreshold{}, fmt.Errorf("invalid threshold %q", s)
+	}
+	return t, nil
+}
+
+// hostRule is the threshold of the hosts matching Pattern, which is either a
+// hostname or a pattern as understood by path.Match, e.g. 'web*'.
+type hostRule struct {
+	Pattern string
+	hostThreshold
+}
+
+func (r hostRule) matches(host string) bool {
+	ok, _ := path.Match(r.Pattern, host)
+	return ok
+}
+
+// parseHostRule parses 'host=warning[,critical]' or
+// 'pattern:warning=2h,critical=6h'. The critical threshold defaults to the
+// warning one in both forms.
+func parseHostRule(s string) (hostRule, error) {
+	if i := strings.Index(s, ":"); i > 0 && !strings.Contains(s[:i], "=") {
+		r := hostRule{Pattern: s[:i]}
+		for _, kv := range strings.Split(s[i+1:], ",") {
+			j := strings.Index(kv, "=")
+			if j < 0 {
+				return hostRule{}, fmt.Errorf("invalid threshold %q", kv)
+			}
+			d, err := parseDuration(kv[j+1:])
+			if err != nil {
+				return hostRule{}, err
+			}
+			switch strings.TrimSpace(kv[:j]) {
+			case "warning":
+				r.Warning = d
+			case "critical":
+				r.Critical = d
+			default:
+				return hostRule{}, fmt.Errorf("unknown threshold %q, expected 'warning' or 'critical'", kv[:j])
+			}
+		}
+		if r.Critical == 0 {
+			r.Critical = r.Warning
+		}
+		if r.Warning <= 0 || r.Critical < r.Warning {
+			return hostRule{}, fmt.Errorf("invalid threshold %q", s[i+1:])
+		}
+		return r, r.validatePattern()
+	}
+
+	i := strings.Index(s, "=")
+	if i <= 0 {
+		return hostRule{}, fmt.Errorf("expected 'host=warning[,critical]' or 'pattern:warning=duration,critical=duration'")
+	}
+	t, err := parseHostThreshold(s[i+1:])
+	if err != nil {
+		return hostRule{}, err
+	}
+	r := hostRule{Pattern: s[:i], hostThreshold: t}
+	return r, r.validatePattern()
+}
+
+func (r hostRule) validatePattern() error {
+	if _, err := path.Match(r.Pattern, ""); err != nil {
+		return fmt.Errorf("invalid pattern %q", r.Pattern)
+	}
+	return nil
+}
+
+// hostThresholds implements flag.Value for the repeatable 'host-threshold'
+// option. The rules are kept in the order given.
+type hostThresholds []hostRule
+
+func (l *hostThresholds) String() string {
+	rules := make([]string, 0, len(*l))
+	for _, r := range *l {
+		rules = append(rules, fmt.Sprintf("%s=%s,%s", r.Pattern, r.Warning, r.Critical))
+	}
+	return strings.Join(rules, " ")
+}
+
+func (l *hostThresholds) Set(s string) error {
+	r, err := parseHostRule(s)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, r)
+	return nil
+}
+
+// UnmarshalYAML reads the rules of the config from a list in the format of
+// the 'host-threshold' option.
+func (l *hostThresholds) UnmarshalYAML(value *yaml.Node) error {
+	var rules []string
+	if err := value.Decode(&rules); err != nil {
+		return err
+	}
+	for _, s := range rules {
+		if err := l.Set(s); err != nil {
+			return fmt.Errorf("line %d: %s", value.Line, err)
+		}
+	}
+	return nil
+}
+
+// lookup returns the threshold of the host: that of a rule for exactly this
+// hostname, or else that of the first pattern matching it.
+func (l hostThresholds) lookup(host string) (hostThreshold, bool) {
+	for _, r := range l {
+		if r.Pattern == host {
+			return r.hostThreshold, true
+		}
+	}
+	for _, r := range l {
+		if r.matches(host) {
+			return r.hostThreshold, true
+		}
+	}
+	return hostThreshold{}, false
+}
+
+// hostRules returns the host thresholds of the config for the repository, or
+// else those of the 'host-threshold' options.
+func (repo repository) hostRules() hostThresholds {
+	if len(repo.HostThresholds) > 0 {
+		return repo.HostThresholds
+	}
+	return hostThresholdsFlag
+}
+
+// checkHosts evaluates the age of the latest snapshot of every host against
+// its threshold from 'host-threshold' or the config, or
+// 'default-host-threshold' for hosts without one. Every host is evaluated on
+// its own. Rules which match no host with snapshots at all are CRITICAL. It
+// returns the worst status and a description of each violating host.
+func (c *repoCheck) checkHosts() (int, []string, error) {
+	snapshots, err := c.decoded()
+	if err != nil {
+		return OK, nil, err
+	}
+	groups := checkrestic.GroupSnapshots(snapshots, checkrestic.ByHost)
+	rules := c.repo.hostRules()
+
+	status := OK
+	var violations []string
+	for _, g := range groups {
+		t, ok := rules.lookup(g.Key)
+		if !ok {
+			if defaultHostThreshold == nil {
+				continue
+			}
+			t = *defaultHostThreshold
+		}
+		age := time.Since(newestTime(g))
+		hostStatus, threshold := OK, time.Duration(0)
+		if age > t.Critical {
+			hostStatus, threshold = CRITICAL, t.Critical
+		} else if age > t.Warning {
+			hostStatus, threshold = WARNING, t.Warning
+		}
+		if hostStatus != OK {
+			status = worseStatus(status, hostStatus)
+			violations = append(violations, fmt.Sprintf("host %s: latest snapshot created %s ago (threshold %s)", g.Key, age.Round(agePrecision), threshold))
+		}
+	}
+
+	for _, r := range rules {
+		found := false
+		for _, g := range groups {
+			found = found || r.matches(g.Key)
+		}
+		if found {
+			continue
+		}
+		status = CRITICAL
+		if strings.ContainsAny(r.Pattern, "*?[") {
+			violations = append(violations, fmt.Sprintf("hosts %s: no snapshots found", r.Pattern))
+		} else {
+			violations = append(violations, fmt.Sprintf("host %s: no snapshots found", r.Pattern))
+		}
+	}
+	return status, violations, nil
+}
+
+// newestTime returns the time of the newest snapshot of the group as
+// selected by 'newest-by', like that of the latest one of the repository.
+func newestTime(g *checkrestic.Group) time.Time {
+	var newest time.Time
+	for _, sn := range g.Snapshots {
+		if t := snapshotTime(sn); t.After(newest) {
+			newest = t
+		}
+	}
+	return newest
+}
+
+// hostSummary describes the snapshots of a single host.
+type hostSummary struct {
+	Host      string        `json:"host"`
+	Count     int           `json:"count"`
+	NewestAge time.Duration `json:"newest_age"`
+}
+
+// summarizeHosts counts the snapshots of every host along with the age of the
+// newest one as selected by 'newest-by', sorted by host.
+func (c *repoCheck) summarizeHosts() ([]hostSummary, error) {
+	snapshots, err := c.decoded()
+	if err != nil {
+		return nil, err
+	}
+	groups := checkrestic.GroupSnapshots(snapshots, checkrestic.ByHost)
+	hosts := make([]hostSummary, 0, len(groups))
+	for _, g := range groups {
+		hosts = append(hosts, hostSummary{Host: g.Key, Count: len(g.Snapshots), NewestAge: time.Since(newestTime(g))})
+	}
+	return hosts, nil
+}
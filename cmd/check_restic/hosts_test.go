@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"check_restic/pkg/checkrestic"
+)
+
+func TestCheckHostsNewestBy(t *testing.T) {
+	now := time.Now()
+	// the clock of web is ahead, the snapshot file was written hours ago
+	snapshots := []*checkrestic.Snapshot{
+		{ID: "1c2afc0e", Hostname: "web", Time: now.Add(-30 * time.Minute), ModTime: now.Add(-3 * time.Hour)},
+		{ID: "2d3bfd1f", Hostname: "web", Time: now.Add(-4 * time.Hour), ModTime: now.Add(-4 * time.Hour)},
+	}
+	repo := repository{Path: "/srv/restic", HostThresholds: hostThresholds{{Pattern: "web", hostThreshold: hostThreshold{Warning: time.Hour, Critical: 2 * time.Hour}}}}
+	tests := []struct {
+		newestBy string
+		want     int
+	}{
+		{"snapshot-time", OK},
+		{"modtime", CRITICAL},
+		{"min-of-both", CRITICAL},
+		{"max-of-both", OK},
+	}
+	for _, tt := range tests {
+		setFlag(t, &newestBy, tt.newestBy)
+		c := &repoCheck{repo: repo, snapshots: snapshots}
+		status, violations, err := c.checkHosts()
+		if err != nil || status != tt.want {
+			t.Errorf("newest-by=%s: got %s %v, %v, want %s", tt.newestBy, getStatusStr(status), violations, err, getStatusStr(tt.want))
+		}
+	}
+}
+
+func TestSummarizeHostsNewestBy(t *testing.T) {
+	now := time.Now()
+	snapshots := []*checkrestic.Snapshot{
+		{ID: "1c2afc0e", Hostname: "web", Time: now.Add(-30 * time.Minute), ModTime: now.Add(-3 * time.Hour)},
+		{ID: "2d3bfd1f", Hostname: "db", Time: now.Add(-2 * time.Hour), ModTime: now.Add(-time.Hour)},
+	}
+	tests := []struct {
+		newestBy string
+		db, web  time.Duration
+	}{
+		{"snapshot-time", 2 * time.Hour, 30 * time.Minute},
+		{"modtime", time.Hour, 3 * time.Hour},
+		{"min-of-both", 2 * time.Hour, 3 * time.Hour},
+		{"max-of-both", time.Hour, 30 * time.Minute},
+	}
+	for _, tt := range tests {
+		setFlag(t, &newestBy, tt.newestBy)
+		c := &repoCheck{repo: repository{Path: "/srv/restic"}, snapshots: snapshots}
+		hosts, err := c.summarizeHosts()
+		if err != nil || len(hosts) != 2 {
+			t.Fatalf("newest-by=%s: got %+v, %v", tt.newestBy, hosts, err)
+		}
+		// sorted by host
+		if hosts[0].NewestAge.Round(time.Minute) != tt.db || hosts[1].NewestAge.Round(time.Minute) != tt.web {
+			t.Errorf("newest-by=%s: got %+v", tt.newestBy, hosts)
+		}
+	}
+}
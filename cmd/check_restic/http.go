@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// newHTTPClient returns a client for HTTP requests made by the check. It uses
+// the proxy given via the 'proxy-url' option, or otherwise honors the
+// HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables. Callers
+// customizing TLS settings must modify the returned transport rather than
+// replacing it, so that the proxy keeps being used.
+func newHTTPClient(timeout time.Duration) (*http.Client, *http.Transport) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if proxy != nil {
+		transport.Proxy = http.ProxyURL(proxy)
+	} else {
+		transport.Proxy = http.ProxyFromEnvironment
+	}
+	return &http.Client{Timeout: timeout, Transport: transport}, transport
+}
+
+// parseProxyURL validates the value of the 'proxy-url' option.
+func parseProxyURL(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err == nil && u.Host != "" && (u.Scheme == "http" || u.Scheme == "https" || u.Scheme == "socks5") {
+		return u, nil
+	}
+	return nil, fmt.Errorf("The option 'proxy-url' needs to be a valid http, https or socks5 URL.")
+}
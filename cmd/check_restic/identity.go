@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// passphraseEnv passes the passphrase of the 'identity' on to the askpass
+// invocation, see sshEnv.
+const passphraseEnv = "CHECK_RESTIC_KEY_PASSPHRASE"
+
+// keyPassphrase returns the passphrase read from 'identity-passphrase-file',
+// or nil if there is none. Only the final line break is removed, since a
+// passphrase may well end with spaces.
+func keyPassphrase() ([]byte, error) {
+	if *passphraseFile == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(*passphraseFile)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read the passphrase file: %s", err)
+	}
+	return []byte(strings.TrimRight(string(data), "\r\n")), nil
+}
+
+// agentSocket returns the socket of the ssh agent used for 'ssh-agent'.
+func agentSocket() (string, error) {
+	sock := *agentSocketPath
+	if sock == "" {
+		sock = os.Getenv("SSH_AUTH_SOCK")
+	}
+	if sock == "" {
+		return "", errors.New("SSH_AUTH_SOCK is not set, but 'ssh-agent' was requested, use 'ssh-agent-socket' to give the socket")
+	}
+	return sock, nil
+}
+
+// identityArgs returns the ssh options authenticating with only the
+// 'identity' and the keys of the agent, if either is given, instead of
+// whatever ssh_config and the environment of the monitoring system suggest.
+func identityArgs() ([]string, error) {
+	var args []string
+	if *identityFile != "" {
+		args = append(args, "-i", *identityFile, "-o", "IdentitiesOnly=yes")
+	}
+	if *sshAgent {
+		sock, err := agentSocket()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "-o", "IdentityAgent="+sock)
+	}
+	return args, nil
+}
+
+// sshEnv returns the environment for ssh answering its prompts for the PIN of
+// the PKCS#11 token and the passphrase of the 'identity', or nil to inherit
+// the environment unchanged. Since ssh only reads them from a terminal or an
+// SSH_ASKPASS program, this binary acts as the latter, handing them out from
+// the environment instead of the command line, which is visible to every
+// user. SSH_ASKPASS_REQUIRE needs OpenSSH 8.4 or later.
+func sshEnv() ([]string, error) {
+	pin, err := pkcs11Pin()
+	if err != nil {
+		return nil, err
+	}
+	passphrase, err := keyPassphrase()
+	if err != nil {
+		return nil, err
+	}
+	if pin == "" && passphrase == nil {
+		// the token may not need a PIN, or ssh-agent holds the key already
+		return nil, nil
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	env := append(os.Environ(),
+		"SSH_ASKPASS="+exe,
+		"SSH_ASKPASS_REQUIRE=force",
+		askpassEnv+"=1",
+	)
+	if pin != "" {
+		env = append(env, pkcs11PinEnv+"="+pin)
+	}
+	if passphrase != nil {
+		env = append(env, passphraseEnv+"="+string(passphrase))
+	}
+	return env, nil
+}
+
+// runAskpass answers the prompt of ssh if this binary was invoked as its
+// SSH_ASKPASS program, and reports whether it was. ssh passes the prompt as
+// the only argument, which tells a passphrase from a PIN. Any other prompt,
+// like one to confirm a host key, is declined.
+func runAskpass() bool {
+	if os.Getenv(askpassEnv) == "" {
+		return false
+	}
+	var prompt string
+	if len(os.Args) > 1 {
+		prompt = os.Args[1]
+	}
+	answer, ok := askpassAnswer(prompt)
+	if !ok {
+		os.Exit(1)
+	}
+	fmt.Println(answer)
+	return true
+}
+
+// askpassAnswer returns the answer to the prompt of ssh and whether it is
+// one to answer at all.
+func askpassAnswer(prompt string) (string, bool) {
+	switch {
+	case strings.Contains(prompt, "PIN"):
+		return os.Getenv(pkcs11PinEnv), true
+	case strings.Contains(prompt, "passphrase"):
+		return os.Getenv(passphraseEnv), true
+	}
+	return "", false
+}
@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestAskpassAnswer(t *testing.T) {
+	t.Setenv(pkcs11PinEnv, "123456")
+	t.Setenv(passphraseEnv, "secret words ")
+	tests := []struct {
+		prompt string
+		want   string
+		ok     bool
+	}{
+		// the prompts of OpenSSH
+		{"Enter PIN for 'SoftHSM token': ", "123456", true},
+		{"Enter passphrase for key '/etc/check_restic/id_ed25519': ", "secret words ", true},
+		// neither the PIN nor the passphrase is handed out for anything else
+		{"Are you sure you want to continue connecting (yes/no/[fingerprint])? ", "", false},
+		{"u@backup.example.com's password: ", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		if got, ok := askpassAnswer(tt.prompt); got != tt.want || ok != tt.ok {
+			t.Errorf("askpassAnswer(%q) = %q, %v, want %q, %v", tt.prompt, got, ok, tt.want, tt.ok)
+		}
+	}
+}
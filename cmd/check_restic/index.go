@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"time"
+)
+
+// checkIndex compares the newest file below 'index/' with the newest snapshot
+// file for 'index-lag-warning'. restic writes an index file along with every
+// snapshot, so an index lagging behind the snapshots hints at a failed prune
+// or an index which needs to be repaired.
+func (c *repoCheck) checkIndex() subResult {
+	entries, err := c.fs.ReadDir(path.Join(c.repo.Path, "index"))
+	if err != nil {
+		return failed("index", err)
+	}
+	var newestIndex, newestSnapshot time.Time
+	for _, fi := range entries {
+		if !fi.IsDir() && fi.ModTime().After(newestIndex) {
+			newestIndex = fi.ModTime()
+		}
+	}
+	for _, fi := range c.files {
+		if fi.ModTime().After(newestSnapshot) {
+			newestSnapshot = fi.ModTime()
+		}
+	}
+	if newestIndex.IsZero() {
+		return subResult{Name: "index", Status: WARNING, Message: "no index files found"}
+	}
+	if lag := newestSnapshot.Sub(newestIndex); lag > *indexLagWarning {
+		return subResult{Name: "index", Status: WARNING,
+			Message: fmt.Sprintf("newest index file is %s older than the newest snapshot, 'restic repair index' or 'restic prune' may be needed", lag.Round(agePrecision))}
+	}
+	return subResult{Name: "index"}
+}
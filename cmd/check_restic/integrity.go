@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"time"
+)
+
+// integrityMarker returns the path of 'integrity-check-path' on the backend,
+// which is relative to the repository unless it is absolute.
+func (c *repoCheck) integrityMarker() string {
+	if path.IsAbs(*integrityPath) {
+		return path.Clean(*integrityPath)
+	}
+	return path.Join(c.repo.Path, *integrityPath)
+}
+
+// checkIntegrity applies 'integrity-check-warning' and
+// 'integrity-check-critical' to the time the marker of 'integrity-check-path'
+// was last written, or for a directory to that of its newest file. The job
+// running 'restic check' is expected to write it only if the check passed,
+// e.g. 'restic check && touch check-results/ok'.
+func (c *repoCheck) checkIntegrity(res *result) subResult {
+	marker := c.integrityMarker()
+	entries, err := c.fs.ReadDir(path.Dir(marker))
+	if err != nil {
+		return failed("integrity-check", err)
+	}
+	var last time.Time
+	found := false
+	for _, fi := range entries {
+		if fi.Name() != path.Base(marker) {
+			continue
+		}
+		found = true
+		if !fi.IsDir() {
+			last = fi.ModTime()
+			break
+		}
+		results, err := c.fs.ReadDir(marker)
+		if err != nil {
+			return failed("integrity-check", err)
+		}
+		for _, r := range results {
+			if !r.IsDir() && r.ModTime().After(last) {
+				last = r.ModTime()
+			}
+		}
+		break
+	}
+
+	sub := subResult{Name: "integrity-check"}
+	// a check which was never recorded is as bad as the worst threshold
+	status := WARNING
+	if *integrityCritical > 0 {
+		status = CRITICAL
+	}
+	if !found {
+		sub.Status, sub.Message = status, fmt.Sprintf("no integrity check recorded, %s does not exist", *integrityPath)
+		return sub
+	}
+	if last.IsZero() {
+		sub.Status, sub.Message = status, fmt.Sprintf("no integrity check recorded, %s is empty", *integrityPath)
+		return sub
+	}
+
+	age := time.Since(last)
+	res.IntegrityCheckAge = &age
+	switch {
+	case *integrityCritical > 0 && age > *integrityCritical:
+		sub.Status = CRITICAL
+	case *integrityWarning > 0 && age > *integrityWarning:
+		sub.Status = WARNING
+	}
+	if sub.Status != OK {
+		sub.Message = fmt.Sprintf("last successful integrity check %s ago", age.Round(agePrecision))
+	}
+	return sub
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// repoLabel derives the label identifying a repository in the output from its
+// path, as selected by the 'label-from' option. A 'label-regex' which does not
+// match falls back to the full path.
+func repoLabel(p string, re *regexp.Regexp) string {
+	switch *labelFrom {
+	case "basename":
+		if base := path.Base(strings.TrimRight(p, "/")); base != "." && base != "/" {
+			return base
+		}
+	case "regex":
+		m := re.FindStringSubmatch(p)
+		switch {
+		case len(m) > 1 && m[1] != "":
+			return m[1]
+		case len(m) == 1 && m[0] != "":
+			return m[0]
+		}
+	}
+	return p
+}
+
+// parseLabelFrom validates the 'label-from' and 'label-regex' options and
+// returns the compiled regex, if any.
+func parseLabelFrom() (*regexp.Regexp, error) {
+	switch *labelFrom {
+	case "full", "basename":
+		return nil, nil
+	case "regex":
+	default:
+		return nil, fmt.Errorf("The option 'label-from' needs to be one of 'full', 'basename' or 'regex'.")
+	}
+	if *labelRegex == "" {
+		return nil, fmt.Errorf("The option 'label-regex' needs to be set for 'label-from=regex'.")
+	}
+	re, err := regexp.Compile(*labelRegex)
+	if err != nil {
+		return nil, fmt.Errorf("The option 'label-regex' needs to be a valid regular expression: %s", err)
+	}
+	return re, nil
+}
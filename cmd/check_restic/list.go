@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"check_restic/pkg/checkrestic"
+)
+
+// loadAllSnapshots connects to the repository and decrypts all its snapshots.
+func loadAllSnapshots(repo repository) ([]*checkrestic.Snapshot, error) {
+	fs, _, disconnect, err := openFS(repo)
+	if err != nil {
+		return nil, err
+	}
+	defer disconnect()
+
+	files, layout, err := checkrestic.ListSnapshotFilesConcurrently(fs, repo.Path, *repoLayout, *listConcurrency)
+	if err != nil {
+		return nil, err
+	}
+	r, err := checkrestic.OpenRepo(fs, repo.Path, repoPassword(repo))
+	if err != nil {
+		return nil, err
+	}
+	r.SnapshotLayout = layout
+	r.DecodeConcurrency = *decodeConcurrency
+	return r.Snapshots(checkrestic.SnapshotFiles(files))
+}
+
+// runListing prints the distinct keys of the snapshots of every repository,
+// along with the number of snapshots and the age of the newest one for each.
+func runListing(keys func(*checkrestic.Snapshot) []string) (int, string) {
+	var b strings.Builder
+	for _, repo := range repos {
+		snapshots, err := loadAllSnapshots(repo)
+		if err != nil {
+			return UNKNOWN, fmt.Sprintf("%s: %s\n", getStatusStr(UNKNOWN), err)
+		}
+
+		if len(repos) > 1 {
+			fmt.Fprintf(&b, "[%s]\n", repo.Label)
+		}
+		w := tabwriter.NewWriter(&b, 0, 8, 2, ' ', 0)
+		for _, g := range checkrestic.GroupSnapshots(snapshots, keys) {
+			fmt.Fprintf(w, "%s\t%d snapshots\tnewest %s ago\n", g.Key, len(g.Snapshots), time.Since(g.Newest.Time).Round(agePrecision))
+		}
+		w.Flush()
+	}
+	return OK, b.String()
+}
+
+// loadAllLocks connects to the repository and decrypts all its locks.
+func loadAllLocks(repo repository) ([]*checkrestic.Lock, error) {
+	fs, _, disconnect, err := openFS(repo)
+	if err != nil {
+		return nil, err
+	}
+	defer disconnect()
+
+	r, err := checkrestic.OpenRepo(fs, repo.Path, repoPassword(repo))
+	if err != nil {
+		return nil, err
+	}
+	return r.Locks()
+}
+
+// runLockListing prints the locks of every repository, oldest first, with
+// their short IDs as accepted by 'restic unlock'.
+func runLockListing() (int, string) {
+	var b strings.Builder
+	for _, repo := range repos {
+		locks, err := loadAllLocks(repo)
+		if err != nil {
+			return UNKNOWN, fmt.Sprintf("%s: %s\n", getStatusStr(UNKNOWN), err)
+		}
+		sort.Slice(locks, func(a, b int) bool {
+			return locks[a].Time.Before(locks[b].Time)
+		})
+
+		if len(repos) > 1 {
+			fmt.Fprintf(&b, "[%s]\n", repo.Label)
+		}
+		if len(locks) == 0 {
+			b.WriteString("no locks\n")
+			continue
+		}
+		w := tabwriter.NewWriter(&b, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(w, "ID\tAge\tHost\tUser\tPID\tType\n")
+		for _, l := range locks {
+			kind := "shared"
+			if l.Exclusive {
+				kind = "exclusive"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\n", shortID(l.ID), time.Since(l.Time).Round(agePrecision), l.Hostname, l.Username, l.PID, kind)
+		}
+		w.Flush()
+	}
+	return OK, b.String()
+}
+
+// loadSnapshot connects to the repository and decrypts the snapshot whose ID
+// starts with prefix, which needs to be unambiguous.
+func loadSnapshot(repo repository, prefix string) (*checkrestic.Snapshot, error) {
+	fs, _, disconnect, err := openFS(repo)
+	if err != nil {
+		return nil, err
+	}
+	defer disconnect()
+
+	files, layout, err := checkrestic.ListSnapshotFilesConcurrently(fs, repo.Path, *repoLayout, *listConcurrency)
+	if err != nil {
+		return nil, err
+	}
+	var matches []os.FileInfo
+	for _, fi := range checkrestic.SnapshotFiles(files) {
+		if strings.HasPrefix(fi.Name(), prefix) {
+			matches = append(matches, fi)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("no snapshot with ID %s found", prefix)
+	case 1:
+	default:
+		ids := make([]string, 0, len(matches))
+		for _, fi := range matches {
+			ids = append(ids, fi.Name())
+		}
+		sort.Strings(ids)
+		return nil, fmt.Errorf("the ID %s is ambiguous, it matches %s", prefix, strings.Join(ids, ", "))
+	}
+
+	r, err := checkrestic.OpenRepo(fs, repo.Path, repoPassword(repo))
+	if err != nil {
+		return nil, err
+	}
+	r.SnapshotLayout = layout
+	sn, err := r.Snapshot(matches[0].Name())
+	if err != nil {
+		return nil, err
+	}
+	sn.ModTime = checkrestic.ModTime(matches[0])
+	return sn, nil
+}
+
+// runDumpSnapshot prints every field of the snapshot with the given ID as
+// decoded, without evaluating any thresholds.
+func runDumpSnapshot(prefix string) (int, string) {
+	var b strings.Builder
+	for _, repo := range repos {
+		sn, err := loadSnapshot(repo, prefix)
+		if err != nil {
+			return UNKNOWN, fmt.Sprintf("%s: %s\n", getStatusStr(UNKNOWN), err)
+		}
+
+		if len(repos) > 1 {
+			fmt.Fprintf(&b, "[%s]\n", repo.Label)
+		}
+		w := tabwriter.NewWriter(&b, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(w, "ID\t%s\n", sn.ID)
+		fmt.Fprintf(w, "Time\t%s\n", sn.Time.Format(time.RFC3339Nano))
+		fmt.Fprintf(w, "File modified\t%s\n", sn.ModTime.Format(time.RFC3339))
+		fmt.Fprintf(w, "Hostname\t%s\n", sn.Hostname)
+		fmt.Fprintf(w, "Username\t%s\n", sn.Username)
+		fmt.Fprintf(w, "Paths\t%s\n", strings.Join(sn.Paths, ", "))
+		fmt.Fprintf(w, "Tags\t%s\n", strings.Join(sn.Tags, ", "))
+		fmt.Fprintf(w, "Parent\t%s\n", sn.Parent)
+		fmt.Fprintf(w, "Tree\t%s\n", sn.Tree)
+		w.Flush()
+	}
+	return OK, b.String()
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"time"
+
+	"check_restic/pkg/checkrestic"
+)
+
+// lockSummary describes the locks of a repository for 'check-locks'.
+type lockSummary struct {
+	Count int `json:"count"`
+	// Stale is the number of locks older than 'stale-lock-age'.
+	Stale int `json:"stale,omitempty"`
+	// OldestAge is the age of the oldest lock, 0 if there are none.
+	OldestAge time.Duration `json:"oldest_age,omitempty"`
+}
+
+// checkLocks lists the locks of the repository and returns WARNING if any of
+// them is older than 'stale-lock-age'. restic refreshes the locks of running
+// processes every few minutes, so an old lock was left behind by one which
+// died or hangs, and blocks 'restic prune' until it is removed. The times of
+// the lock files are used, except for backends not reporting them, which need
+// the locks to be decrypted. An append-only repository does not allow
+// removing locks remotely, so its stale locks are reported without advice.
+func (c *repoCheck) checkLocks(res *result) subResult {
+	files, err := c.fs.ReadDir(path.Join(c.repo.Path, "locks"))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return failed("locks", err)
+	}
+	var times []time.Time
+	decode := false
+	for _, fi := range files {
+		if !fi.Mode().IsRegular() || !checkrestic.IsID(fi.Name()) {
+			continue
+		}
+		if fi.ModTime().IsZero() {
+			decode = true
+			break
+		}
+		times = append(times, fi.ModTime())
+	}
+	if decode {
+		r, err := c.open()
+		if err != nil {
+			return failed("locks", err)
+		}
+		locks, err := r.Locks()
+		if err != nil {
+			return failed("locks", err)
+		}
+		times = times[:0]
+		for _, l := range locks {
+			times = append(times, l.Time)
+		}
+	}
+
+	now := time.Now()
+	sum := &lockSummary{Count: len(times)}
+	for _, t := range times {
+		age := now.Sub(t)
+		if age > sum.OldestAge {
+			sum.OldestAge = age
+		}
+		if age > *staleLockAge {
+			sum.Stale++
+		}
+	}
+	res.Locks = sum
+
+	sub := subResult{Name: "locks", Message: fmt.Sprintf("%d locks", sum.Count)}
+	if sum.Stale > 0 {
+		sub.Status = WARNING
+		sub.Message += fmt.Sprintf(", %d older than %s (oldest %s)", sum.Stale, *staleLockAge, sum.OldestAge.Round(agePrecision))
+		if *appendOnly {
+			sub.Message += ", which the append-only repository keeps unless they are removed on the server"
+		} else {
+			sub.Message += ", remove them with 'restic unlock' unless restic is still running"
+		}
+	}
+	return sub
+}
+
+// onlyStaleLocks reports whether the stale locks of an append-only repository
+// are all the result warns about. They cannot be removed remotely, so they
+// stay a WARNING even with 'fail-on-warning'.
+func onlyStaleLocks(res result) bool {
+	if !*appendOnly || res.Status != WARNING {
+		return false
+	}
+	locks := false
+	for _, c := range res.Checks {
+		if c.Status == WARNING {
+			if c.Name != "locks" {
+				return false
+			}
+			locks = true
+		}
+	}
+	return locks
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"check_restic/pkg/checkrestic"
+)
+
+func TestCheckLocksAppendOnly(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "locks"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	lock := filepath.Join(dir, "locks", strings.Repeat("1", 64))
+	if err := os.WriteFile(lock, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-3 * time.Hour)
+	if err := os.Chtimes(lock, old, old); err != nil {
+		t.Fatal(err)
+	}
+	savedAppendOnly, savedFailOnWarning := *appendOnly, *failOnWarning
+	defer func() { *appendOnly, *failOnWarning = savedAppendOnly, savedFailOnWarning }()
+	*failOnWarning = true
+
+	for _, appendOnlyRepo := range []bool{false, true} {
+		*appendOnly = appendOnlyRepo
+		c := &repoCheck{repo: repository{Path: dir}, fs: checkrestic.LocalFS{}}
+		var res result
+		sub := c.checkLocks(&res)
+		if sub.Status != WARNING || res.Locks == nil || res.Locks.Stale != 1 {
+			t.Errorf("append-only %v: got %s %q, %+v", appendOnlyRepo, getStatusStr(sub.Status), sub.Message, res.Locks)
+		}
+		if strings.Contains(sub.Message, "restic unlock") == appendOnlyRepo {
+			t.Errorf("append-only %v: got %q", appendOnlyRepo, sub.Message)
+		}
+
+		// 'fail-on-warning' promotes the stale locks unless they cannot be
+		// removed, but not any other warning
+		for _, others := range [][]subResult{nil, {{Name: "max-oldest", Status: WARNING}}} {
+			checks := append([]subResult{sub}, others...)
+			saved := repos
+			repos = []repository{{Path: dir}}
+			_, results, err := checkAll(func(repo repository) result {
+				res := result{Repo: repo, Checks: checks}
+				res.Status, res.Message = aggregate(checks)
+				return res
+			}, time.Now())
+			repos = saved
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := CRITICAL
+			if appendOnlyRepo && others == nil {
+				want = WARNING
+			}
+			if results[0].Status != want {
+				t.Errorf("append-only %v with %d other warnings: got %s, want %s", appendOnlyRepo, len(others), getStatusStr(results[0].Status), getStatusStr(want))
+			}
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logger writes the log lines of the 'verbose' and 'debug' options to stderr
+// or the 'log-file', never to stdout, which only carries the result.
+var logger = struct {
+	mu  sync.Mutex
+	out io.Writer
+}{out: os.Stderr}
+
+// openLog directs the log to the 'log-file', if it is given.
+func openLog() error {
+	if *logFile == "" {
+		return nil
+	}
+	f, err := os.OpenFile(*logFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return fmt.Errorf("Unable to open the log file: %s", err)
+	}
+	logger.mu.Lock()
+	logger.out = f
+	logger.mu.Unlock()
+	return nil
+}
+
+// verbosef logs a message if the 'verbose' or 'debug' option was given.
+func verbosef(format string, args ...interface{}) {
+	if verbose > 0 || *debug {
+		logEvent("info", "", fmt.Sprintf(format, args...))
+	}
+}
+
+// debugf logs a step of the check along with key-value pairs describing it
+// if the 'debug' option was given, e.g.
+// debugf("connect", "starting ssh", "host", repo.Host).
+func debugf(step, msg string, kv ...interface{}) {
+	if *debug {
+		logEvent("debug", step, msg, kv...)
+	}
+}
+
+// logEvent writes a line in the 'log-format'. The plain text format keeps the
+// look of the messages of 'verbose', with the step and the pairs appended.
+// Pairs with a nil value, e.g. an error which did not occur, are left out.
+func logEvent(level, step, msg string, kv ...interface{}) {
+	now := time.Now()
+	var pairs []interface{}
+	for i := 0; i+1 < len(kv); i += 2 {
+		if kv[i+1] != nil {
+			pairs = append(pairs, kv[i], kv[i+1])
+		}
+	}
+	kv = pairs
+	var b bytes.Buffer
+	switch *logFormat {
+	case "json":
+		fields := map[string]interface{}{"time": now.Format(time.RFC3339Nano), "level": level, "msg": msg}
+		if step != "" {
+			fields["step"] = step
+		}
+		for i := 0; i+1 < len(kv); i += 2 {
+			v := kv[i+1]
+			if err, ok := v.(error); ok {
+				v = err.Error()
+			} else if d, ok := v.(time.Duration); ok {
+				v = d.String()
+			}
+			fields[fmt.Sprint(kv[i])] = v
+		}
+		data, err := json.Marshal(fields)
+		if err != nil {
+			data = []byte(strconv.Quote(msg))
+		}
+		b.Write(data)
+	case "logfmt":
+		fmt.Fprintf(&b, "time=%s level=%s", now.Format(time.RFC3339Nano), level)
+		if step != "" {
+			fmt.Fprintf(&b, " step=%s", logfmtValue(step))
+		}
+		fmt.Fprintf(&b, " msg=%s", logfmtValue(msg))
+		for i := 0; i+1 < len(kv); i += 2 {
+			fmt.Fprintf(&b, " %s=%s", kv[i], logfmtValue(fmt.Sprint(kv[i+1])))
+		}
+	default:
+		fmt.Fprintf(&b, "%s ", now.Format(time.RFC3339))
+		if step != "" {
+			fmt.Fprintf(&b, "[%s] ", step)
+		}
+		b.WriteString(msg)
+		for i := 0; i+1 < len(kv); i += 2 {
+			fmt.Fprintf(&b, " %s=%s", kv[i], logfmtValue(fmt.Sprint(kv[i+1])))
+		}
+	}
+	b.WriteByte('\n')
+
+	logger.mu.Lock()
+	logger.out.Write(b.Bytes())
+	logger.mu.Unlock()
+}
+
+// logfmtValue quotes a value if it would otherwise be ambiguous.
+func logfmtValue(s string) string {
+	if s == "" || strings.ContainsAny(s, " \t\n\"=\\") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// logWriter logs every line written to it, e.g. the stderr of ssh, which
+// would otherwise end up next to the result.
+type logWriter struct {
+	step string
+	mu   sync.Mutex
+	buf  []byte
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		if line := strings.TrimSpace(string(w.buf[:i])); line != "" && (verbose > 0 || *debug) {
+			logEvent("info", w.step, line)
+		}
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
@@ -0,0 +1,1481 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"check_restic/pkg/checkrestic"
+)
+
+const (
+	OK       = checkrestic.OK
+	WARNING  = checkrestic.WARNING
+	CRITICAL = checkrestic.CRITICAL
+	UNKNOWN  = checkrestic.UNKNOWN
+)
+
+var (
+	warning            = flag.Duration("warning", -1, "return WARNING if the lastest snapshot is older than the specified number of hours")
+	critical           = flag.Duration("critical", -1, "return CRITICAL if the lastest snapshot is older than the specified number of hours")
+	repoFile           = flag.String("repository-file", "", "read the paths of further repositories from the specified file, one per line, like additional 'repository' options")
+	sftpHost           = flag.String("host", "", "ssh host to be used for sftp connection, may include the port like 'host:2222' or '[2001:db8::1]:2222', which takes precedence over 'port'; IPv6 addresses without a port need no brackets")
+	sftpUser           = flag.String("user", "", "ssh user to be used for sftp connection")
+	sftpPort           = flag.String("port", defaultPort, "ssh port to be used for sftp connection")
+	proxyCommand       = flag.String("proxy-command", "", "command connecting to the host through its stdin and stdout like the ProxyCommand of ssh, '%h' and '%p' are replaced by the host and port")
+	proxyJump          = flag.String("proxy-jump", "", "comma-separated jump hosts '[user@]host[:port]' to connect through, like 'ssh -J', e.g. for repositories only reachable via a bastion host; the user defaults to 'user'")
+	reuseConns         = flag.Bool("reuse-connections", true, "check all repositories on the same host, user and port via a single ssh connection")
+	parallel           = flag.Int("parallel", 4, "number of repositories checked at once")
+	scheduleExpr       = flag.String("schedule", "", "cron expression of the backup runs in the local time zone, e.g. '0 2 * * 1-5' for weekdays at 02:00; the runs since the latest snapshot are counted against 'schedule-missed-warning' and 'schedule-missed-critical', and 'warning' and 'critical' become optional")
+	scheduleGrace      = flag.Duration("schedule-grace", time.Hour, "how long after its scheduled time a run for 'schedule' only counts as missed, i.e. the longest a backup may take")
+	scheduleMissedWarn = flag.Int("schedule-missed-warning", 1, "return WARNING if at least the specified number of runs of 'schedule' were missed, 0 disables it")
+	scheduleMissedCrit = flag.Int("schedule-missed-critical", 2, "return CRITICAL if at least the specified number of runs of 'schedule' were missed, 0 disables it")
+	retries            = flag.Int("retries", 0, "retry connecting to the repository and listing its snapshots the specified number of times before giving up, e.g. to ride out a brief network outage; missing repositories and rejected credentials are not retried")
+	retryDelay         = flag.Duration("retry-delay", 5*time.Second, "how long to wait before the first of the 'retries', doubled for each further one")
+	timeout            = flag.Duration("timeout", 0, "return UNKNOWN if the check does not finish within the specified duration, e.g. because the host does not respond; should be shorter than the timeout of the monitoring system")
+	configFile         = flag.String("config", "", "read repositories and their thresholds from the specified YAML file, whose global values are overridden by the corresponding options")
+	configDir          = flag.String("config-dir", "", "read repositories and their thresholds from every YAML file in the specified directory")
+	output             = flag.String("output", "text", "output format, one of 'text', 'json', 'csv', 'influx' or 'sensu'; defaults to the value of "+outputEnv+" if it is set")
+
+	useResticBinary = flag.Bool("use-restic-binary", false, "list the snapshots via 'restic snapshots --json' instead of reading the repository directly, which supports every backend of restic; 'repository' is passed to restic as is and defaults to RESTIC_REPOSITORY, the password is read by restic as well")
+
+	backendName    = flag.String("backend", "sftp", "backend storing the repositories, one of 'sftp', 'local' (a directory on this machine, the default if neither 'host' nor 'user' is set), 'rest' (restic's rest-server), 's3' (Amazon S3 or a compatible service like MinIO), 'azure' (Azure Blob storage), 'gcs' (Google Cloud Storage) or 'rclone' (any remote of rclone, e.g. Google Drive, OneDrive or B2); for the latter five, 'repository' is the path of the repository on the server, within the bucket or container or on 'rclone-remote'")
+	azureAccount   = flag.String("azure-account", "", "Azure storage account of the azure backend, authenticated by the AZURE_ACCOUNT_KEY or AZURE_ACCOUNT_SAS environment variables or else the managed identity; AZURE_STORAGE_CONNECTION_STRING may be used instead")
+	azureContainer = flag.String("azure-container", "", "container holding the repositories of the azure backend")
+	gcsBucket      = flag.String("gcs-bucket", "", "bucket holding the repositories of the gcs backend")
+	gcsKeyFile     = flag.String("gcs-key-file", "", "service account key file in JSON format used by the gcs backend instead of the application default credentials")
+
+	restURL          = flag.String("rest-url", "", "URL of the rest-server of the rest backend, e.g. 'https://backup.example.com:8000'; it may include the user and password like for restic")
+	restUser         = flag.String("rest-user", "", "user authenticating to the rest-server via basic authentication")
+	restPasswordFile = flag.String("rest-password-file", "", "read the password of 'rest-user' from the specified file")
+	insecureTLS      = flag.Bool("insecure-tls", false, "do not verify the TLS certificate of the rest-server, e.g. for a self-signed one")
+
+	rcloneRemote  = flag.String("rclone-remote", "", "rclone remote holding the repositories of the rclone backend, e.g. 'gdrive:' or 'b2:bucket/restic', like for 'restic -r rclone:...'")
+	rcloneProgram = flag.String("rclone-program", "rclone", "path of the rclone binary started by the rclone backend")
+	rcloneArgs    = flag.String("rclone-args", "serve restic --stdio", "arguments passed to 'rclone-program' before 'rclone-remote', e.g. to add '--config'; they need to keep serving the REST protocol on stdin and stdout")
+
+	s3Endpoint        = flag.String("s3-endpoint", "", "URL of an S3 compatible service used by the s3 backend instead of Amazon S3 in 's3-region', e.g. 'https://minio.example.com:9000'")
+	s3Bucket          = flag.String("s3-bucket", "", "bucket holding the repositories of the s3 backend")
+	s3Prefix          = flag.String("s3-prefix", "", "prefix of the repositories within 's3-bucket'")
+	s3Region          = flag.String("s3-region", "", "region of 's3-bucket', defaults to AWS_REGION or AWS_DEFAULT_REGION or else 'us-east-1'")
+	s3CredentialsFile = flag.String("s3-credentials-file", "", "shared credentials file read for the AWS_PROFILE, or 'default', profile by the s3 backend unless AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are set; defaults to AWS_SHARED_CREDENTIALS_FILE or ~/.aws/credentials")
+
+	repoLayout = flag.String("repo-layout", "auto", "layout of the snapshots directory, one of 'auto', 'flat' (files only) or 'sharded' (in subdirectories named by the first two characters of their IDs)")
+
+	labelFrom  = flag.String("label-from", "full", "how repositories are labelled in the output, one of 'full' (the path), 'basename' (its last element) or 'regex' (the first group matched by 'label-regex' in the path)")
+	labelRegex = flag.String("label-regex", "", "regular expression extracting the label from the path of a repository for 'label-from=regex', e.g. '/srv/restic/([^-/]+)'")
+
+	pkcs11Lib     = flag.String("pkcs11-lib", "", "PKCS#11 provider library the key is loaded from, e.g. for keys kept on a hardware token; the PIN is read from 'pkcs11-pin-file' or the CHECK_RESTIC_PKCS11_PIN environment variable")
+	pkcs11PinFile = flag.String("pkcs11-pin-file", "", "read the PIN of the PKCS#11 token from the specified file")
+	pkcs11Slot    = flag.Int("pkcs11-slot", -1, "slot of the PKCS#11 token holding the key, by default the first one holding a token; requires 'ssh-client=native'")
+	pkcs11Label   = flag.String("pkcs11-label", "", "label of the key on the PKCS#11 token, needed if it holds several; requires 'ssh-client=native'")
+
+	sshClient          = flag.String("ssh-client", "openssh", "how to connect to the sftp target, one of 'openssh' (running the 'ssh' command) or 'native' (built in, without ssh_config, authenticated by 'identity' or 'ssh-agent')")
+	identityFile       = flag.String("identity", "", "private key to authenticate with, defaults to ~/.ssh/id_ed25519, id_ecdsa and id_rsa for the native ssh client unless 'ssh-agent' is set and to ssh_config otherwise; ssh is then told to offer only this key and those of the agent")
+	passphraseFile     = flag.String("identity-passphrase-file", "", "read the passphrase of an encrypted 'identity' from the specified file; ssh is handed it via SSH_ASKPASS, which needs OpenSSH 8.4 or later")
+	knownHostsFile     = flag.String("known-hosts", "", "known hosts file verifying the host keys, defaults to ~/.ssh/known_hosts for the native ssh client and to ssh_config otherwise; ssh is then told to refuse unknown hosts")
+	hostKeyFingerprint = flag.String("host-key-fingerprint", "", "comma-separated SHA256 fingerprints of the host keys the native ssh client accepts instead of those in 'known-hosts', as shown by 'ssh-keygen -lf', e.g. 'SHA256:uNiVztksCsDhcc0u9e8BujQXVUpKZIDTMczCvj3tD2s'")
+	sshAgent           = flag.Bool("ssh-agent", false, "authenticate with the keys of the ssh agent at SSH_AUTH_SOCK or 'ssh-agent-socket', e.g. holding an encrypted key unlocked once for the user running the checks")
+	sshConfigFile      = flag.String("ssh-config", "", "ssh_config file resolving the 'host' as an alias, e.g. to its HostName, Port, User and IdentityFile; passed to ssh with '-F', the native ssh client only reads these four settings of 'Host' sections; with it, 'user' is optional and the default 'port' is left to the file")
+	agentSocketPath    = flag.String("ssh-agent-socket", "", "socket of the ssh agent used by 'ssh-agent' instead of SSH_AUTH_SOCK, which the monitoring system usually does not set, e.g. '/run/user/<uid>/ssh-agent.socket'")
+	sshCommandTmpl     = flag.String("ssh-command", "", "command run instead of ssh to open the sftp session for 'ssh-client=openssh', e.g. 'plink -batch -P {port} {user}@{host} -s sftp' on Windows or one running the 'sftp-server' of a restricted shell; the placeholders {host}, {port}, {user} and {identity} are replaced after splitting the command into arguments like a shell, without running one; the options passed to ssh as arguments need to be given in the command instead")
+
+	passwordFile      = flag.String("password-file", "", "read the repository password from the specified file, required by checks which decrypt the repository")
+	passwordCommand   = flag.String("password-command", "", "read the repository password from the output of the specified shell command")
+	timeDrift         = flag.Duration("warn-on-time-drift", 0, "return WARNING if the modification times of the snapshot files differ from the times recorded in the snapshots by more than the specified duration (median over all snapshots, requires decrypting every snapshot)")
+	expectRepoVersion = flag.Int("expect-repo-version", 0, "return WARNING if the repository format version differs from the specified one")
+	warnEmptySnapshot = flag.Bool("warn-empty-snapshot", false, "return WARNING if the latest snapshot does not contain any files (reads the repository index and is therefore expensive for large repositories)")
+	requireOlderThan  = flag.Duration("require-snapshot-older-than", 0, "return CRITICAL unless the oldest snapshot is older than the specified duration, e.g. to verify long-term retention")
+	maxOldest         = flag.Duration("max-oldest", 0, "return WARNING if the oldest snapshot is older than the specified duration, e.g. '9600h' if nothing should be kept for longer than 400 days, since 'restic forget' or 'restic prune' is then not running")
+	keepLast          = flag.Int("keep-last", 0, "expected retention policy like that of 'restic forget', given with the same values: returns WARNING if snapshots remain which none of 'keep-last', 'keep-hourly', 'keep-daily', 'keep-weekly', 'keep-monthly' and 'keep-yearly' keeps, or if one of the periodic rules finds fewer snapshots than it keeps although the snapshots reach back that far; like restic applied separately to every host and set of paths; requires decrypting every snapshot")
+	keepHourly        = flag.Int("keep-hourly", 0, "number of hourly snapshots the expected retention policy keeps, see 'keep-last'")
+	keepDaily         = flag.Int("keep-daily", 0, "number of daily snapshots the expected retention policy keeps, see 'keep-last'")
+	keepWeekly        = flag.Int("keep-weekly", 0, "number of weekly snapshots the expected retention policy keeps, see 'keep-last'")
+	keepMonthly       = flag.Int("keep-monthly", 0, "number of monthly snapshots the expected retention policy keeps, see 'keep-last'")
+	keepYearly        = flag.Int("keep-yearly", 0, "number of yearly snapshots the expected retention policy keeps, see 'keep-last'")
+	cadenceWindow     = flag.Int("cadence-window", 0, "number of the latest snapshots whose intervals are compared with 'cadence-warning' and 'cadence-critical', e.g. 24 for hourly backups, which catches backups silently switched to a longer interval")
+	cadenceBy         = flag.String("cadence-by", "average", "interval of the 'cadence-window' compared with the thresholds, one of 'average' or 'max', the latter also catching a single long gap")
+	cadenceWarning    = flag.Duration("cadence-warning", 0, "return WARNING if the average or, with 'cadence-by=max', the longest interval between the snapshots of the 'cadence-window' exceeds the specified duration, e.g. '2h' for hourly backups")
+	cadenceCritical   = flag.Duration("cadence-critical", 0, "return CRITICAL if the interval of 'cadence-warning' exceeds the specified duration")
+	minSnapshotsWarn  = flag.Int("min-snapshots-warning", 0, "return WARNING if there are fewer than the specified number of snapshots, e.g. because pruning removed too many or the repository was re-initialized")
+	minSnapshotsCrit  = flag.Int("min-snapshots-critical", 0, "return CRITICAL if there are fewer than the specified number of snapshots")
+	checkStructure    = flag.Bool("check-structure", false, "return CRITICAL if the repository lacks its config, a key or one of the 'data', 'index' and 'snapshots' directories, i.e. appears corrupted or uninitialized, instead of only reporting missing snapshots; object stores have no directories, so only the config and the keys are verified for them")
+	indexLagWarning   = flag.Duration("index-lag-warning", 0, "return WARNING if the newest file below 'index/' is older than the newest snapshot by more than the specified duration, e.g. after a failed 'restic prune'; not supported by the rest and rclone backends")
+	integrityPath     = flag.String("integrity-check-path", "", "marker written whenever 'restic check' passed, or a directory like 'check-results/' whose newest file counts, relative to the repository unless absolute; its age is checked against 'integrity-check-warning' and 'integrity-check-critical'; not supported by the rest and rclone backends")
+	integrityWarning  = flag.Duration("integrity-check-warning", 0, "return WARNING if the last successful integrity check recorded at 'integrity-check-path' is older than the specified duration, e.g. '192h' for a weekly check")
+	integrityCritical = flag.Duration("integrity-check-critical", 0, "return CRITICAL if the last successful integrity check recorded at 'integrity-check-path' is older than the specified duration")
+	checkLocks        = flag.Bool("check-locks", false, "report the number of locks and return WARNING if any of them is older than 'stale-lock-age', e.g. left behind by a restic process which died and blocking 'restic prune'")
+	staleLockAge      = flag.Duration("stale-lock-age", time.Hour, "age of a lock after which 'check-locks' considers it stale; restic refreshes the locks of running processes every few minutes")
+	dataSubsetStat    = flag.Bool("data-subset-stat", false, "report the size of the repository data, estimated from a sample of the directories below 'data/'")
+	sizeSamplePct     = flag.Int("size-sample-pct", 10, "percentage of the directories below 'data/' listed by 'data-subset-stat', 100 determines the exact size")
+	sizeWarningStr    = flag.String("size-warning", "", "return WARNING if the repository data is larger than the specified size, e.g. '500G' or '2TiB'; implies 'data-subset-stat'")
+	sizeCriticalStr   = flag.String("size-critical", "", "return CRITICAL if the repository data is larger than the specified size; implies 'data-subset-stat'")
+	defaultHostThresh = flag.String("default-host-threshold", "", "threshold 'warning[,critical]' for the latest snapshot of every host without a 'host-threshold', requires decrypting every snapshot")
+	checkWritable     = flag.Bool("check-writable", false, "return CRITICAL if a file cannot be created and deleted below 'tmp/' of the repository, e.g. because the disk is full or was remounted read-only")
+	checkFreeSpaceOpt = flag.Bool("check-free-space", false, "report the space available on the filesystem holding the repository and compare it with 'free-warning' and 'free-critical'; needs an SFTP server supporting the statvfs extension, like OpenSSH")
+	freeWarningStr    = flag.String("free-warning", "", "return WARNING if less than the specified space is available, a size like '50G' or a percentage of the filesystem like '10%'; implies 'check-free-space'")
+	freeCriticalStr   = flag.String("free-critical", "", "return CRITICAL if less than the specified space is available; implies 'check-free-space'")
+	groupBy           = flag.String("group-by", "", "report the number of snapshots and the age of the newest one per group, only 'host' is supported; requires decrypting every snapshot")
+	showHeadroom      = flag.Bool("show-headroom", false, "append the time left until the latest snapshot reaches the warning threshold to OK results")
+	requiredPathsFile = flag.String("required-paths-file", "", "read paths from the specified file, one per line, which each need to be included in a snapshot younger than 'warning' and 'critical', e.g. to prove that every expected directory is backed up; requires decrypting every snapshot")
+	exitOKOnMissing   = flag.Bool("exit-ok-on-missing", false, "return OK if the repository or its 'snapshots' directory does not exist yet, e.g. for repositories created later by a provisioning run; connection and permission errors are still reported")
+
+	warnFutureCount     = flag.Int("warn-future-count", 0, "return WARNING if at least the specified number of snapshots were created more than 'clock-skew' in the future, pointing to a client with a wrong clock; requires decrypting every snapshot")
+	criticalFutureCount = flag.Int("critical-future-count", 0, "return CRITICAL if at least the specified number of snapshots were created more than 'clock-skew' in the future")
+	clockSkew           = flag.Duration("clock-skew", 5*time.Minute, "how far snapshot times may be ahead of the local clock before they count for 'warn-future-count' and 'critical-future-count'")
+
+	heartbeatTag      = flag.String("heartbeat-tag", "", "check the latest snapshot with the specified tag against 'heartbeat-warning' and 'heartbeat-critical', e.g. of a tiny backup made every few minutes; such snapshots do not count as the latest one otherwise; requires decrypting every snapshot")
+	heartbeatWarning  = flag.Duration("heartbeat-warning", 0, "return WARNING if the latest snapshot tagged 'heartbeat-tag' is older than the specified duration")
+	heartbeatCritical = flag.Duration("heartbeat-critical", 0, "return CRITICAL if the latest snapshot tagged 'heartbeat-tag' is older than the specified duration")
+
+	maintenanceUntil = flag.String("maintenance-until", "", "return OK instead of WARNING or CRITICAL until the specified time in RFC 3339 format, e.g. during planned maintenance")
+	maintenanceFile  = flag.String("maintenance-file", "", "read the end of a maintenance window like 'maintenance-until' from the specified file, if it exists")
+	inactiveRepos    = flag.String("inactive-repos", "", "comma-separated paths of repositories which are intentionally paused: their status is shown in the message but OK is returned, like for repositories marked 'inactive' in the config")
+
+	failOnWarning     = flag.Bool("fail-on-warning", false, "return CRITICAL instead of WARNING, e.g. for backups which must not be stale at all; UNKNOWN is not affected")
+	unknownAs         = flag.String("unknown-as", "UNKNOWN", "exit with the specified status, one of 'UNKNOWN', 'WARNING' or 'CRITICAL', instead of UNKNOWN; the message is not changed")
+	unknownAsArgsErrs = flag.Bool("unknown-as-invalid-args", false, "also apply 'unknown-as' if the command line or config is invalid")
+	onConnectError    = flag.String("on-connect-error", "unknown", "return the specified status, one of 'unknown', 'warning' or 'critical', if the connection to the host cannot be established, e.g. to alert on an unreachable backup server like on a missed backup; a changed host key is always CRITICAL")
+	onEmptyRepo       = flag.String("on-empty-repo", "critical", "return the specified status, one of 'warning' or 'critical', if the repository does not contain any snapshots, e.g. for a freshly initialized one")
+
+	ntpCheck     = flag.Bool("ntp-check", false, "return UNKNOWN if the local clock deviates from the NTP server's by more than 'max-clock-skew'")
+	ntpServer    = flag.String("ntp-server", "pool.ntp.org", "NTP server used by 'ntp-check'")
+	maxClockSkew = flag.Duration("max-clock-skew", time.Minute, "maximum deviation of the local clock tolerated by 'ntp-check'")
+
+	pingURL     = flag.String("ping-url", "", "URL of a dead man's switch to notify on OK, '<url>/fail' is notified on CRITICAL")
+	pingTimeout = flag.Duration("ping-timeout", 10*time.Second, "timeout for notifying 'ping-url'")
+
+	newestByName     = flag.String("newest-by", "", "time determining the latest snapshot, one of 'modtime' (of the snapshot file), 'snapshot-time' (recorded in the snapshot), 'min-of-both' or 'max-of-both'; defaults to 'snapshot-time' if a password is available and 'modtime' otherwise")
+	agePrecisionName = flag.String("age-precision", "second", "precision of the age shown in the message, one of 'second', 'minute' or 'hour'")
+
+	decodeConcurrency = flag.Int("decode-concurrency", 8, "number of snapshots read and decrypted at once over the connection to the repository")
+	listConcurrency   = flag.Int("list-concurrency", 8, "number of directories listed at once over the connection to the repository, i.e. the shards below 'snapshots/' and those sampled by 'data-subset-stat', which hides the latency of remote backends for large repositories")
+
+	stateFile    = flag.String("state-file", "", "file used to keep state between runs, required by 'flap-suppress', 'prune-stale-window', 'result-cache-ttl', 'on-change-command', 'snapshot-paths-change-detection', 'detect-reinit', 'detect-trends' and 'growth-warning' or 'growth-critical'")
+	flapSuppress = flag.Bool("flap-suppress", false, "return WARNING instead of CRITICAL or UNKNOWN unless the failure persists for 'flap-count' runs after an OK within 'flap-window'")
+	flapWindow   = flag.Duration("flap-window", time.Hour, "how long after an OK result failures are considered possible flapping")
+	flapCount    = flag.Int("flap-count", 3, "number of consecutive failing runs before 'flap-suppress' stops suppressing them")
+
+	onChangeCommand = flag.String("on-change-command", "", "run the specified shell command whenever the status of a repository changes, passing CHECK_RESTIC_STATUS, CHECK_RESTIC_REPO and CHECK_RESTIC_MESSAGE in its environment, requires 'state-file'")
+
+	resultCacheTTL = flag.Duration("result-cache-ttl", 0, "reuse the result of a previous run with the same arguments if it is not older than the specified duration, requires 'state-file'")
+
+	pruneStaleWindow = flag.Duration("prune-stale-window", 0, "return WARNING if the oldest snapshot has not changed for the specified duration while the number of snapshots grew, requires 'state-file'")
+	appendOnly       = flag.Bool("append-only", false, "the repository is append-only, e.g. served by 'rest-server --append-only', so old snapshots are never removed and locks cannot be removed remotely: disables 'prune-stale-window', and stale locks found by 'check-locks' stay a WARNING even with 'fail-on-warning' and are reported without suggesting 'restic unlock'")
+
+	pathsChange   = flag.Bool("snapshot-paths-change-detection", false, "return WARNING if a path backed up by earlier snapshots is missing from the latest snapshot, e.g. after the backup job was edited, requires 'state-file'")
+	resetBaseline = flag.Bool("reset-baseline", false, "replace the paths recorded by 'snapshot-paths-change-detection' with those of the latest snapshot and the identity recorded by 'detect-reinit' with the current one, e.g. after intentionally removing a path from the backup")
+
+	detectReinit = flag.Bool("detect-reinit", false, "return CRITICAL if the ID or chunker polynomial of the repository differs from the one recorded by earlier runs, e.g. because 'restic init' was run over it or a mount now points elsewhere, requires 'state-file'")
+
+	detectTrends       = flag.Bool("detect-trends", false, "return WARNING if, compared to the previous run, the number of snapshots decreased by more than 'count-drop-tolerance', the latest snapshot was replaced by an older one, the repository data shrank by more than half or, with 'schedule', no new snapshot appeared despite a scheduled run, requires 'state-file'")
+	countDropTolerance = flag.Int("count-drop-tolerance", 0, "number of snapshots by which the count may decrease between runs before 'detect-trends' returns WARNING, e.g. when 'forget' removes several snapshots at once")
+
+	growthWarningStr  = flag.String("growth-warning", "", "return WARNING if the repository data grew by more than the specified size per day on average over the 'growth-window', e.g. '50G'; implies 'data-subset-stat', requires 'state-file'")
+	growthCriticalStr = flag.String("growth-critical", "", "return CRITICAL if the repository data grew by more than the specified size per day on average over the 'growth-window'; implies 'data-subset-stat', requires 'state-file'")
+	growthWindow      = flag.Duration("growth-window", 7*24*time.Hour, "period over which 'growth-warning' and 'growth-critical' average the growth of the repository data")
+
+	onlyIfReachable      = flag.String("only-if-reachable", "", "only alert about stale backups if the backed-up machine accepts TCP connections at the specified 'host:port', e.g. for laptops which are often offline")
+	unreachableStatusStr = flag.String("unreachable-status", "OK", "status returned instead of a stale alert if the machine given by 'only-if-reachable' is unreachable, one of 'OK', 'WARNING', 'CRITICAL' or 'UNKNOWN'")
+
+	proxyURL = flag.String("proxy-url", "", "proxy used for HTTP requests instead of the one from the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables")
+
+	redactHosts = flag.Bool("redact-hosts", false, "replace the ssh host and user and the hostnames of 'group-by=host' in the output like 'redact'")
+	redactPaths = flag.Bool("redact-paths", false, "replace the paths and labels of the repositories in the output like 'redact'")
+
+	debug     = flag.Bool("debug", false, "log every step of the check, i.e. connecting, the ssh handshake, listing and decoding the snapshots, along with the details of 'verbose'; the log never goes to stdout, which only carries the result")
+	logFile   = flag.String("log-file", "", "append the log of 'verbose' and 'debug', including the messages of ssh, to the specified file instead of writing it to stderr")
+	logFormat = flag.String("log-format", "text", "format of the log lines, one of 'text', 'logfmt' (key=value pairs) or 'json'")
+
+	statusFile = flag.String("status-file", "", "after every check, atomically replace the specified file with the results in the format of 'output=json', with 'generated_at' added, e.g. for dashboards reading it")
+
+	listen         = flag.String("listen", "", "instead of checking once, keep running and serve the results as Prometheus metrics on /metrics of the specified address, e.g. ':9774'")
+	listenInterval = flag.Duration("listen-interval", 5*time.Minute, "how often to check the repositories for 'listen'")
+
+	daemon             = flag.Bool("daemon", false, "instead of checking once, keep running, check the repositories every 'daemon-interval' and push the result of every repository as a passive check to 'submit'; the ssh connections stay open between the rounds and 'timeout' applies to every round")
+	daemonInterval     = flag.Duration("daemon-interval", 5*time.Minute, "how often to check the repositories for 'daemon'")
+	submitTo           = flag.String("submit", "", "where 'daemon' pushes the results, one of 'icinga2' (the REST API of Icinga 2) or 'nrdp' (Nagios Remote Data Processor); NSCA is not supported, use NRDP instead")
+	submitURL          = flag.String("submit-url", "", "URL of the endpoint of 'submit', e.g. 'https://icinga.example.com:5665' or 'https://nagios.example.com/nrdp/'")
+	submitUser         = flag.String("submit-user", "", "API user of icinga2, which needs the permission 'actions/process-check-result'")
+	submitPasswordFile = flag.String("submit-password-file", "", "read the password of 'submit-user' for icinga2 or the token for nrdp from the specified file")
+	submitCAFile       = flag.String("submit-ca-file", "", "PEM encoded CA certificates verifying the TLS certificate of 'submit-url' instead of the system ones, e.g. the CA of Icinga 2 at /var/lib/icinga2/certs/ca.crt")
+	submitHost         = flag.String("submit-host", "", "host the results are submitted for, defaults to the ssh host of the repository or else the hostname of this machine")
+	submitService      = flag.String("submit-service", "restic {label}", "service the result of a repository is submitted for, '{label}' is replaced by the label of the repository")
+
+	summarizeText = flag.Bool("summarize", false, "if several repositories are checked, show the number of repositories per status instead of 'checked N repositories' and only list those which are not OK in the text output")
+
+	color = flag.String("color", "auto", "colorize the status in text output, never in the other formats including the text in Sensu events, one of 'auto' (if stdout is a terminal and NO_COLOR is not set), 'always' or 'never'")
+
+	splay = flag.Duration("splay", 0, "wait for a random duration of up to the specified length before connecting, to spread the load of many checks starting at once")
+
+	selfTest    = flag.Bool("self-test", false, "only verify that the repository can be reached via sftp instead of checking its snapshots")
+	selfTestDir = flag.String("self-test-writable-dir", "", "directory on the sftp target the self-test may write a temporary file to")
+
+	benchCycles = flag.Int("bench", 0, "only connect to the repository and list its snapshots the specified number of times, then show the timings and the amount of data transferred, e.g. to compare 'reuse-connections' on and off")
+
+	listHosts           = flag.Bool("list-hosts", false, "only list the hostnames of all snapshots with their number and the age of the newest one")
+	listTags            = flag.Bool("list-tags", false, "only list the tags of all snapshots with their number and the age of the newest one")
+	waitForSnapshotMode = flag.Bool("wait-for-snapshot", false, "only wait until a snapshot newer than 'wait-since' appears, e.g. to verify a backup right after making it")
+	waitSinceStr        = flag.String("wait-since", "", "reference time for 'wait-for-snapshot' in RFC 3339 format, defaults to now")
+	waitTimeout         = flag.Duration("wait-timeout", 10*time.Minute, "return CRITICAL if no snapshot appeared within the specified duration for 'wait-for-snapshot'")
+	waitInterval        = flag.Duration("wait-interval", 10*time.Second, "interval at which 'wait-for-snapshot' lists the snapshots")
+
+	listLocks    = flag.Bool("list-locks", false, "only list the locks of the repository with their age, host, user, process and type")
+	dumpSnapshot = flag.String("dump-snapshot", "", "only print the decoded snapshot with the specified ID, which may be abbreviated, e.g. to see why a filter does not match it")
+)
+
+// repository holds the effective settings used to check a single repository.
+type repository struct {
+	Path     string
+	Label    string
+	Host     string
+	User     string
+	Port     string
+	Warning  time.Duration
+	Critical time.Duration
+	// PasswordFile replaces the repository password for this repository, it
+	// is only set by the config.
+	PasswordFile string
+	// Tags replace the 'snapshot-tag' options for this repository, they are
+	// only set by the config.
+	Tags []string
+	// Hosts replace the 'snapshot-host' options for this repository, they
+	// are only set by the config.
+	Hosts []string
+	// HostThresholds replace the 'host-threshold' options for this
+	// repository, they are only set by the config.
+	HostThresholds hostThresholds
+	// Inactive marks a repository whose backups are intentionally paused.
+	Inactive bool
+}
+
+// result is the outcome of checking a single repository.
+type result struct {
+	Repo    repository
+	Status  int
+	Message string
+	// Snapshots is -1 if the snapshots could not be listed. LatestID, Latest
+	// and Age are only meaningful if hasLatest reports so.
+	Snapshots int
+	LatestID  string
+	Latest    time.Time
+	Age       time.Duration
+	// Oldest is the time of the oldest snapshot.
+	Oldest time.Time
+	// RepoVersion is the repository format version, or 0 if it is unknown.
+	RepoVersion int
+	// DataSize is the size of the repository data, estimated from
+	// DataSizeSampled percent of it. Both are 0 if the size is unknown.
+	DataSize        int64
+	DataSizeSampled int
+	// PerHost summarizes the snapshots of every host for 'group-by=host'.
+	PerHost []hostSummary
+	// Headroom is the time left until the latest snapshot is older than the
+	// warning threshold for 'show-headroom', or 0.
+	Headroom time.Duration
+	// ActualStatus is the status of an inactive repository before it was
+	// capped at OK.
+	ActualStatus int
+	// LatestHost, LatestTags and LatestPaths describe the latest snapshot,
+	// they are only known if the snapshots were decrypted and LatestPaths is
+	// nil otherwise.
+	LatestHost  string
+	LatestTags  []string
+	LatestPaths []string
+	// Identity is the identity of the repository for 'detect-reinit', nil if
+	// it is unknown.
+	Identity *repoIdentity
+	// Locks summarizes the locks for 'check-locks', nil if they were not
+	// listed.
+	Locks *lockSummary
+	// MissedRuns is the number of runs of the 'schedule' since the latest
+	// snapshot, nil if there is no schedule.
+	MissedRuns *int
+	// IntegrityCheckAge is the age of the last successful integrity check
+	// recorded at 'integrity-check-path', nil if unknown.
+	IntegrityCheckAge *time.Duration
+	// CadenceGap is the average or longest interval between the snapshots
+	// of the 'cadence-window', nil if it is not checked.
+	CadenceGap *time.Duration
+	// FreeSpace is the space of the filesystem holding the repository for
+	// 'check-free-space', nil if it is not checked.
+	FreeSpace *freeSpace
+	// DataGrowth is the average growth of the repository data in bytes per
+	// day for 'growth-warning' and 'growth-critical', nil if it is unknown.
+	DataGrowth *int64
+	// Checks are the results of the individual checks, nil if the snapshots
+	// could not be listed.
+	Checks []subResult
+	// Retries is the number of times checking the repository was retried.
+	Retries int
+}
+
+// hasLatest reports whether a latest snapshot was found. The repository may
+// well hold snapshots without one, if none of them matched the filters or all
+// of them are heartbeats.
+func (res result) hasLatest() bool {
+	return res.Snapshots > 0 && !res.Latest.IsZero()
+}
+
+// repos lists the repositories to be checked, as determined by parseArgs.
+var repos []repository
+
+// runCtx is canceled once the 'timeout' expired.
+var runCtx = context.Background()
+
+// connCtx returns the context of the processes behind the ssh connections.
+// The daemon keeps them open from one round to the next, so they outlive the
+// context of a round and are closed along with the connections instead.
+func connCtx() context.Context {
+	if *daemon {
+		return context.Background()
+	}
+	return runCtx
+}
+
+// repoPaths holds the paths given by the 'repository' option.
+var repoPaths stringList
+
+// password is the repository password, as determined by parseArgs. It is only
+// read if a check needs to decrypt the repository.
+var password string
+
+// newestBy is the effective value of the 'newest-by' option, as determined by
+// parseArgs.
+var newestBy = "modtime"
+
+// agePrecision is the duration ages in messages are rounded to, as
+// determined by parseArgs.
+var agePrecision = time.Second
+
+// useColor reports whether the text output is colorized, as determined by
+// parseArgs.
+var useColor bool
+
+// proxy is the parsed value of the 'proxy-url' option, if any.
+var proxy *url.URL
+
+// unknownExitCode is the exit code used instead of UNKNOWN, as determined by
+// parseArgs.
+var unknownExitCode = UNKNOWN
+
+// connectErrorStatus and emptyRepoStatus are the statuses of the
+// 'on-connect-error' and 'on-empty-repo' options, as determined by parseArgs.
+var (
+	connectErrorStatus = UNKNOWN
+	emptyRepoStatus    = CRITICAL
+)
+
+// hostThresholdsFlag holds the thresholds given by the repeatable
+// 'host-threshold' option.
+var hostThresholdsFlag hostThresholds
+
+// requiredPaths lists the paths read from the 'required-paths-file', if any.
+var requiredPaths []string
+
+// sizeWarning, sizeCritical, growthWarning and growthCritical are the parsed
+// values of the corresponding options in bytes, or 0 if they are not set.
+var sizeWarning, sizeCritical, growthWarning, growthCritical int64
+
+// defaultHostThreshold is the parsed value of the 'default-host-threshold'
+// option, if any.
+var defaultHostThreshold *hostThreshold
+
+func init() {
+	checkrestic.Logf = verbosef
+	flag.Var(&verbose, "verbose", "log details about the check to stderr and add the host and tags of the latest snapshot to the message if the snapshots were decrypted; '-verbose=2' also adds its paths")
+	flag.Var(&repoPaths, "repository", "path to restic repository on sftp target, may be repeated to check several repositories")
+	flag.Var(&redactFlag, "redact", "replace substrings matching the specified regular expression in the output by a placeholder derived from their hash, e.g. customer names; may be repeated")
+	flag.Var(&snapshotHosts, "snapshot-host", "only consider snapshots of the specified hostname for the age of the latest one, may be repeated to allow any of them; requires decrypting every snapshot")
+	flag.Var(&snapshotTags, "snapshot-tag", "only consider snapshots with the specified tag for the age of the latest one, or with all of several comma-separated tags, may be repeated to allow any of them; requires decrypting every snapshot")
+	flag.Var(&snapshotPaths, "snapshot-path", "only consider snapshots including the specified path for the age of the latest one, may be repeated to require all of them; requires decrypting every snapshot")
+	flag.Var(&hostThresholdsFlag, "host-threshold", "threshold 'host=warning[,critical]' or 'pattern:warning=duration,critical=duration' for the latest snapshot of the specified host or of every host matching the pattern, e.g. 'web*:warning=2h,critical=6h'; may be repeated, a rule for the exact hostname takes precedence over patterns, which are tried in the order given; requires decrypting every snapshot")
+}
+
+// maintenanceEnd is the end of the maintenance window given by
+// 'maintenance-until' or 'maintenance-file', or zero if there is none.
+var maintenanceEnd time.Time
+
+// outputEnv holds the output format used if the 'output' option is not given,
+// e.g. to select one for all checks of an environment. It is also the first
+// thing reported if the options cannot be parsed.
+var outputEnv = envVar("output")
+
+// peekOutput returns the value of the 'output' option from the command line,
+// even if it could not be parsed as a whole.
+func peekOutput() string {
+	args := os.Args[1:]
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		name := strings.TrimLeft(arg, "-")
+		if name == arg {
+			continue
+		}
+		if strings.HasPrefix(name, "output=") {
+			return strings.TrimPrefix(name, "output=")
+		}
+		if name == "output" && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	if env := os.Getenv(outputEnv); env != "" {
+		return env
+	}
+	return *output
+}
+
+// resolveOutput validates the output format, which may have been taken from
+// the environment.
+func resolveOutput() error {
+	switch *output {
+	case "text", "json", "csv", "influx", "sensu":
+		return nil
+	}
+	if fromEnv["output"] {
+		return fmt.Errorf("The environment variable '%s' needs to be one of 'text', 'json', 'csv', 'influx' or 'sensu'.", outputEnv)
+	}
+	return fmt.Errorf("The option 'output' needs to be one of 'text', 'json', 'csv', 'influx' or 'sensu'.")
+}
+
+// unreachableStatus is the parsed value of the 'unreachable-status' option.
+var unreachableStatus = OK
+
+func parseArgs() error {
+	// report invalid options like any other error, in the requested format
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	if err := flag.CommandLine.Parse(os.Args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		return err
+	}
+	if err := applyEnv(); err != nil {
+		return err
+	}
+	switch *logFormat {
+	case "text", "logfmt", "json":
+	default:
+		return fmt.Errorf("The option 'log-format' needs to be one of 'text', 'logfmt' or 'json'.")
+	}
+	if err := openLog(); err != nil {
+		return err
+	}
+
+	switch *agePrecisionName {
+	case "second":
+		agePrecision = time.Second
+	case "minute":
+		agePrecision = time.Minute
+	case "hour":
+		agePrecision = time.Hour
+	default:
+		return fmt.Errorf("The option 'age-precision' needs to be one of 'second', 'minute' or 'hour'.")
+	}
+
+	switch *color {
+	case "always":
+		useColor = true
+	case "auto":
+		fi, err := os.Stdout.Stat()
+		useColor = err == nil && fi.Mode()&os.ModeCharDevice != 0 && os.Getenv("NO_COLOR") == ""
+	case "never":
+	default:
+		return fmt.Errorf("The option 'color' needs to be one of 'auto', 'always' or 'never'.")
+	}
+	// the other formats are parsed, and the Sensu event embeds the text
+	if *output != "text" {
+		useColor = false
+	}
+
+	switch strings.ToUpper(*unknownAs) {
+	case "UNKNOWN":
+	case "WARNING":
+		unknownExitCode = WARNING
+	case "CRITICAL":
+		unknownExitCode = CRITICAL
+	default:
+		return fmt.Errorf("The option 'unknown-as' needs to be one of 'UNKNOWN', 'WARNING' or 'CRITICAL'.")
+	}
+
+	switch strings.ToLower(*onConnectError) {
+	case "unknown":
+		connectErrorStatus = UNKNOWN
+	case "warning":
+		connectErrorStatus = WARNING
+	case "critical":
+		connectErrorStatus = CRITICAL
+	default:
+		return fmt.Errorf("The option 'on-connect-error' needs to be one of 'unknown', 'warning' or 'critical'.")
+	}
+	switch strings.ToLower(*onEmptyRepo) {
+	case "warning":
+		emptyRepoStatus = WARNING
+	case "critical":
+		emptyRepoStatus = CRITICAL
+	default:
+		return fmt.Errorf("The option 'on-empty-repo' needs to be one of 'warning' or 'critical'.")
+	}
+
+	if *defaultHostThresh != "" {
+		t, err := parseHostThreshold(*defaultHostThresh)
+		if err != nil {
+			return fmt.Errorf("The option 'default-host-threshold' needs to be of the form 'warning[,critical]'.")
+		}
+		defaultHostThreshold = &t
+	}
+
+	switch *repoLayout {
+	case checkrestic.LayoutAuto, checkrestic.LayoutFlat, checkrestic.LayoutSharded:
+	default:
+		return fmt.Errorf("The option 'repo-layout' needs to be one of 'auto', 'flat' or 'sharded'.")
+	}
+
+	switch *groupBy {
+	case "", "host":
+	default:
+		return fmt.Errorf("The option 'group-by' needs to be 'host'.")
+	}
+
+	switch strings.ToUpper(*unreachableStatusStr) {
+	case "OK":
+	case "WARNING":
+		unreachableStatus = WARNING
+	case "CRITICAL":
+		unreachableStatus = CRITICAL
+	case "UNKNOWN":
+		unreachableStatus = UNKNOWN
+	default:
+		return fmt.Errorf("The option 'unreachable-status' needs to be one of 'OK', 'WARNING', 'CRITICAL' or 'UNKNOWN'.")
+	}
+	if *waitSinceStr != "" {
+		t, err := time.Parse(time.RFC3339, *waitSinceStr)
+		if err != nil {
+			return fmt.Errorf("The option 'wait-since' needs to be a time in RFC 3339 format.")
+		}
+		waitSince = t
+	}
+	if *maintenanceUntil != "" {
+		t, err := time.Parse(time.RFC3339, *maintenanceUntil)
+		if err != nil {
+			return fmt.Errorf("The option 'maintenance-until' needs to be a time in RFC 3339 format.")
+		}
+		maintenanceEnd = t
+	}
+	if *maintenanceFile != "" {
+		data, err := os.ReadFile(*maintenanceFile)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("Unable to read the maintenance file: %s", err)
+		}
+		if err == nil {
+			t, err := time.Parse(time.RFC3339, strings.TrimSpace(string(data)))
+			if err != nil {
+				return fmt.Errorf("The maintenance file needs to contain a time in RFC 3339 format.")
+			}
+			if t.After(maintenanceEnd) {
+				maintenanceEnd = t
+			}
+		}
+	}
+	if *waitInterval <= 0 {
+		return fmt.Errorf("The option 'wait-interval' needs to be greater than 0.")
+	}
+
+	if (*pkcs11Slot >= 0 || *pkcs11Label != "") && *pkcs11Lib == "" {
+		return fmt.Errorf("The options 'pkcs11-slot' and 'pkcs11-label' need 'pkcs11-lib' to be set.")
+	}
+	if *proxyJump != "" && *proxyCommand != "" {
+		return fmt.Errorf("The options 'proxy-jump' and 'proxy-command' are mutually exclusive.")
+	}
+	if *agentSocketPath != "" && !*sshAgent {
+		return fmt.Errorf("The option 'ssh-agent' needs to be set for 'ssh-agent-socket'.")
+	}
+	if *sshConfigFile != "" {
+		cfg, err := loadSSHConfig(*sshConfigFile)
+		if err != nil {
+			return fmt.Errorf("Unable to read the ssh config: %s", err)
+		}
+		sshConfig = cfg
+	}
+	switch *sshClient {
+	case "openssh":
+		if *hostKeyFingerprint != "" {
+			return fmt.Errorf("The option 'host-key-fingerprint' needs 'ssh-client=native', configure ssh via ssh_config otherwise.")
+		}
+		if *pkcs11Slot >= 0 || *pkcs11Label != "" {
+			return fmt.Errorf("The options 'pkcs11-slot' and 'pkcs11-label' need 'ssh-client=native', ssh offers every key of the token otherwise.")
+		}
+		if *sshCommandTmpl != "" {
+			args, err := parseSSHCommand(*sshCommandTmpl)
+			if err != nil {
+				return fmt.Errorf("The option 'ssh-command' needs to be a command like 'plink -batch -P {port} {user}@{host} -s sftp': %s", err)
+			}
+			sshCommand = args
+			for name, set := range map[string]bool{
+				"proxy-command":            *proxyCommand != "",
+				"proxy-jump":               *proxyJump != "",
+				"known-hosts":              *knownHostsFile != "",
+				"ssh-config":               *sshConfigFile != "",
+				"ssh-agent":                *sshAgent,
+				"pkcs11-lib":               *pkcs11Lib != "",
+				"identity-passphrase-file": *passphraseFile != "",
+			} {
+				if set {
+					return fmt.Errorf("The option '%s' cannot be combined with 'ssh-command', pass it in the command instead.", name)
+				}
+			}
+			if (*identityFile != "") != sshCommandUses("{identity}") {
+				return fmt.Errorf("The option 'identity' needs to be set if and only if 'ssh-command' contains {identity}.")
+			}
+		}
+	case "native":
+		if *pkcs11Lib != "" && !pkcs11Supported {
+			return fmt.Errorf("The option 'pkcs11-lib' needs 'ssh-client=openssh' since this binary was built without PKCS#11 support, build it with cgo and '-tags pkcs11' otherwise.")
+		}
+		if *proxyJump != "" {
+			hosts, err := parseJumpHosts(*proxyJump)
+			if err != nil {
+				return fmt.Errorf("The option 'proxy-jump' needs to list jump hosts like '[user@]host[:port]': %s", err)
+			}
+			jumpHosts = hosts
+		}
+		if *sshCommandTmpl != "" {
+			return fmt.Errorf("The option 'ssh-command' is only supported by 'ssh-client=openssh'.")
+		}
+		if *hostKeyFingerprint != "" && *knownHostsFile != "" {
+			return fmt.Errorf("The options 'host-key-fingerprint' and 'known-hosts' are mutually exclusive.")
+		}
+		for _, fp := range strings.Split(*hostKeyFingerprint, ",") {
+			if *hostKeyFingerprint != "" && !strings.HasPrefix(strings.TrimSpace(fp), "SHA256:") {
+				return fmt.Errorf("The option 'host-key-fingerprint' needs to list fingerprints like 'SHA256:...' as shown by 'ssh-keygen -lf'.")
+			}
+		}
+	default:
+		return fmt.Errorf("The option 'ssh-client' needs to be one of 'openssh' or 'native'.")
+	}
+
+	if *onlyIfReachable != "" {
+		if _, _, err := net.SplitHostPort(*onlyIfReachable); err != nil {
+			return fmt.Errorf("The option 'only-if-reachable' needs to be of the form 'host:port'.")
+		}
+	}
+
+	if err := resolveOutput(); err != nil {
+		return err
+	}
+
+	paths := append([]string(nil), repoPaths...)
+	if *repoFile != "" {
+		listed, err := loadPathList(*repoFile)
+		if err != nil {
+			return fmt.Errorf("Unable to read the repository file: %s", err)
+		}
+		if len(listed) == 0 {
+			return fmt.Errorf("The repository file does not list any repositories.")
+		}
+		paths = append(paths, listed...)
+	}
+
+	// the repositories are validated depending on the schedule
+	if *scheduleExpr != "" {
+		sched, err := parseSchedule(*scheduleExpr)
+		if err != nil {
+			return fmt.Errorf("The option 'schedule' needs to be a cron expression like '0 2 * * 1-5': %s", err)
+		}
+		backupSchedule = sched
+	}
+	def := repository{
+		Host:     *sftpHost,
+		User:     *sftpUser,
+		Port:     *sftpPort,
+		Warning:  *warning,
+		Critical: *critical,
+	}
+	if *configFile == "" && *configDir == "" {
+		repos = []repository{def}
+		for i, p := range paths {
+			if i > 0 {
+				repos = append(repos, def)
+			}
+			repos[i].Path = p
+		}
+	} else {
+		set := make(map[string]bool)
+		flag.Visit(func(f *flag.Flag) {
+			set[f.Name] = true
+		})
+		repos = nil
+		if *configFile != "" {
+			cfg, err := loadConfig(*configFile)
+			if err != nil {
+				return err
+			}
+			repos, err = cfg.repositories(def, set)
+			if err != nil {
+				return err
+			}
+		}
+		if *configDir != "" {
+			dirRepos, err := loadConfigDir(*configDir, def, set)
+			if err != nil {
+				return err
+			}
+			repos = append(repos, dirRepos...)
+		}
+		for _, p := range paths {
+			repo := def
+			repo.Path = p
+			repos = append(repos, repo)
+		}
+		if len(repos) == 0 {
+			return fmt.Errorf("The configuration does not list any repositories.")
+		}
+	}
+
+	inactive := make(map[string]bool)
+	if *inactiveRepos != "" {
+		for _, p := range strings.Split(*inactiveRepos, ",") {
+			p, err := normalizeRepoPath(strings.TrimSpace(p))
+			if err != nil {
+				return err
+			}
+			inactive[p] = true
+		}
+	}
+	if detectLocal() && !*useResticBinary {
+		*backendName = "local"
+	}
+	for i, repo := range repos {
+		if err := repo.validate(); err != nil {
+			return err
+		}
+		// restic understands more than paths
+		if *useResticBinary {
+			continue
+		}
+		if *backendName == "sftp" {
+			repos[i].Host, repos[i].Port, _ = splitAddress(repo.Host, repo.Port)
+		}
+		p, err := normalizeRepoPath(repo.Path)
+		if err != nil {
+			return err
+		}
+		repos[i].Path = p
+		if inactive[p] {
+			repos[i].Inactive = true
+		}
+	}
+	if !*useResticBinary {
+		if err := setupBackend(); err != nil {
+			return err
+		}
+	}
+	if objectStore != nil {
+		for i := range repos {
+			repos[i].Host, repos[i].User, repos[i].Port = objectStoreLocation, "", ""
+		}
+	}
+	labelRe, err := parseLabelFrom()
+	if err != nil {
+		return err
+	}
+	for i := range repos {
+		repos[i].Label = repoLabel(repos[i].Path, labelRe)
+	}
+
+	if *proxyURL != "" {
+		u, err := parseProxyURL(*proxyURL)
+		if err != nil {
+			return err
+		}
+		proxy = u
+	}
+
+	if *flapSuppress && *stateFile == "" {
+		return fmt.Errorf("The option 'state-file' needs to be set for 'flap-suppress'.")
+	}
+	if *decodeConcurrency < 1 {
+		return fmt.Errorf("The option 'decode-concurrency' needs to be at least 1.")
+	}
+	if *listConcurrency < 1 {
+		return fmt.Errorf("The option 'list-concurrency' needs to be at least 1.")
+	}
+	if *timeout < 0 {
+		return fmt.Errorf("The option 'timeout' must not be negative.")
+	}
+	if *parallel < 1 {
+		return fmt.Errorf("The option 'parallel' needs to be at least 1.")
+	}
+	if *sizeSamplePct < 1 || *sizeSamplePct > 100 {
+		return fmt.Errorf("The option 'size-sample-pct' needs to be between 1 and 100.")
+	}
+	if *onChangeCommand != "" && *stateFile == "" {
+		return fmt.Errorf("The option 'state-file' needs to be set for 'on-change-command'.")
+	}
+	if *resultCacheTTL > 0 && *stateFile == "" {
+		return fmt.Errorf("The option 'state-file' needs to be set for 'result-cache-ttl'.")
+	}
+	if *pruneStaleWindow > 0 && *stateFile == "" {
+		return fmt.Errorf("The option 'state-file' needs to be set for 'prune-stale-window'.")
+	}
+	if *pathsChange && *stateFile == "" {
+		return fmt.Errorf("The option 'state-file' needs to be set for 'snapshot-paths-change-detection'.")
+	}
+	if *detectReinit && *stateFile == "" {
+		return fmt.Errorf("The option 'state-file' needs to be set for 'detect-reinit'.")
+	}
+	if *detectTrends && *stateFile == "" {
+		return fmt.Errorf("The option 'state-file' needs to be set for 'detect-trends'.")
+	}
+	if *countDropTolerance < 0 {
+		return fmt.Errorf("The option 'count-drop-tolerance' must not be negative.")
+	}
+	for _, t := range []struct {
+		name string
+		s    string
+		v    *int64
+	}{
+		{"size-warning", *sizeWarningStr, &sizeWarning},
+		{"size-critical", *sizeCriticalStr, &sizeCritical},
+		{"growth-warning", *growthWarningStr, &growthWarning},
+		{"growth-critical", *growthCriticalStr, &growthCritical},
+	} {
+		if t.s == "" {
+			continue
+		}
+		v, err := parseSize(t.s)
+		if err != nil || v <= 0 {
+			return fmt.Errorf("The option '%s' needs to be a size greater than 0, e.g. '500G'.", t.name)
+		}
+		*t.v = v
+	}
+	for _, t := range []struct {
+		name string
+		s    string
+		v    *freeLimit
+	}{
+		{"free-warning", *freeWarningStr, &freeWarning},
+		{"free-critical", *freeCriticalStr, &freeCritical},
+	} {
+		if t.s == "" {
+			continue
+		}
+		l, err := parseFreeLimit(t.s)
+		if err != nil {
+			return fmt.Errorf("The option '%s' needs to be a size greater than 0 or a percentage, e.g. '50G' or '10%%'.", t.name)
+		}
+		*t.v = l
+	}
+	if checksGrowth() && *stateFile == "" {
+		return fmt.Errorf("The option 'state-file' needs to be set for 'growth-warning' and 'growth-critical'.")
+	}
+	if *growthWindow <= 0 {
+		return fmt.Errorf("The option 'growth-window' needs to be greater than 0.")
+	}
+	if *resetBaseline && !*pathsChange && !*detectReinit {
+		return fmt.Errorf("The option 'snapshot-paths-change-detection' or 'detect-reinit' needs to be set for 'reset-baseline'.")
+	}
+	if *warnFutureCount < 0 || *criticalFutureCount < 0 {
+		return fmt.Errorf("The options 'warn-future-count' and 'critical-future-count' must not be negative.")
+	}
+	if *warnFutureCount > 0 && *criticalFutureCount > 0 && *criticalFutureCount < *warnFutureCount {
+		return fmt.Errorf("The option 'critical-future-count' needs to be at least 'warn-future-count'.")
+	}
+	if *heartbeatTag != "" && (*heartbeatWarning <= 0 || *heartbeatCritical <= 0) {
+		return fmt.Errorf("The options 'heartbeat-warning' and 'heartbeat-critical' need to be set and greater than 0 for 'heartbeat-tag'.")
+	}
+	if *staleLockAge <= 0 {
+		return fmt.Errorf("The option 'stale-lock-age' needs to be greater than 0.")
+	}
+	if *cadenceWindow < 0 || *cadenceWindow == 1 {
+		return fmt.Errorf("The option 'cadence-window' needs to be at least 2.")
+	}
+	if *cadenceBy != "average" && *cadenceBy != "max" {
+		return fmt.Errorf("The option 'cadence-by' needs to be one of 'average' or 'max'.")
+	}
+	if *cadenceWarning < 0 || *cadenceCritical < 0 {
+		return fmt.Errorf("The options 'cadence-warning' and 'cadence-critical' must not be negative.")
+	}
+	if *cadenceWindow > 0 && *cadenceWarning == 0 && *cadenceCritical == 0 {
+		return fmt.Errorf("The option 'cadence-window' needs 'cadence-warning' or 'cadence-critical' to be set.")
+	}
+	if *cadenceWindow == 0 && (*cadenceWarning > 0 || *cadenceCritical > 0) {
+		return fmt.Errorf("The options 'cadence-warning' and 'cadence-critical' need 'cadence-window' to be set.")
+	}
+	if *cadenceWarning > 0 && *cadenceCritical > 0 && *cadenceCritical < *cadenceWarning {
+		return fmt.Errorf("The option 'cadence-critical' must not be less than 'cadence-warning'.")
+	}
+	if *maxOldest < 0 {
+		return fmt.Errorf("The option 'max-oldest' must not be negative.")
+	}
+	if *maxOldest > 0 && *requireOlderThan >= *maxOldest {
+		return fmt.Errorf("The option 'max-oldest' needs to be greater than 'require-snapshot-older-than'.")
+	}
+	if *keepLast < 0 || *keepHourly < 0 || *keepDaily < 0 || *keepWeekly < 0 || *keepMonthly < 0 || *keepYearly < 0 {
+		return fmt.Errorf("The options 'keep-last', 'keep-hourly', 'keep-daily', 'keep-weekly', 'keep-monthly' and 'keep-yearly' must not be negative.")
+	}
+	if *minSnapshotsWarn < 0 || *minSnapshotsCrit < 0 {
+		return fmt.Errorf("The options 'min-snapshots-warning' and 'min-snapshots-critical' must not be negative.")
+	}
+	if *minSnapshotsWarn > 0 && *minSnapshotsCrit > *minSnapshotsWarn {
+		return fmt.Errorf("The option 'min-snapshots-critical' must not be greater than 'min-snapshots-warning'.")
+	}
+	if *scheduleGrace < 0 {
+		return fmt.Errorf("The option 'schedule-grace' must not be negative.")
+	}
+	if *scheduleMissedWarn < 0 || *scheduleMissedCrit < 0 {
+		return fmt.Errorf("The options 'schedule-missed-warning' and 'schedule-missed-critical' must not be negative.")
+	}
+	if *scheduleMissedWarn > 0 && *scheduleMissedCrit > 0 && *scheduleMissedCrit < *scheduleMissedWarn {
+		return fmt.Errorf("The option 'schedule-missed-critical' must not be less than 'schedule-missed-warning'.")
+	}
+	if *retries < 0 {
+		return fmt.Errorf("The option 'retries' must not be negative.")
+	}
+	if *retryDelay <= 0 {
+		return fmt.Errorf("The option 'retry-delay' needs to be greater than 0.")
+	}
+	if *indexLagWarning < 0 {
+		return fmt.Errorf("The option 'index-lag-warning' must not be negative.")
+	}
+	if *integrityWarning < 0 || *integrityCritical < 0 {
+		return fmt.Errorf("The options 'integrity-check-warning' and 'integrity-check-critical' must not be negative.")
+	}
+	if *integrityPath != "" && *integrityWarning == 0 && *integrityCritical == 0 {
+		return fmt.Errorf("The option 'integrity-check-path' needs 'integrity-check-warning' or 'integrity-check-critical' to be set.")
+	}
+	if *integrityPath == "" && (*integrityWarning > 0 || *integrityCritical > 0) {
+		return fmt.Errorf("The options 'integrity-check-warning' and 'integrity-check-critical' need 'integrity-check-path' to be set.")
+	}
+	if *clockSkew < 0 {
+		return fmt.Errorf("The option 'clock-skew' must not be negative.")
+	}
+	if *requiredPathsFile != "" {
+		paths, err := loadPathList(*requiredPathsFile)
+		if err != nil {
+			return fmt.Errorf("Unable to read the required paths file: %s", err)
+		}
+		if len(paths) == 0 {
+			return fmt.Errorf("The required paths file does not list any paths.")
+		}
+		requiredPaths = paths
+	}
+	if *benchCycles < 0 {
+		return fmt.Errorf("The option 'bench' must not be negative.")
+	}
+	if *listen != "" {
+		if interactive() {
+			return fmt.Errorf("The option 'listen' cannot be combined with modes which only run once.")
+		}
+		if *listenInterval <= 0 {
+			return fmt.Errorf("The option 'listen-interval' needs to be greater than 0.")
+		}
+	}
+	if *daemon {
+		if interactive() || *listen != "" {
+			return fmt.Errorf("The option 'daemon' cannot be combined with 'listen' or modes which only run once.")
+		}
+		if *daemonInterval <= 0 {
+			return fmt.Errorf("The option 'daemon-interval' needs to be greater than 0.")
+		}
+		if *submitTo != "icinga2" && *submitTo != "nrdp" {
+			return fmt.Errorf("The option 'submit' needs to be one of 'icinga2' or 'nrdp' for 'daemon'.")
+		}
+		if u, err := url.Parse(*submitURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("The option 'submit-url' needs to be a valid http or https URL for 'daemon'.")
+		}
+		if *submitTo == "icinga2" && *submitUser == "" {
+			return fmt.Errorf("The option 'submit-user' needs to be set for 'submit=icinga2'.")
+		}
+	} else if *submitTo != "" {
+		return fmt.Errorf("The option 'daemon' needs to be set for 'submit'.")
+	}
+	if *flapCount < 1 {
+		return fmt.Errorf("The option 'flap-count' needs to be greater than 0.")
+	}
+
+	if *useResticBinary {
+		if err := validateResticBinary(); err != nil {
+			return err
+		}
+		newestBy = "snapshot-time"
+		return nil
+	}
+
+	if err := readRepoPasswords(); err != nil {
+		return err
+	}
+
+	// Decrypting the snapshots is the only way to learn about their real times,
+	// since modification times may be reset when copying a repository, e.g.
+	// to "now" making the repository look fresh. The conservative
+	// 'min-of-both' guards against the latter, while 'max-of-both' tolerates
+	// snapshots created by a client with a lagging clock.
+	switch *newestByName {
+	case "":
+		if allReposHavePasswords() {
+			newestBy = "snapshot-time"
+		} else if !interactive() {
+			var err error
+			if password, err = readPassword(); err != nil {
+				return err
+			}
+			if password != "" {
+				newestBy = "snapshot-time"
+			}
+		}
+	case "modtime", "snapshot-time", "min-of-both", "max-of-both":
+		newestBy = *newestByName
+	default:
+		return fmt.Errorf("The option 'newest-by' needs to be one of 'modtime', 'snapshot-time', 'min-of-both' or 'max-of-both'.")
+	}
+	// the REST API does not report modification times
+	if restProtocol() && !interactive() {
+		if newestBy != "snapshot-time" {
+			return fmt.Errorf("The %s backend needs 'newest-by=snapshot-time' and therefore the password, since the server does not report modification times.", *backendName)
+		}
+		if *timeDrift > 0 {
+			return fmt.Errorf("The option 'warn-on-time-drift' is not supported by the %s backend, since the server does not report modification times.", *backendName)
+		}
+		if *indexLagWarning > 0 {
+			return fmt.Errorf("The option 'index-lag-warning' is not supported by the %s backend, since the server does not report modification times.", *backendName)
+		}
+		if *integrityPath != "" {
+			return fmt.Errorf("The option 'integrity-check-path' is not supported by the %s backend, since the server does not report modification times.", *backendName)
+		}
+	}
+
+	// the repository password is only needed for the repositories without a
+	// password file in the config
+	if needsPassword() && !allReposHavePasswords() {
+		var err error
+		password, err = readPassword()
+		if err != nil {
+			return err
+		}
+		if password == "" {
+			return fmt.Errorf("The option 'password-file' or 'password-command' or the environment variable RESTIC_PASSWORD needs to be set.")
+		}
+	}
+	return nil
+}
+
+// needsPassword reports whether any of the enabled checks needs to decrypt the
+// repository.
+func needsPassword() bool {
+	if *listHosts || *listTags || *listLocks || *dumpSnapshot != "" {
+		return true
+	}
+	if *selfTest || *benchCycles > 0 {
+		return false
+	}
+	return newestBy != "modtime" || *warnEmptySnapshot || *timeDrift > 0 || *expectRepoVersion > 0 || checksHosts() || *groupBy != "" || checksFuture() || *heartbeatTag != "" || *requiredPathsFile != "" || *pathsChange || *detectReinit || checksRetention() || filtersSnapshots() || *checkLocks && restProtocol()
+}
+
+// checksFuture reports whether future-dated snapshots are to be counted.
+func checksFuture() bool {
+	return *warnFutureCount > 0 || *criticalFutureCount > 0
+}
+
+// checksFreeSpace reports whether the free space of the filesystem holding
+// the repository is queried.
+func checksFreeSpace() bool {
+	return *checkFreeSpaceOpt || *freeWarningStr != "" || *freeCriticalStr != ""
+}
+
+// measuresSize reports whether the size of the repository data is needed.
+func measuresSize() bool {
+	return *dataSubsetStat || sizeWarning > 0 || sizeCritical > 0 || checksGrowth()
+}
+
+// checksGrowth reports whether the growth of the repository data is to be
+// evaluated.
+func checksGrowth() bool {
+	return growthWarning > 0 || growthCritical > 0
+}
+
+// checksHosts reports whether any per-host thresholds are to be evaluated for
+// any of the repositories.
+func checksHosts() bool {
+	for _, repo := range repos {
+		if repo.checksHosts() {
+			return true
+		}
+	}
+	return false
+}
+
+// checksHosts reports whether any per-host thresholds are to be evaluated for
+// the repository.
+func (repo repository) checksHosts() bool {
+	return len(repo.hostRules()) > 0 || defaultHostThreshold != nil
+}
+
+// interactive reports whether a mode meant to be run by hand instead of a
+// regular check was requested.
+func interactive() bool {
+	return *selfTest || *benchCycles > 0 || *listHosts || *listTags || *listLocks || *dumpSnapshot != "" || *waitForSnapshotMode
+}
+
+// detectLocal reports whether the repositories are local since no backend was
+// requested and none of them has an ssh host or user.
+func detectLocal() bool {
+	given := configBackend != ""
+	flag.Visit(func(f *flag.Flag) {
+		given = given || f.Name == "backend"
+	})
+	if given {
+		return false
+	}
+	for _, repo := range repos {
+		if repo.Host != "" || repo.User != "" {
+			return false
+		}
+	}
+	return true
+}
+
+func (repo repository) validate() error {
+	// interactive modes do not evaluate any thresholds, and the age of the
+	// latest snapshot is optional with a schedule
+	if !interactive() && backupSchedule == nil {
+		if repo.Warning < 0 {
+			return fmt.Errorf("The option 'warning' needs to be set and greater than 0.")
+		}
+		if repo.Critical < 0 {
+			return fmt.Errorf("The option 'critical' needs to be set and greater than 0.")
+		}
+	}
+	if *useResticBinary {
+		if repo.Path == "" && !resticRepoFromEnv() {
+			return fmt.Errorf("The option 'repository' or the environment variable RESTIC_REPOSITORY needs to be set.")
+		}
+		return nil
+	}
+	if repo.Path == "" {
+		return fmt.Errorf("The option 'repository' needs to be set.")
+	}
+	if *backendName != "sftp" {
+		return nil
+	}
+	if repo.Host == "" {
+		return fmt.Errorf("The option 'host' needs to be set.")
+	}
+	if repo.User == "" && *sshConfigFile == "" {
+		return fmt.Errorf("The option 'user' needs to be set.")
+	}
+	if _, _, err := splitAddress(repo.Host, repo.Port); err != nil {
+		return fmt.Errorf("The options 'host' and 'port' need to give a valid address: %s", err)
+	}
+	return nil
+}
+
+// normalizeRepoPath cleans up a repository path as copied from elsewhere:
+// duplicate and trailing slashes are removed, as is an accidentally included
+// snapshots directory. SFTP always separates paths with forward slashes.
+func normalizeRepoPath(p string) (string, error) {
+	if strings.Contains(p, "\\") {
+		return "", fmt.Errorf("The option 'repository' must not contain backslashes, SFTP paths are separated by '/': %s", p)
+	}
+	cleaned := path.Clean(p)
+	if path.Base(cleaned) == "snapshots" && path.Dir(cleaned) != "." {
+		verbosef("stripping snapshots directory from repository path %s", p)
+		cleaned = path.Dir(cleaned)
+	}
+	return cleaned, nil
+}
+
+func getStatusStr(status int) string {
+	return checkrestic.StatusName(status)
+}
+
+// worseStatus returns the more severe of the two statuses, ranking CRITICAL
+// over WARNING over UNKNOWN over OK.
+func worseStatus(a, b int) int {
+	return checkrestic.WorseStatus(a, b)
+}
+
+func main() {
+	if runAskpass() {
+		return
+	}
+	rc, out := mainReturnWithStatus()
+	fmt.Print(out)
+	if rc == UNKNOWN {
+		rc = unknownExitCode
+	}
+	os.Exit(rc)
+}
+
+func mainReturnWithStatus() (int, string) {
+	err := parseArgs()
+	if err != nil {
+		// invalid arguments are usually not transient, so keep reporting them
+		// as UNKNOWN unless asked otherwise
+		if !*unknownAsArgsErrs {
+			unknownExitCode = UNKNOWN
+		}
+		return UNKNOWN, formatError(peekOutput(), err.Error())
+	}
+	if *listen != "" {
+		// 'timeout' applies to every round of checks then
+		return runExporter()
+	}
+	if *daemon {
+		return runDaemon()
+	}
+	if *timeout > 0 {
+		return withTimeout(*timeout, run)
+	}
+	return run()
+}
+
+// timeoutGrace is how long a check which timed out may take to clean up.
+const timeoutGrace = time.Second
+
+// withTimeout returns the outcome of f, or UNKNOWN if it does not return
+// within d. The ssh processes are killed via runCtx then, while f is
+// abandoned after the timeoutGrace, since the process exits right after.
+func withTimeout(d time.Duration, f func() (int, string)) (int, string) {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	runCtx = ctx
+
+	type outcome struct {
+		rc  int
+		out string
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		rc, out := f()
+		done <- outcome{rc, out}
+	}()
+	select {
+	case o := <-done:
+		return o.rc, o.out
+	case <-ctx.Done():
+		// give f a moment to notice that the ssh processes were killed and
+		// clean up
+		select {
+		case <-done:
+		case <-time.After(timeoutGrace):
+		}
+		return UNKNOWN, formatError(*output, fmt.Sprintf("timed out after %s", d))
+	}
+}
+
+// run performs what was asked for on the command line once it was parsed.
+func run() (int, string) {
+	defer connections.close()
+	defer rclone.close()
+
+	// all ages are computed using the local clock, so make sure it can be
+	// trusted before blaming the backups
+	if *ntpCheck && !interactive() {
+		offset, err := queryClockOffset(*ntpServer, 5*time.Second)
+		if err != nil {
+			return UNKNOWN, formatError(*output, fmt.Sprintf("unable to query NTP server %s: %s", *ntpServer, err))
+		}
+		if offset > *maxClockSkew || offset < -*maxClockSkew {
+			return UNKNOWN, formatError(*output, fmt.Sprintf("local clock is off by %s according to NTP server %s", offset.Round(time.Millisecond), *ntpServer))
+		}
+	}
+
+	if *benchCycles > 0 {
+		return redacted(runBench())
+	}
+	if *listHosts {
+		return redacted(runListing(checkrestic.ByHost))
+	}
+	if *listTags {
+		return redacted(runListing(checkrestic.ByTag))
+	}
+	if *listLocks {
+		return redacted(runLockListing())
+	}
+	if *dumpSnapshot != "" {
+		return redacted(runDumpSnapshot(*dumpSnapshot))
+	}
+	if *waitForSnapshotMode {
+		return runWait()
+	}
+
+	check := checkRepository
+	if *selfTest {
+		check = runSelfTest
+	} else if *splay > 0 {
+		// interactive modes are not delayed, only regular checks
+		time.Sleep(time.Duration(rand.New(rand.NewSource(time.Now().UnixNano())).Int63n(int64(*splay))))
+	}
+	now := time.Now()
+	rc, results, err := checkAll(check, now)
+	if err != nil {
+		return UNKNOWN, formatError(*output, err.Error())
+	}
+
+	if *statusFile != "" && !interactive() {
+		if err := writeStatusFile(*statusFile, results, now); err != nil {
+			verbosef("unable to write status file: %s", err)
+		}
+	}
+
+	var out string
+	switch *output {
+	case "json":
+		out = formatJSON(results)
+	case "csv":
+		out = formatCSV(results)
+	case "influx":
+		out = formatInflux(results, time.Now())
+	case "sensu":
+		out = formatSensu(results, time.Now())
+	default:
+		out = formatText(results)
+	}
+
+	if *pingURL != "" && !interactive() {
+		ping(rc, out)
+	}
+	return rc, out
+}
+
+// checkAll checks every repository via check and reports the worst status.
+// The state is loaded and saved around the checks, and the results are
+// redacted once everything relying on the real values is done.
+func checkAll(check func(repository) result, now time.Time) (int, []result, error) {
+	var st *state
+	if *stateFile != "" && !interactive() {
+		var err error
+		st, err = loadState(*stateFile)
+		if err != nil {
+			return UNKNOWN, nil, fmt.Errorf("unable to load state: %s", err)
+		}
+	}
+
+	// check every repository on its own and report the worst status
+	rc := OK
+	results := make([]result, 0, len(repos))
+
+	// the repositories are checked concurrently, while everything involving
+	// the state or commands happens in their order afterwards
+	checked := make([]result, len(repos))
+	fresh := make([]bool, len(repos))
+	workers := *parallel
+	if *selfTest {
+		workers = 1
+	}
+	sem := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	for i, repo := range repos {
+		if st != nil && *resultCacheTTL > 0 {
+			if res, ok := st.repo(repo).cachedResult(repo, now); ok {
+				checked[i] = res
+				continue
+			}
+		}
+		fresh[i] = true
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, repo repository) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			checked[i] = check(repo)
+		}(i, repo)
+	}
+	wg.Wait()
+
+	for i, repo := range repos {
+		res := checked[i]
+		if !fresh[i] {
+			rc = worseStatus(rc, res.Status)
+			results = append(results, res)
+			continue
+		}
+		var rs *repoState
+		if st != nil {
+			rs = st.repo(repo)
+		}
+		// only runs which checked the snapshots are compared with the state
+		if rs != nil && res.Checks != nil {
+			var checks []subResult
+			// prune intentionally never runs for append-only repositories
+			if *pruneStaleWindow > 0 && !*appendOnly {
+				checks = append(checks, rs.checkPrune(&res, now))
+			}
+			if *pathsChange {
+				checks = append(checks, rs.checkPaths(&res))
+			}
+			if *detectReinit {
+				checks = append(checks, rs.checkIdentity(&res, now))
+			}
+			if checksGrowth() {
+				checks = append(checks, rs.checkGrowth(&res, now))
+			}
+			if *detectTrends {
+				checks = append(checks, rs.checkTrends(&res, now))
+			}
+			res.addChecks(checks...)
+		}
+		// after the checks against the state, whose alerts are stale ones too
+		if *onlyIfReachable != "" && !*selfTest {
+			gateUnreachableSource(&res)
+		}
+		if rs != nil {
+			status := res.Status
+			if *flapSuppress {
+				rs.suppressFlapping(&res, now)
+			}
+			rs.record(now, status)
+		}
+		// promote per repository, before the worst status of all is taken,
+		// except for stale locks an append-only repository cannot get rid of
+		if *failOnWarning && res.Status == WARNING && !onlyStaleLocks(res) {
+			res.Status = CRITICAL
+		}
+		// the window expires on its own, so it cannot be forgotten
+		if now.Before(maintenanceEnd) && (res.Status == WARNING || res.Status == CRITICAL) {
+			res.Status = OK
+			res.Message += fmt.Sprintf(" (suppressed: maintenance until %s)", maintenanceEnd.Format(time.RFC3339))
+		}
+		// unlike maintenance, this never expires, so the real status stays
+		// visible in the message
+		if repo.Inactive {
+			res.ActualStatus = res.Status
+			if res.Status != OK {
+				res.Status = OK
+				res.Message += fmt.Sprintf(" (inactive, actually %s)", getStatusStr(res.ActualStatus))
+			}
+		}
+		if rs != nil {
+			if rs.report(res.Status) && *onChangeCommand != "" {
+				runOnChange(res)
+			}
+			if *resultCacheTTL > 0 {
+				rs.cache(res, now)
+			}
+		}
+		rc = worseStatus(rc, res.Status)
+		results = append(results, res)
+	}
+	if st != nil {
+		if err := st.save(*stateFile); err != nil {
+			verbosef("unable to save state: %s", err)
+		}
+	}
+
+	// the state and hooks above still see the real values
+	if r := newRedactor(perHostNames(results)); r.active() {
+		r.results(results)
+	}
+	return rc, results, nil
+}
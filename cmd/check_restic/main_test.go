@@ -0,0 +1,354 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// checkStatuses checks one repository for each of the statuses, each of
+// which results in that status, and returns the status of all and the
+// results.
+func checkStatuses(t *testing.T, now time.Time, statuses ...int) (int, []result) {
+	t.Helper()
+	saved := repos
+	defer func() { repos = saved }()
+	repos = nil
+	for i := range statuses {
+		repos = append(repos, repository{Path: fmt.Sprintf("/srv/restic/%d", i)})
+	}
+	check := func(repo repository) result {
+		for i, r := range repos {
+			if r.Path == repo.Path {
+				return result{Repo: repo, Status: statuses[i], Message: "checked"}
+			}
+		}
+		t.Fatalf("unknown repository %s", repo.Path)
+		return result{}
+	}
+	rc, results, err := checkAll(check, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rc, results
+}
+
+func TestFailOnWarning(t *testing.T) {
+	tests := []struct {
+		statuses []int
+		fail     bool
+		want     int
+		each     []int
+	}{
+		{[]int{OK, WARNING}, false, WARNING, []int{OK, WARNING}},
+		{[]int{OK, WARNING}, true, CRITICAL, []int{OK, CRITICAL}},
+		{[]int{OK, OK}, true, OK, []int{OK, OK}},
+		// the promotion happens per repository, so a WARNING is no longer
+		// outranked by an UNKNOWN of another one
+		{[]int{UNKNOWN, WARNING}, false, WARNING, []int{UNKNOWN, WARNING}},
+		{[]int{UNKNOWN, WARNING}, true, CRITICAL, []int{UNKNOWN, CRITICAL}},
+		{[]int{UNKNOWN, OK}, true, UNKNOWN, []int{UNKNOWN, OK}},
+		{[]int{WARNING, CRITICAL, WARNING}, true, CRITICAL, []int{CRITICAL, CRITICAL, CRITICAL}},
+	}
+	saved := *failOnWarning
+	defer func() { *failOnWarning = saved }()
+	for _, tt := range tests {
+		*failOnWarning = tt.fail
+		rc, results := checkStatuses(t, time.Now(), tt.statuses...)
+		if rc != tt.want {
+			t.Errorf("%v with fail-on-warning=%v: got %s, want %s", tt.statuses, tt.fail, getStatusStr(rc), getStatusStr(tt.want))
+		}
+		for i, res := range results {
+			if res.Status != tt.each[i] {
+				t.Errorf("%v with fail-on-warning=%v: repository %d is %s, want %s", tt.statuses, tt.fail, i, getStatusStr(res.Status), getStatusStr(tt.each[i]))
+			}
+		}
+	}
+}
+
+func TestNormalizeRepoPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/srv/restic", "/srv/restic"},
+		{"/srv/restic/", "/srv/restic"},
+		{"/srv/restic///", "/srv/restic"},
+		{"//srv//restic", "/srv/restic"},
+		{"/srv/./restic/../restic", "/srv/restic"},
+		{"/srv/restic/snapshots", "/srv/restic"},
+		{"/srv/restic/snapshots/", "/srv/restic"},
+		{"/srv//restic//snapshots", "/srv/restic"},
+		// relative paths and '~' are left to the server to resolve
+		{"restic", "restic"},
+		{"restic/", "restic"},
+		{"./restic", "restic"},
+		{"restic/snapshots", "restic"},
+		{"~", "~"},
+		{"~/", "~"},
+		{"~/restic//", "~/restic"},
+		{"~/restic/snapshots", "~/restic"},
+		// not a snapshots directory below a repository
+		{"snapshots", "snapshots"},
+		{"/srv/snapshots-old", "/srv/snapshots-old"},
+	}
+	for _, tt := range tests {
+		got, err := normalizeRepoPath(tt.path)
+		if err != nil || got != tt.want {
+			t.Errorf("normalizeRepoPath(%q) = %q, %v, want %q", tt.path, got, err, tt.want)
+		}
+	}
+	for _, p := range []string{`C:\restic`, `/srv\restic`, `\\server\restic`} {
+		if got, err := normalizeRepoPath(p); err == nil {
+			t.Errorf("normalizeRepoPath(%q) = %q, want an error", p, got)
+		}
+	}
+}
+
+func TestMaintenanceWindow(t *testing.T) {
+	end := time.Date(2026, 1, 14, 12, 0, 0, 0, time.UTC)
+	saved := maintenanceEnd
+	defer func() { maintenanceEnd = saved }()
+	maintenanceEnd = end
+
+	tests := []struct {
+		now        time.Time
+		suppressed bool
+	}{
+		{end.Add(-24 * time.Hour), true},
+		{end.Add(-time.Nanosecond), true},
+		{end, false},
+		{end.Add(time.Nanosecond), false},
+	}
+	for _, tt := range tests {
+		rc, results := checkStatuses(t, tt.now, OK, WARNING, CRITICAL, UNKNOWN)
+		want := []int{OK, WARNING, CRITICAL, UNKNOWN}
+		wantRC := CRITICAL
+		if tt.suppressed {
+			want = []int{OK, OK, OK, UNKNOWN}
+			wantRC = UNKNOWN
+		}
+		if rc != wantRC {
+			t.Errorf("at %s: got %s, want %s", tt.now, getStatusStr(rc), getStatusStr(wantRC))
+		}
+		for i, res := range results {
+			annotated := strings.HasSuffix(res.Message, " (suppressed: maintenance until 2026-01-14T12:00:00Z)")
+			if res.Status != want[i] || annotated != (tt.suppressed && (i == 1 || i == 2)) {
+				t.Errorf("at %s: repository %d is %s: %s", tt.now, i, getStatusStr(res.Status), res.Message)
+			}
+		}
+	}
+}
+
+// withArgs sets up parsing the arguments and environment variables like the
+// plugin does, on a fresh command line which shares the values of the
+// options. The options and everything parsed from them are reset afterwards.
+func withArgs(t *testing.T, env map[string]string, args ...string) {
+	t.Helper()
+	savedCommandLine, savedArgs, savedFromEnv := flag.CommandLine, os.Args, fromEnv
+	savedRepos, savedUnknownExitCode, savedUseColor := repos, unknownExitCode, useColor
+	saved := make(map[string]string)
+	lists := make(map[string]stringList)
+	fs := flag.NewFlagSet("check_restic", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	savedCommandLine.VisitAll(func(f *flag.Flag) {
+		if l, ok := f.Value.(*stringList); ok {
+			lists[f.Name] = append(stringList(nil), *l...)
+		}
+		saved[f.Name] = f.Value.String()
+		fs.Var(f.Value, f.Name, f.Usage)
+	})
+	t.Cleanup(func() {
+		// repeatable options are appended to, the other ones are set again
+		fs.Visit(func(f *flag.Flag) {
+			if l, ok := f.Value.(*stringList); ok {
+				*l = lists[f.Name]
+				return
+			}
+			f.Value.Set(saved[f.Name])
+		})
+		flag.CommandLine, os.Args, fromEnv = savedCommandLine, savedArgs, savedFromEnv
+		repos, unknownExitCode, useColor = savedRepos, savedUnknownExitCode, savedUseColor
+	})
+	flag.CommandLine = fs
+	os.Args = append([]string{"check_restic"}, args...)
+	fromEnv = make(map[string]bool)
+	for name, value := range env {
+		t.Setenv(name, value)
+	}
+}
+
+func TestInvalidOptionsAsJSON(t *testing.T) {
+	tests := []struct {
+		env  map[string]string
+		args []string
+		json bool
+	}{
+		{nil, []string{"-output=json", "-warning=abc"}, true},
+		{nil, []string{"-warning=abc", "--output", "json"}, true},
+		{nil, []string{"-output", "json", "-warning", "abc"}, true},
+		{nil, []string{"-warning=2h", "-critical=1h", "-output=json"}, true},
+		// the environment is not applied yet if the options cannot be parsed
+		{map[string]string{outputEnv: "json"}, []string{"-warning=abc"}, true},
+		{map[string]string{outputEnv: "json"}, []string{"-output=text", "-warning=abc"}, false},
+		{nil, []string{"-warning=abc"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			withArgs(t, tt.env, tt.args...)
+			rc, out := mainReturnWithStatus()
+			if rc != UNKNOWN {
+				t.Errorf("got %s", getStatusStr(rc))
+			}
+			if !tt.json {
+				if !strings.HasPrefix(out, "UNKNOWN: ") {
+					t.Errorf("got %q, want text", out)
+				}
+				return
+			}
+			var doc struct {
+				Status       string        `json:"status"`
+				StatusCode   int           `json:"status_code"`
+				Message      string        `json:"message"`
+				Repositories []interface{} `json:"repositories"`
+			}
+			if err := json.Unmarshal([]byte(out), &doc); err != nil {
+				t.Fatalf("got %q, not JSON: %s", out, err)
+			}
+			if doc.Status != "UNKNOWN" || doc.StatusCode != UNKNOWN || doc.Message == "" || doc.Repositories == nil {
+				t.Errorf("got %q", out)
+			}
+		})
+	}
+}
+
+func TestOutputPrecedence(t *testing.T) {
+	tests := []struct {
+		env  string
+		args []string
+		want string
+		err  string
+	}{
+		{"", nil, "text", ""},
+		{"json", nil, "json", ""},
+		{"json", []string{"-output=csv"}, "csv", ""},
+		{"json", []string{"--output", "text"}, "text", ""},
+		{"", []string{"-output=sensu"}, "sensu", ""},
+		{"yaml", nil, "", "The environment variable 'CHECK_RESTIC_OUTPUT' needs to be one of"},
+		{"yaml", []string{"-output=influx"}, "influx", ""},
+		{"json", []string{"-output=yaml"}, "", "The option 'output' needs to be one of"},
+		{"", []string{"-output="}, "", "The option 'output' needs to be one of"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.env+" "+strings.Join(tt.args, " "), func(t *testing.T) {
+			args := append([]string{"-backend=local", "-repository=" + t.TempDir(), "-warning=1h", "-critical=2h"}, tt.args...)
+			env := map[string]string{outputEnv: tt.env}
+			withArgs(t, env, args...)
+			// what is reported if the options cannot be parsed
+			if got := peekOutput(); tt.want != "" && got != tt.want {
+				t.Errorf("peeked %q, want %q", got, tt.want)
+			}
+			err := parseArgs()
+			if tt.err != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+					t.Errorf("got %v, want %s...", err, tt.err)
+				}
+				return
+			}
+			if err != nil || *output != tt.want {
+				t.Errorf("got %q, %v, want %q", *output, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestColorOnlyInText(t *testing.T) {
+	for _, format := range []string{"text", "json", "csv", "influx", "sensu"} {
+		t.Run(format, func(t *testing.T) {
+			withArgs(t, nil, "-backend=local", "-repository="+t.TempDir(), "-warning=1h", "-critical=2h", "-color=always", "-output="+format)
+			if err := parseArgs(); err != nil {
+				t.Fatal(err)
+			}
+			if useColor != (format == "text") {
+				t.Errorf("colorized: %v", useColor)
+			}
+			if format != "sensu" {
+				return
+			}
+			// the event embeds the text output
+			for _, out := range []string{formatSensu(testResults(), time.Now()), formatError(format, "unable to connect")} {
+				if strings.Contains(out, "\x1b") || strings.Contains(out, "\\u001b") {
+					t.Errorf("got escape codes in %s", out)
+				}
+			}
+		})
+	}
+}
+
+func TestOptionPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	cfg := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(cfg, []byte("backend: local\nrepository: "+dir+"\nwarning: 3h\ncritical: 6h\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		env  string
+		args []string
+		want time.Duration
+	}{
+		{"", nil, 3 * time.Hour},
+		{"2h", nil, 2 * time.Hour},
+		{"", []string{"-warning=1h"}, time.Hour},
+		{"2h", []string{"-warning=1h"}, time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.env+" "+strings.Join(tt.args, " "), func(t *testing.T) {
+			withArgs(t, map[string]string{envVar("warning"): tt.env}, append([]string{"-config=" + cfg}, tt.args...)...)
+			if err := parseArgs(); err != nil {
+				t.Fatal(err)
+			}
+			if len(repos) != 1 || repos[0].Warning != tt.want || repos[0].Critical != 6*time.Hour {
+				t.Errorf("got %+v, want a warning of %s", repos, tt.want)
+			}
+		})
+	}
+}
+
+func TestPKCS11Options(t *testing.T) {
+	tests := []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"-pkcs11-slot=0"}, "The options 'pkcs11-slot' and 'pkcs11-label' need 'pkcs11-lib' to be set."},
+		{[]string{"-pkcs11-label=backup"}, "The options 'pkcs11-slot' and 'pkcs11-label' need 'pkcs11-lib' to be set."},
+		{[]string{"-pkcs11-lib=/usr/lib/softhsm/libsofthsm2.so", "-pkcs11-label=backup"}, "The options 'pkcs11-slot' and 'pkcs11-label' need 'ssh-client=native'"},
+		{[]string{"-pkcs11-lib=/usr/lib/softhsm/libsofthsm2.so"}, ""},
+	}
+	if pkcs11Supported {
+		tests = append(tests, struct {
+			args []string
+			err  string
+		}{[]string{"-ssh-client=native", "-pkcs11-lib=/usr/lib/softhsm/libsofthsm2.so", "-pkcs11-slot=1", "-pkcs11-label=backup"}, ""})
+	} else {
+		tests = append(tests, struct {
+			args []string
+			err  string
+		}{[]string{"-ssh-client=native", "-pkcs11-lib=/usr/lib/softhsm/libsofthsm2.so"}, "The option 'pkcs11-lib' needs 'ssh-client=openssh' since this binary was built without PKCS#11 support"})
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			args := append([]string{"-backend=sftp", "-host=backup.example.com", "-user=u", "-repository=/srv/restic", "-warning=1h", "-critical=2h"}, tt.args...)
+			withArgs(t, nil, args...)
+			err := parseArgs()
+			if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.HasPrefix(err.Error(), tt.err)) {
+				t.Errorf("got %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,500 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"os/exec"
+	osuser "os/user"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// nativeTimeout limits establishing the TCP connection and the ssh handshake
+// of the native client, which has no ssh_config to take ConnectTimeout from.
+const nativeTimeout = 30 * time.Second
+
+// defaultIdentities are the private keys the native client tries if neither
+// 'identity' nor 'ssh-agent' is given, in the order ssh tries them.
+var defaultIdentities = []string{"id_ed25519", "id_ecdsa", "id_rsa"}
+
+// nativeConfig returns the ssh client configuration of the native client,
+// authenticating with the identity unless it is empty. The returned function
+// disconnects from the ssh agent, if any.
+func nativeConfig(user, identity string) (*ssh.ClientConfig, func(), error) {
+	home, _ := os.UserHomeDir()
+	var auth []ssh.AuthMethod
+	cleanup := func() {}
+
+	if *sshAgent {
+		sock, err := agentSocket()
+		if err != nil {
+			return nil, nil, err
+		}
+		conn, err := net.Dial("unix", sock)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to connect to the ssh agent: %s", err)
+		}
+		cleanup = func() { conn.Close() }
+		auth = append(auth, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+	}
+
+	passphrase, err := keyPassphrase()
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	var signers []ssh.Signer
+	if identity != "" {
+		signer, err := loadIdentity(identity, passphrase)
+		if err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+		signers = append(signers, signer)
+	}
+	if *pkcs11Lib != "" {
+		signer, err := pkcs11Signer()
+		if err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+		signers = append(signers, signer)
+	}
+	if identity == "" && *pkcs11Lib == "" && !*sshAgent && home != "" {
+		for _, name := range defaultIdentities {
+			signer, err := loadIdentity(filepath.Join(home, ".ssh", name), passphrase)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			} else if err != nil {
+				verbosef("skipping identity: %s", err)
+				continue
+			}
+			signers = append(signers, signer)
+		}
+	}
+	if len(signers) > 0 {
+		auth = append(auth, ssh.PublicKeys(signers...))
+	}
+	if len(auth) == 0 {
+		cleanup()
+		return nil, nil, errors.New("no identity found for the native ssh client, use 'identity', 'ssh-agent' or 'pkcs11-lib'")
+	}
+
+	hostKeys, err := hostKeyCallback(home)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+
+	return &ssh.ClientConfig{
+		User:            user,
+		Auth:            auth,
+		HostKeyCallback: hostKeys,
+		Timeout:         nativeTimeout,
+	}, cleanup, nil
+}
+
+// errHostKeyChanged is returned if the host presents a key other than the
+// known or pinned one, which may be an attack and is therefore CRITICAL.
+var errHostKeyChanged = errors.New("host key changed")
+
+// hostKeyCallback verifies the host key against the fingerprints of
+// 'host-key-fingerprint' if it is set, or else against the known hosts.
+func hostKeyCallback(home string) (ssh.HostKeyCallback, error) {
+	if *hostKeyFingerprint != "" {
+		pinned := strings.Split(*hostKeyFingerprint, ",")
+		return func(host string, remote net.Addr, key ssh.PublicKey) error {
+			got := ssh.FingerprintSHA256(key)
+			for _, fp := range pinned {
+				if strings.TrimSpace(fp) == got {
+					return nil
+				}
+			}
+			return fmt.Errorf("%w: %s presented %s", errHostKeyChanged, host, got)
+		}, nil
+	}
+
+	knownHosts := *knownHostsFile
+	if knownHosts == "" {
+		knownHosts = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeys, err := knownhosts.New(knownHosts)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the known hosts: %s", err)
+	}
+	return func(host string, remote net.Addr, key ssh.PublicKey) error {
+		err := hostKeys(host, remote, key)
+		var keyErr *knownhosts.KeyError
+		// an unknown host is merely a configuration problem
+		if errors.As(err, &keyErr) && len(keyErr.Want) > 0 {
+			return fmt.Errorf("%w: %s presented %s, which does not match %s", errHostKeyChanged, host, ssh.FingerprintSHA256(key), knownHosts)
+		}
+		return err
+	}, nil
+}
+
+// loadIdentity reads a private key in any format ssh-keygen writes, which is
+// decrypted with the passphrase if it is encrypted.
+func loadIdentity(name string, passphrase []byte) (ssh.Signer, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(data)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		if passphrase == nil {
+			return nil, fmt.Errorf("%s: the key is encrypted, use 'identity-passphrase-file' or load it into an agent and use 'ssh-agent' instead", name)
+		}
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(data, passphrase)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+	return signer, nil
+}
+
+// dialNative opens an SFTP session to the host of the repository without an
+// ssh binary. The returned function closes the session and the connection.
+func dialNative(repo repository) (*sftp.Client, func(), error) {
+	host, port, user, identity := resolveNative(repo)
+	config, cleanup, err := nativeConfig(user, identity)
+	if err != nil {
+		return nil, nil, err
+	}
+	// the handshake does not wrap the error of the callback, so keep it
+	var keyErr error
+	verify := config.HostKeyCallback
+	config.HostKeyCallback = func(host string, remote net.Addr, key ssh.PublicKey) error {
+		keyErr = verify(host, remote, key)
+		return keyErr
+	}
+	debugf("connect", "dialing", "addr", net.JoinHostPort(host, port), "user", user, "jump_hosts", len(jumpHosts))
+	start := time.Now()
+	conn, closeHops, err := dialVia(jumpHosts, net.JoinHostPort(host, port), config)
+	debugf("handshake", "ssh handshake done", "addr", net.JoinHostPort(host, port), "took", time.Since(start), "error", err)
+	if err != nil {
+		cleanup()
+		if errors.Is(keyErr, errHostKeyChanged) {
+			return nil, nil, keyErr
+		}
+		return nil, nil, err
+	}
+	disconnect := func() {
+		closeHops()
+		cleanup()
+	}
+
+	session, err := conn.NewSession()
+	if err != nil {
+		disconnect()
+		return nil, nil, err
+	}
+	if err := session.RequestSubsystem("sftp"); err != nil {
+		disconnect()
+		return nil, nil, fmt.Errorf("unable to start the sftp subsystem: %s", err)
+	}
+	wr, err := session.StdinPipe()
+	if err != nil {
+		disconnect()
+		return nil, nil, err
+	}
+	rd, err := session.StdoutPipe()
+	if err != nil {
+		disconnect()
+		return nil, nil, err
+	}
+
+	client, err := sftp.NewClientPipe(countingReader{rd, &bytesReceived}, countingWriter{wr, &bytesSent})
+	if err != nil {
+		disconnect()
+		return nil, nil, err
+	}
+	debugf("handshake", "sftp session established", "addr", net.JoinHostPort(host, port), "took", time.Since(start))
+	return client, func() {
+		client.Close()
+		session.Close()
+		disconnect()
+	}, nil
+}
+
+// resolveNative returns the address, user and identity the native client
+// connects to the host of the repository with. The settings of the
+// 'ssh-config' for the host apply unless given by the options, where the
+// default port counts as not given.
+func resolveNative(repo repository) (host, port, user, identity string) {
+	host, port, user, identity = repo.Host, repo.Port, repo.User, *identityFile
+	if sshConfig == nil {
+		return
+	}
+	hc := lookupSSHConfig(repo.Host)
+	if hc.HostName != "" {
+		host = hc.HostName
+	}
+	if hc.Port != "" && port == defaultPort {
+		port = hc.Port
+	}
+	if hc.User != "" && user == "" {
+		user = hc.User
+	}
+	if hc.IdentityFile != "" && identity == "" {
+		identity = hc.IdentityFile
+	}
+	if user == "" {
+		if u, err := osuser.Current(); err == nil {
+			user = u.Username
+		}
+	}
+	return
+}
+
+// jumpHost is one of the hosts of 'proxy-jump'.
+type jumpHost struct {
+	User string
+	Host string
+	Port string
+}
+
+func (h jumpHost) String() string {
+	if h.User != "" {
+		return h.User + "@" + net.JoinHostPort(h.Host, h.Port)
+	}
+	return net.JoinHostPort(h.Host, h.Port)
+}
+
+// parseJumpHosts parses the comma-separated '[user@]host[:port]' of
+// 'proxy-jump', like 'ssh -J'. The user defaults to that of the repository.
+func parseJumpHosts(s string) ([]jumpHost, error) {
+	var hosts []jumpHost
+	for _, part := range strings.Split(s, ",") {
+		var h jumpHost
+		part = strings.TrimSpace(part)
+		if i := strings.LastIndex(part, "@"); i >= 0 {
+			h.User, part = part[:i], part[i+1:]
+		}
+		h.Host, h.Port = part, "22"
+		if host, port, err := net.SplitHostPort(part); err == nil {
+			h.Host, h.Port = host, port
+		}
+		if h.Host == "" || strings.ContainsAny(h.Host, " /") {
+			return nil, fmt.Errorf("invalid jump host %q", part)
+		}
+		hosts = append(hosts, h)
+	}
+	return hosts, nil
+}
+
+// jumpHosts is the parsed value of the 'proxy-jump' option.
+var jumpHosts []jumpHost
+
+// dialVia connects to addr through each of the hops in turn, like 'ssh -J',
+// or else through the 'proxy-command'. All hops are authenticated and
+// verified like the final host. The returned function closes all connections.
+func dialVia(hops []jumpHost, addr string, config *ssh.ClientConfig) (*ssh.Client, func(), error) {
+	var clients []*ssh.Client
+	closeAll := func() {
+		for i := len(clients) - 1; i >= 0; i-- {
+			clients[i].Close()
+		}
+	}
+	dial := func(addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+		// tunneled connections have no deadlines, so give up on a jump host
+		// or proxy command which does not respond by closing it
+		var connect func() (net.Conn, error)
+		var abort func()
+		var proxy *proxyConn
+		via := "the jump host"
+		switch {
+		case len(clients) > 0:
+			prev := clients[len(clients)-1]
+			connect = func() (net.Conn, error) { return prev.Dial("tcp", addr) }
+			abort = func() { prev.Close() }
+		case *proxyCommand != "":
+			var err error
+			proxy, err = dialProxyCommand(addr)
+			if err != nil {
+				return nil, err
+			}
+			connect = func() (net.Conn, error) { return proxy, nil }
+			abort = func() { proxy.Close() }
+			via = "the proxy command"
+		default:
+			return ssh.Dial("tcp", addr, config)
+		}
+		type dialed struct {
+			client *ssh.Client
+			err    error
+		}
+		done := make(chan dialed, 1)
+		go func() {
+			conn, err := connect()
+			if err != nil {
+				done <- dialed{nil, err}
+				return
+			}
+			c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+			if err != nil {
+				conn.Close()
+				done <- dialed{nil, err}
+				return
+			}
+			done <- dialed{ssh.NewClient(c, chans, reqs), nil}
+		}()
+		select {
+		case d := <-done:
+			if d.err != nil && proxy != nil {
+				return nil, proxy.explain(d.err)
+			}
+			return d.client, d.err
+		case <-time.After(config.Timeout):
+			abort()
+			return nil, fmt.Errorf("%s: no response via %s within %s", addr, via, config.Timeout)
+		}
+	}
+
+	for _, hop := range hops {
+		hopConfig := *config
+		if hop.User != "" {
+			hopConfig.User = hop.User
+		}
+		client, err := dial(net.JoinHostPort(hop.Host, hop.Port), &hopConfig)
+		if err != nil {
+			closeAll()
+			return nil, nil, fmt.Errorf("unable to connect to jump host %s: %s", hop, err)
+		}
+		verbosef("connected to jump host %s", hop)
+		clients = append(clients, client)
+	}
+	client, err := dial(addr, config)
+	if err != nil {
+		closeAll()
+		return nil, nil, err
+	}
+	clients = append(clients, client)
+	return client, closeAll, nil
+}
+
+// proxyConn is the connection to the host through the 'proxy-command', which
+// is expected to relay its stdin and stdout to the ssh port like for the
+// ProxyCommand of ssh.
+type proxyConn struct {
+	io.Reader
+	io.WriteCloser
+	cmd   *exec.Cmd
+	addr  proxyAddr
+	close sync.Once
+
+	// stderr is copied from the command until copied is closed
+	stderr syncBuffer
+	copied chan struct{}
+}
+
+// syncBuffer is a bytes.Buffer which may be written while it is read.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// proxyAddr is the address the 'proxy-command' connects to, for verifying
+// the host key against the known hosts.
+type proxyAddr string
+
+func (a proxyAddr) Network() string { return "tcp" }
+func (a proxyAddr) String() string  { return string(a) }
+
+// dialProxyCommand starts the 'proxy-command' with its '%h' and '%p' tokens
+// replaced by the host and port of addr, and '%%' by a '%'.
+func dialProxyCommand(addr string) (*proxyConn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	command := strings.NewReplacer("%%", "%", "%h", host, "%p", port).Replace(*proxyCommand)
+	c := &proxyConn{addr: proxyAddr(addr), copied: make(chan struct{})}
+	c.cmd = exec.CommandContext(connCtx(), "sh", "-c", command)
+	if c.WriteCloser, err = c.cmd.StdinPipe(); err != nil {
+		return nil, err
+	}
+	if c.Reader, err = c.cmd.StdoutPipe(); err != nil {
+		return nil, err
+	}
+	// processes started in the background by the command may keep stderr
+	// open, so it is copied here rather than by exec, whose Wait would wait
+	// for them
+	rd, wr, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	c.cmd.Stderr = wr
+	debugf("connect", "starting the proxy command", "addr", addr, "command", command)
+	err = c.cmd.Start()
+	wr.Close()
+	if err != nil {
+		rd.Close()
+		return nil, fmt.Errorf("unable to start the proxy command: %s", err)
+	}
+	go func() {
+		defer close(c.copied)
+		defer rd.Close()
+		io.Copy(io.MultiWriter(&logWriter{step: "proxy"}, &c.stderr), rd)
+	}()
+	return c, nil
+}
+
+// Close closes stdin of the command and stops it, since a proxy like nc may
+// not exit on its own.
+func (c *proxyConn) Close() error {
+	var err error
+	c.close.Do(func() {
+		err = c.WriteCloser.Close()
+		c.cmd.Process.Kill()
+		c.cmd.Wait()
+	})
+	return err
+}
+
+// explain stops the command and adds its output on stderr to the error, which
+// tells why the connection failed far better than the ssh handshake.
+func (c *proxyConn) explain(err error) error {
+	c.Close()
+	select {
+	case <-c.copied:
+	case <-time.After(time.Second):
+	}
+	if msg := strings.TrimSpace(c.stderr.String()); msg != "" {
+		return fmt.Errorf("%s (%s)", err, strings.Join(strings.Split(msg, "\n"), "; "))
+	}
+	return err
+}
+
+func (c *proxyConn) LocalAddr() net.Addr  { return proxyAddr("") }
+func (c *proxyConn) RemoteAddr() net.Addr { return c.addr }
+
+// The ssh package does not use deadlines, the handshake is limited by dialVia.
+func (c *proxyConn) SetDeadline(t time.Time) error      { return nil }
+func (c *proxyConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *proxyConn) SetWriteDeadline(t time.Time) error { return nil }
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+func TestDialProxyCommand(t *testing.T) {
+	setFlag(t, proxyCommand, "printf '%s' '%h %p 100%%'")
+	conn, err := dialProxyCommand("backup.example.com:2222")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	out, err := io.ReadAll(conn)
+	if err != nil || string(out) != "backup.example.com 2222 100%" {
+		t.Errorf("got %q, %v", out, err)
+	}
+	if got := conn.RemoteAddr().String(); got != "backup.example.com:2222" {
+		t.Errorf("remote address %s", got)
+	}
+}
+
+func TestDialProxyCommandRelays(t *testing.T) {
+	// the proxy is closed even if it would never exit on its own
+	setFlag(t, proxyCommand, "sleep 5 & exec cat")
+	conn, err := dialProxyCommand("backup.example.com:22")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(conn, "SSH-2.0-test\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len("SSH-2.0-test\r\n"))
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "SSH-2.0-test\r\n" {
+		t.Errorf("got %q, %v", buf, err)
+	}
+	closed := make(chan struct{})
+	go func() {
+		conn.Close()
+		conn.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(10 * time.Second):
+		t.Fatal("closing the proxy command hangs")
+	}
+}
+
+func TestDialProxyCommandExplains(t *testing.T) {
+	setFlag(t, proxyCommand, "echo 'no route to %h' >&2; echo 'giving up' >&2; exit 1")
+	conn, err := dialProxyCommand("backup.example.com:22")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the handshake fails once the command exited
+	if out, err := io.ReadAll(conn); err != nil || len(out) > 0 {
+		t.Fatalf("got %q, %v", out, err)
+	}
+	err = conn.explain(io.EOF)
+	if err == nil || err.Error() != "EOF (no route to backup.example.com; giving up)" {
+		t.Errorf("got %v", err)
+	}
+}
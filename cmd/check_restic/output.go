@@ -0,0 +1,491 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// formatText renders the results in the classic plugin format: a single
+// status line for one repository, or a summary line followed by one line per
+// repository.
+func formatText(results []result) string {
+	rc, msg := summarize(results)
+	if *summarizeText && len(results) > 1 {
+		msg = countStatuses(results)
+	}
+	if perf := perfdata(results); perf != "" {
+		msg += " | " + perf
+	}
+	if len(results) == 1 {
+		return fmt.Sprintf("%s: %s\n", colorStatusStr(rc), msg)
+	}
+
+	var b strings.Builder
+	for _, res := range results {
+		if *summarizeText && res.Status == OK {
+			continue
+		}
+		fmt.Fprintf(&b, "[%s] %s: %s\n", res.Repo.Label, colorStatusStr(res.Status), res.Message)
+	}
+	return fmt.Sprintf("%s: %s\n%s", colorStatusStr(rc), msg, b.String())
+}
+
+// perfdata renders the performance data of all results in the plugin format:
+// the age of the latest snapshot along with the thresholds, the number of
+// snapshots and whatever else was determined. Labels are prefixed by the
+// repository if there are several. Sizes which were
+// extrapolated from a sample are labelled as estimates, since the format
+// itself cannot express that.
+func perfdata(results []result) string {
+	return strings.Join(perfdataList(results), " ")
+}
+
+// perfdataList returns the values of perfdata one by one.
+func perfdataList(results []result) []string {
+	var perf []string
+	add := func(res result, label, value string) {
+		if len(results) > 1 {
+			label = res.Repo.Label + " " + label
+		}
+		perf = append(perf, fmt.Sprintf("'%s'=%s", strings.ReplaceAll(label, "'", "''"), value))
+	}
+	for _, res := range results {
+		if res.hasLatest() && res.Age >= 0 {
+			add(res, "age", fmt.Sprintf("%ds;%s;%s;0", int64(res.Age.Seconds()), perfThreshold(int64(res.Repo.Warning.Seconds())), perfThreshold(int64(res.Repo.Critical.Seconds()))))
+		}
+		if *maxOldest > 0 && res.Snapshots > 0 && !res.Oldest.IsZero() {
+			add(res, "oldest_age", fmt.Sprintf("%ds;%d;;0", int64(time.Since(res.Oldest).Seconds()), int64(maxOldest.Seconds())))
+		}
+		if res.IntegrityCheckAge != nil {
+			add(res, "integrity_check_age", fmt.Sprintf("%ds;%s;%s;0", int64(res.IntegrityCheckAge.Seconds()), perfThreshold(int64(integrityWarning.Seconds())), perfThreshold(int64(integrityCritical.Seconds()))))
+		}
+		if res.CadenceGap != nil {
+			add(res, "cadence_gap", fmt.Sprintf("%ds;%s;%s;0", int64(res.CadenceGap.Seconds()), perfThreshold(int64(cadenceWarning.Seconds())), perfThreshold(int64(cadenceCritical.Seconds()))))
+		}
+		if res.MissedRuns != nil {
+			add(res, "missed_runs", fmt.Sprintf("%d;%s;%s;0", *res.MissedRuns, perfThreshold(int64(*scheduleMissedWarn)), perfThreshold(int64(*scheduleMissedCrit))))
+		}
+		if res.Snapshots >= 0 {
+			add(res, "snapshots", fmt.Sprintf("%d;%s;%s;0", res.Snapshots, minRange(*minSnapshotsWarn), minRange(*minSnapshotsCrit)))
+		}
+		if res.Locks != nil {
+			add(res, "locks", fmt.Sprintf("%d;;;0", res.Locks.Count))
+			add(res, "oldest_lock", fmt.Sprintf("%ds;%d;;0", int64(res.Locks.OldestAge.Seconds()), int64(staleLockAge.Seconds())))
+		}
+		if res.Headroom > 0 {
+			add(res, "headroom", fmt.Sprintf("%ds;;;0", int64(res.Headroom.Seconds())))
+		}
+		if res.DataSizeSampled > 0 {
+			label := "data_size"
+			if res.DataSizeSampled < 100 {
+				label = "data_size_estimate"
+			}
+			add(res, label, fmt.Sprintf("%dB;%s;%s;0", res.DataSize, perfThreshold(sizeWarning), perfThreshold(sizeCritical)))
+		}
+		if res.FreeSpace != nil {
+			total := res.FreeSpace.Total
+			add(res, "free_space", fmt.Sprintf("%dB;%s;%s;0;%d", res.FreeSpace.Available, freeRange(freeWarning, total), freeRange(freeCritical, total), total))
+		}
+		if res.DataGrowth != nil {
+			add(res, "data_growth", fmt.Sprintf("%dB;%s;%s", *res.DataGrowth, perfThreshold(growthWarning), perfThreshold(growthCritical)))
+		}
+		for _, h := range res.PerHost {
+			add(res, "count_"+perfdataLabelSanitizer.Replace(h.Host), fmt.Sprintf("%d;;;0", h.Count))
+		}
+	}
+	return perf
+}
+
+// perfThreshold returns the perfdata threshold for a limit, if it is set.
+func perfThreshold(limit int64) string {
+	if limit <= 0 {
+		return ""
+	}
+	return fmt.Sprint(limit)
+}
+
+// freeRange returns the perfdata threshold alerting below the free space
+// limit, if it is set.
+func freeRange(limit freeLimit, total int64) string {
+	if b := limit.bytes(total); b > 0 {
+		return fmt.Sprintf("%d:", b)
+	}
+	return ""
+}
+
+// minRange returns the perfdata threshold alerting below min, if it is set.
+func minRange(min int) string {
+	if min <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d:", min)
+}
+
+// perfdataLabelSanitizer replaces the characters of hostnames which would
+// break perfdata labels or the tools parsing them.
+var perfdataLabelSanitizer = strings.NewReplacer(" ", "_", "'", "_", "=", "_", "|", "_", ";", "_", ",", "_", "\t", "_")
+
+// colorStatusStr returns the name of status, highlighted using ANSI escape
+// codes if the 'color' option asks for it.
+func colorStatusStr(status int) string {
+	if !useColor {
+		return getStatusStr(status)
+	}
+	codes := map[int]string{OK: "32", WARNING: "33", CRITICAL: "31", UNKNOWN: "35"}
+	code, ok := codes[status]
+	if !ok {
+		code = codes[UNKNOWN]
+	}
+	return fmt.Sprintf("\x1b[%sm%s\x1b[0m", code, getStatusStr(status))
+}
+
+// summarize returns the worst status of all results and a message describing
+// them as a whole.
+func summarize(results []result) (int, string) {
+	if len(results) == 1 {
+		return results[0].Status, results[0].Message
+	}
+	rc := OK
+	for _, res := range results {
+		rc = worseStatus(rc, res.Status)
+	}
+	return rc, fmt.Sprintf("checked %d repositories", len(results))
+}
+
+// countStatuses renders the number of repositories per status, e.g.
+// "185 OK, 12 WARNING, 3 CRITICAL", omitting statuses no repository has.
+func countStatuses(results []result) string {
+	counts := make(map[int]int)
+	for _, res := range results {
+		counts[res.Status]++
+	}
+	var parts []string
+	for _, status := range []int{OK, WARNING, CRITICAL, UNKNOWN} {
+		if counts[status] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[status], getStatusStr(status)))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+type jsonOutput struct {
+	Status       string           `json:"status"`
+	StatusCode   int              `json:"status_code"`
+	Message      string           `json:"message"`
+	Repositories []jsonRepository `json:"repositories"`
+	// GeneratedAt is only set in the 'status-file'.
+	GeneratedAt *time.Time `json:"generated_at,omitempty"`
+}
+
+type jsonRepository struct {
+	Repository               string      `json:"repository"`
+	Label                    string      `json:"label,omitempty"`
+	Host                     string      `json:"host,omitempty"`
+	Status                   string      `json:"status"`
+	StatusCode               int         `json:"status_code"`
+	Message                  string      `json:"message"`
+	SnapshotCount            *int        `json:"snapshot_count,omitempty"`
+	LatestSnapshotID         string      `json:"latest_snapshot_id,omitempty"`
+	LatestSnapshotTime       *time.Time  `json:"latest_snapshot_time,omitempty"`
+	LatestSnapshotHost       string      `json:"latest_snapshot_host,omitempty"`
+	LatestSnapshotTags       []string    `json:"latest_snapshot_tags,omitempty"`
+	LatestSnapshotPaths      []string    `json:"latest_snapshot_paths,omitempty"`
+	AgeSeconds               *int64      `json:"age_seconds,omitempty"`
+	RepositoryVersion        int         `json:"repository_version,omitempty"`
+	DataSizeBytes            *int64      `json:"data_size_bytes,omitempty"`
+	DataSizeSampledPct       int         `json:"data_size_sampled_pct,omitempty"`
+	PerHost                  []jsonHost  `json:"per_host,omitempty"`
+	HeadroomSeconds          *int64      `json:"headroom_seconds,omitempty"`
+	DataGrowthPerDay         *int64      `json:"data_growth_bytes_per_day,omitempty"`
+	MissedRuns               *int        `json:"missed_runs,omitempty"`
+	IntegrityCheckAgeSeconds *int64      `json:"integrity_check_age_seconds,omitempty"`
+	CadenceGapSeconds        *int64      `json:"cadence_gap_seconds,omitempty"`
+	FreeSpaceBytes           *int64      `json:"free_space_bytes,omitempty"`
+	TotalSpaceBytes          *int64      `json:"total_space_bytes,omitempty"`
+	LockCount                *int        `json:"lock_count,omitempty"`
+	StaleLockCount           *int        `json:"stale_lock_count,omitempty"`
+	Inactive                 bool        `json:"inactive,omitempty"`
+	ActualStatus             string      `json:"actual_status,omitempty"`
+	ActualStatusCode         *int        `json:"actual_status_code,omitempty"`
+	Checks                   []jsonCheck `json:"checks,omitempty"`
+}
+
+// jsonCheck is the outcome of one of the checks of a repository, before any
+// adjustment like 'fail-on-warning' or a maintenance window.
+type jsonCheck struct {
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	StatusCode int    `json:"status_code"`
+	Message    string `json:"message,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+type jsonHost struct {
+	Host             string `json:"host"`
+	SnapshotCount    int    `json:"snapshot_count"`
+	NewestAgeSeconds int64  `json:"newest_age_seconds"`
+}
+
+func jsonChecks(checks []subResult) []jsonCheck {
+	var out []jsonCheck
+	for _, c := range checks {
+		jc := jsonCheck{Name: c.Name, Status: getStatusStr(c.Status), StatusCode: c.Status, Message: c.Message}
+		if c.Err != nil {
+			jc.Error = c.Err.Error()
+		}
+		out = append(out, jc)
+	}
+	return out
+}
+
+// formatJSON renders the results as a single JSON document.
+func formatJSON(results []result) string {
+	data, err := json.Marshal(jsonDocument(results))
+	if err != nil {
+		return fmt.Sprintf("{\"status\":\"UNKNOWN\",\"status_code\":%d,\"message\":%q}\n", UNKNOWN, err.Error())
+	}
+	return string(data) + "\n"
+}
+
+// writeStatusFile atomically replaces the 'status-file' with the results in
+// the format of 'output=json', so that its readers never see a partially
+// written file, not even while several checks update it at once.
+func writeStatusFile(name string, results []result, now time.Time) error {
+	out := jsonDocument(results)
+	generated := now.UTC()
+	out.GeneratedAt = &generated
+	data, err := json.Marshal(out)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(name, append(data, '\n'))
+}
+
+func jsonDocument(results []result) jsonOutput {
+	rc, msg := summarize(results)
+	out := jsonOutput{
+		Status:       getStatusStr(rc),
+		StatusCode:   rc,
+		Message:      msg,
+		Repositories: make([]jsonRepository, 0, len(results)),
+	}
+	for _, res := range results {
+		repo := jsonRepository{
+			Repository: res.Repo.Path,
+			Host:       res.Repo.Host,
+			Status:     getStatusStr(res.Status),
+			StatusCode: res.Status,
+			Message:    res.Message,
+
+			RepositoryVersion: res.RepoVersion,
+		}
+		if res.Snapshots >= 0 {
+			count := res.Snapshots
+			repo.SnapshotCount = &count
+		}
+		if res.hasLatest() {
+			latest, age := res.Latest.UTC(), int64(res.Age.Seconds())
+			repo.LatestSnapshotID = shortID(res.LatestID)
+			repo.LatestSnapshotTime = &latest
+			repo.AgeSeconds = &age
+		}
+		repo.LatestSnapshotHost, repo.LatestSnapshotTags, repo.LatestSnapshotPaths = res.LatestHost, res.LatestTags, res.LatestPaths
+		if res.Repo.Label != res.Repo.Path {
+			repo.Label = res.Repo.Label
+		}
+		if res.Headroom > 0 {
+			headroom := int64(res.Headroom.Seconds())
+			repo.HeadroomSeconds = &headroom
+		}
+		if res.DataGrowth != nil {
+			growth := *res.DataGrowth
+			repo.DataGrowthPerDay = &growth
+		}
+		if res.IntegrityCheckAge != nil {
+			age := int64(res.IntegrityCheckAge.Seconds())
+			repo.IntegrityCheckAgeSeconds = &age
+		}
+		if res.CadenceGap != nil {
+			gap := int64(res.CadenceGap.Seconds())
+			repo.CadenceGapSeconds = &gap
+		}
+		if res.FreeSpace != nil {
+			free, total := res.FreeSpace.Available, res.FreeSpace.Total
+			repo.FreeSpaceBytes, repo.TotalSpaceBytes = &free, &total
+		}
+		if res.MissedRuns != nil {
+			missed := *res.MissedRuns
+			repo.MissedRuns = &missed
+		}
+		if res.Locks != nil {
+			count, stale := res.Locks.Count, res.Locks.Stale
+			repo.LockCount, repo.StaleLockCount = &count, &stale
+		}
+		for _, h := range res.PerHost {
+			repo.PerHost = append(repo.PerHost, jsonHost{Host: h.Host, SnapshotCount: h.Count, NewestAgeSeconds: int64(h.NewestAge.Seconds())})
+		}
+		if res.Repo.Inactive {
+			code := res.ActualStatus
+			repo.Inactive = true
+			repo.ActualStatus = getStatusStr(code)
+			repo.ActualStatusCode = &code
+		}
+		repo.Checks = jsonChecks(res.Checks)
+		if res.DataSizeSampled > 0 {
+			size := res.DataSize
+			repo.DataSizeBytes = &size
+			repo.DataSizeSampledPct = res.DataSizeSampled
+		}
+		out.Repositories = append(out.Repositories, repo)
+	}
+	return out
+}
+
+// formatError renders an error which prevented checking any repository, as
+// JSON or a Sensu event if requested and as text otherwise.
+func formatError(format, msg string) string {
+	msg = newRedactor(nil).text(msg)
+	text := fmt.Sprintf("%s: %s\n", colorStatusStr(UNKNOWN), msg)
+	var v interface{}
+	switch format {
+	case "json":
+		v = jsonOutput{Status: getStatusStr(UNKNOWN), StatusCode: UNKNOWN, Message: msg, Repositories: []jsonRepository{}}
+	case "sensu":
+		var event sensuEvent
+		event.Check.Metadata.Name = "check_restic"
+		event.Check.Status = UNKNOWN
+		event.Check.Output = text
+		event.Check.Executed = time.Now().Unix()
+		event.Metrics.Points = []sensuPoint{}
+		v = event
+	default:
+		return text
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return text
+	}
+	return string(data) + "\n"
+}
+
+// influxTagEscaper escapes tag values according to the InfluxDB line protocol.
+var influxTagEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+// formatInflux renders the results using the InfluxDB line protocol, one line
+// per repository, all of them timestamped with now.
+func formatInflux(results []result, now time.Time) string {
+	var b strings.Builder
+	for _, res := range results {
+		b.WriteString("restic_check")
+		for _, tag := range [][2]string{{"repo", res.Repo.Path}, {"label", res.Repo.Label}, {"host", res.Repo.Host}} {
+			if tag[1] != "" {
+				fmt.Fprintf(&b, ",%s=%s", tag[0], influxTagEscaper.Replace(tag[1]))
+			}
+		}
+		b.WriteString(" ")
+		if res.hasLatest() {
+			fmt.Fprintf(&b, "age_seconds=%d,", int64(res.Age.Seconds()))
+		}
+		if res.Snapshots >= 0 {
+			fmt.Fprintf(&b, "snapshot_count=%d,", res.Snapshots)
+		}
+		if res.DataSizeSampled > 0 {
+			fmt.Fprintf(&b, "data_size=%d,data_size_sampled_pct=%d,", res.DataSize, res.DataSizeSampled)
+		}
+		fmt.Fprintf(&b, "status_code=%d %d\n", res.Status, now.UnixNano())
+	}
+	return b.String()
+}
+
+// formatCSV renders one row per repository, preceded by a header row.
+func formatCSV(results []result) string {
+	var b strings.Builder
+	w := csv.NewWriter(&b)
+	w.Write([]string{"repo", "host", "status", "age_seconds", "snapshot_count", "latest_snapshot_time"})
+	for _, res := range results {
+		var age, count, latest string
+		if res.Snapshots >= 0 {
+			count = strconv.Itoa(res.Snapshots)
+		}
+		if res.hasLatest() {
+			age = strconv.FormatInt(int64(res.Age.Seconds()), 10)
+			latest = res.Latest.UTC().Format(time.RFC3339)
+		}
+		w.Write([]string{res.Repo.Path, res.Repo.Host, getStatusStr(res.Status), age, count, latest})
+	}
+	w.Flush()
+	return b.String()
+}
+
+type sensuEvent struct {
+	Check   sensuCheck   `json:"check"`
+	Metrics sensuMetrics `json:"metrics"`
+}
+
+type sensuCheck struct {
+	Metadata struct {
+		Name string `json:"name"`
+	} `json:"metadata"`
+	Status   int    `json:"status"`
+	Output   string `json:"output"`
+	Executed int64  `json:"executed"`
+}
+
+type sensuMetrics struct {
+	Points []sensuPoint `json:"points"`
+}
+
+type sensuPoint struct {
+	Name      string     `json:"name"`
+	Value     float64    `json:"value"`
+	Timestamp int64      `json:"timestamp"`
+	Tags      []sensuTag `json:"tags,omitempty"`
+}
+
+type sensuTag struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// formatSensu renders the results as a Sensu Go event, which can be posted to
+// the events API of the agent as is. The check output is the text output,
+// the metrics are the same as the ones of the InfluxDB output.
+func formatSensu(results []result, now time.Time) string {
+	rc, _ := summarize(results)
+	var event sensuEvent
+	event.Check.Metadata.Name = "check_restic"
+	event.Check.Status = rc
+	event.Check.Output = formatText(results)
+	event.Check.Executed = now.Unix()
+	event.Metrics.Points = make([]sensuPoint, 0)
+
+	for _, res := range results {
+		tags := []sensuTag{{Name: "repo", Value: res.Repo.Path}}
+		if res.Repo.Host != "" {
+			tags = append(tags, sensuTag{Name: "host", Value: res.Repo.Host})
+		}
+		point := func(name string, value float64) {
+			event.Metrics.Points = append(event.Metrics.Points, sensuPoint{Name: "restic_check." + name, Value: value, Timestamp: now.Unix(), Tags: tags})
+		}
+		if res.hasLatest() {
+			point("age_seconds", float64(int64(res.Age.Seconds())))
+		}
+		if res.Snapshots >= 0 {
+			point("snapshot_count", float64(res.Snapshots))
+		}
+		if res.DataSizeSampled > 0 {
+			point("data_size", float64(res.DataSize))
+		}
+		point("status_code", float64(res.Status))
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Sprintf("{\"check\":{\"status\":%d,\"output\":%q}}\n", UNKNOWN, err.Error())
+	}
+	return string(data) + "\n"
+}
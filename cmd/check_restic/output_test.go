@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testResults returns an OK result with a latest snapshot and one which
+// could not be listed, for repositories whose names need escaping.
+func testResults() []result {
+	latest := time.Date(2026, 1, 14, 10, 0, 0, 0, time.UTC)
+	return []result{
+		{
+			Repo:      repository{Path: "/srv/restic/web 1,a=b", Label: "web=1", Host: "back up,1"},
+			Status:    OK,
+			Message:   "latest snapshot 1c2afc0e created 2h0m0s ago",
+			Snapshots: 3,
+			LatestID:  "1c2afc0e939761a8140ae562f6e4568e7f6001e2e8e9c1bd4aeb464e0d7ad0e8",
+			Latest:    latest,
+			Age:       2 * time.Hour,
+		},
+		{
+			Repo:      repository{Path: `/srv/restic/"db"`, Label: `"db"`},
+			Status:    UNKNOWN,
+			Message:   "connection refused",
+			Snapshots: -1,
+		},
+	}
+}
+
+func TestFormatInflux(t *testing.T) {
+	now := time.Date(2026, 1, 14, 12, 0, 0, 0, time.UTC)
+	want := `restic_check,repo=/srv/restic/web\ 1\,a\=b,label=web\=1,host=back\ up\,1 age_seconds=7200,snapshot_count=3,status_code=0 1768392000000000000
+restic_check,repo=/srv/restic/"db",label="db" status_code=3 1768392000000000000
+`
+	if got := formatInflux(testResults(), now); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestFormatCSV(t *testing.T) {
+	want := `repo,host,status,age_seconds,snapshot_count,latest_snapshot_time
+"/srv/restic/web 1,a=b","back up,1",OK,7200,3,2026-01-14T10:00:00Z
+"/srv/restic/""db""",,UNKNOWN,,,
+`
+	got := formatCSV(testResults())
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	records, err := csv.NewReader(strings.NewReader(got)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || records[1][0] != "/srv/restic/web 1,a=b" || records[2][0] != `/srv/restic/"db"` {
+		t.Errorf("read back %q", records)
+	}
+}
+
+func TestFormatSensu(t *testing.T) {
+	now := time.Date(2026, 1, 14, 12, 0, 0, 0, time.UTC)
+	want := `{
+  "check": {
+    "metadata": {"name": "check_restic"},
+    "status": 3,
+    "output": "UNKNOWN: checked 2 repositories | 'web=1 age'=7200s;;;0 'web=1 snapshots'=3;;;0\n[web=1] OK: latest snapshot 1c2afc0e created 2h0m0s ago\n[\"db\"] UNKNOWN: connection refused\n",
+    "executed": 1768392000
+  },
+  "metrics": {
+    "points": [
+      {"name": "restic_check.age_seconds", "value": 7200, "timestamp": 1768392000, "tags": [{"name": "repo", "value": "/srv/restic/web 1,a=b"}, {"name": "host", "value": "back up,1"}]},
+      {"name": "restic_check.snapshot_count", "value": 3, "timestamp": 1768392000, "tags": [{"name": "repo", "value": "/srv/restic/web 1,a=b"}, {"name": "host", "value": "back up,1"}]},
+      {"name": "restic_check.status_code", "value": 0, "timestamp": 1768392000, "tags": [{"name": "repo", "value": "/srv/restic/web 1,a=b"}, {"name": "host", "value": "back up,1"}]},
+      {"name": "restic_check.status_code", "value": 3, "timestamp": 1768392000, "tags": [{"name": "repo", "value": "/srv/restic/\"db\""}]}
+    ]
+  }
+}`
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, []byte(want)); err != nil {
+		t.Fatal(err)
+	}
+	if got := formatSensu(testResults(), now); got != compact.String()+"\n" {
+		t.Errorf("got\n%s\nwant\n%s", got, compact.String())
+	}
+}
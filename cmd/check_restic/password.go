@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+var (
+	passwordOnce sync.Once
+	passwordErr  error
+)
+
+// readPassword returns the repository password, just like restic itself
+// obtains it: from the output of the 'password-command' option, from the file
+// given via the 'password-file' option, or from the RESTIC_PASSWORD_COMMAND,
+// RESTIC_PASSWORD_FILE and RESTIC_PASSWORD environment variables. The
+// password is only obtained once, no matter how often it is asked for.
+func readPassword() (string, error) {
+	passwordOnce.Do(func() {
+		password, passwordErr = obtainPassword()
+	})
+	return password, passwordErr
+}
+
+func obtainPassword() (string, error) {
+	command := *passwordCommand
+	file := *passwordFile
+	if command == "" && file == "" {
+		command = os.Getenv("RESTIC_PASSWORD_COMMAND")
+		file = os.Getenv("RESTIC_PASSWORD_FILE")
+	}
+	if command != "" && file != "" {
+		return "", fmt.Errorf("The options 'password-command' and 'password-file' are mutually exclusive.")
+	}
+
+	if command != "" {
+		// the password never touches the disk or the command line this way
+		cmd := exec.CommandContext(runCtx, "sh", "-c", command)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			if msg := strings.TrimSpace(stderr.String()); msg != "" {
+				return "", fmt.Errorf("The password command failed: %s (%s)", err, msg)
+			}
+			return "", fmt.Errorf("The password command failed: %s", err)
+		}
+		password := strings.TrimRight(string(out), "\r\n")
+		if password == "" {
+			return "", fmt.Errorf("The password command returned an empty password.")
+		}
+		return password, nil
+	}
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("Unable to read the password file: %s", err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	return os.Getenv("RESTIC_PASSWORD"), nil
+}
+
+// repoPasswords maps the password files set by the config to their contents.
+var repoPasswords = make(map[string]string)
+
+// readRepoPasswords reads the password files set by the config for the
+// repositories.
+func readRepoPasswords() error {
+	for _, repo := range repos {
+		if repo.PasswordFile == "" {
+			continue
+		}
+		if _, ok := repoPasswords[repo.PasswordFile]; ok {
+			continue
+		}
+		data, err := os.ReadFile(repo.PasswordFile)
+		if err != nil {
+			return fmt.Errorf("Unable to read the password file of %s: %s", repo.Path, err)
+		}
+		repoPasswords[repo.PasswordFile] = strings.TrimSpace(string(data))
+	}
+	return nil
+}
+
+// repoPassword returns the password of the repository, which is either the
+// one of its password file or the repository password.
+func repoPassword(repo repository) string {
+	if repo.PasswordFile != "" {
+		return repoPasswords[repo.PasswordFile]
+	}
+	return password
+}
+
+// allReposHavePasswords reports whether the config sets a password file for
+// every repository, so that the repository password is not needed.
+func allReposHavePasswords() bool {
+	for _, repo := range repos {
+		if repo.PasswordFile == "" {
+			return false
+		}
+	}
+	return len(repos) > 0
+}
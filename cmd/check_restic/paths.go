@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"check_restic/pkg/checkrestic"
+)
+
+// loadPathList reads the paths listed in a file like the
+// 'required-paths-file', one per line. Empty lines and lines starting with '#'
+// are ignored.
+func loadPathList(name string) ([]string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var paths []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		paths = append(paths, path.Clean(line))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return paths, nil
+}
+
+// covers reports whether a snapshot of backedUp includes p, i.e. whether it
+// is p itself or one of its parents.
+func covers(backedUp, p string) bool {
+	backedUp = path.Clean(backedUp)
+	return backedUp == p || backedUp == "/" || strings.HasPrefix(p, backedUp+"/")
+}
+
+// checkRequiredPaths evaluates the age of the latest snapshot including each
+// of the required paths against the thresholds of the repository, so that a
+// repository does not look fresh while one of the paths is not backed up any
+// more. Paths without any snapshot are CRITICAL. It returns the worst status
+// and a description of each violating path.
+func (c *repoCheck) checkRequiredPaths() (int, []string, error) {
+	snapshots, err := c.decoded()
+	if err != nil {
+		return OK, nil, err
+	}
+	groups := checkrestic.GroupSnapshots(snapshots, checkrestic.ByPath)
+
+	status := OK
+	var violations []string
+	for _, p := range requiredPaths {
+		var newest *checkrestic.Snapshot
+		for _, g := range groups {
+			if covers(g.Key, p) && (newest == nil || g.Newest.Time.After(newest.Time)) {
+				newest = g.Newest
+			}
+		}
+		if newest == nil {
+			status = CRITICAL
+			violations = append(violations, fmt.Sprintf("path %s: no snapshots found", p))
+			continue
+		}
+		age := time.Since(newest.Time)
+		pathStatus, threshold := OK, time.Duration(0)
+		// the thresholds are optional with a 'schedule'
+		if c.repo.Critical >= 0 && age > c.repo.Critical {
+			pathStatus, threshold = CRITICAL, c.repo.Critical
+		} else if c.repo.Warning >= 0 && age > c.repo.Warning {
+			pathStatus, threshold = WARNING, c.repo.Warning
+		}
+		if pathStatus != OK {
+			status = worseStatus(status, pathStatus)
+			violations = append(violations, fmt.Sprintf("path %s: latest snapshot created %s ago (threshold %s)", p, age.Round(agePrecision), threshold))
+		}
+	}
+	return status, violations, nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import "strings"
+
+// ping reports the overall status to the dead man's switch at 'ping-url':
+// the URL itself is pinged on OK, and '<url>/fail' on CRITICAL. Other
+// statuses are not reported, leaving it to the watchdog to notice missing
+// pings. The output of the check is sent along as request body. Failures are
+// only logged since they must not affect the result of the check.
+func ping(status int, out string) {
+	url := strings.TrimSuffix(*pingURL, "/")
+	switch status {
+	case OK:
+	case CRITICAL:
+		url += "/fail"
+	default:
+		return
+	}
+
+	client, _ := newHTTPClient(*pingTimeout)
+	resp, err := client.Post(url, "text/plain; charset=utf-8", strings.NewReader(out))
+	if err != nil {
+		verbosef("ping to %s failed: %s", url, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		verbosef("ping to %s failed: %s", url, resp.Status)
+		return
+	}
+	verbosef("pinged %s", url)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// askpassEnv marks an invocation by ssh as its SSH_ASKPASS program, see
+// sshEnv.
+const askpassEnv = "CHECK_RESTIC_ASKPASS"
+
+// pkcs11PinEnv holds the PIN of the PKCS#11 token if no 'pkcs11-pin-file' is
+// given. It is also used to pass the PIN on to the askpass invocation.
+const pkcs11PinEnv = "CHECK_RESTIC_PKCS11_PIN"
+
+// pkcs11Args returns the ssh options loading the keys of the 'pkcs11-lib'
+// provider. The keys never leave the token, ssh asks it to sign instead.
+func pkcs11Args() []string {
+	if *pkcs11Lib == "" {
+		return nil
+	}
+	return []string{"-o", "PKCS11Provider=" + *pkcs11Lib}
+}
+
+// pkcs11Pin returns the PIN of the PKCS#11 token, if any is needed.
+func pkcs11Pin() (string, error) {
+	if *pkcs11Lib == "" {
+		return "", nil
+	}
+	pin := os.Getenv(pkcs11PinEnv)
+	if *pkcs11PinFile != "" {
+		data, err := os.ReadFile(*pkcs11PinFile)
+		if err != nil {
+			return "", fmt.Errorf("Unable to read the PKCS#11 PIN file: %s", err)
+		}
+		pin = strings.TrimSpace(string(data))
+	}
+	return pin, nil
+}
@@ -0,0 +1,259 @@
+//go:build pkcs11 && cgo
+// +build pkcs11,cgo
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sync"
+
+	"github.com/miekg/pkcs11"
+	"golang.org/x/crypto/ssh"
+)
+
+// pkcs11Supported reports whether the native client can use keys kept on a
+// PKCS#11 token, which needs cgo to load the provider.
+const pkcs11Supported = true
+
+// token is the key of the 'pkcs11-lib' provider, which is loaded once for all
+// connections since a provider may only be initialized once per process.
+var token struct {
+	once   sync.Once
+	signer ssh.Signer
+	err    error
+}
+
+// pkcs11Signer returns the signer of the key on the token of the 'pkcs11-lib'
+// provider, in the 'pkcs11-slot' and labeled 'pkcs11-label' if given. The
+// key never leaves the token, which is asked to sign instead.
+func pkcs11Signer() (ssh.Signer, error) {
+	token.once.Do(func() {
+		token.signer, token.err = loadPKCS11Signer()
+	})
+	return token.signer, token.err
+}
+
+func loadPKCS11Signer() (ssh.Signer, error) {
+	p := pkcs11.New(*pkcs11Lib)
+	if p == nil {
+		return nil, fmt.Errorf("unable to load the PKCS#11 provider %s", *pkcs11Lib)
+	}
+	if err := p.Initialize(); err != nil && !errors.Is(err, pkcs11.Error(pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED)) {
+		return nil, fmt.Errorf("unable to initialize the PKCS#11 provider %s: %s", *pkcs11Lib, err)
+	}
+	slots, err := p.GetSlotList(true)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the PKCS#11 slots: %s", err)
+	}
+	if len(slots) == 0 {
+		return nil, fmt.Errorf("no PKCS#11 token found by %s", *pkcs11Lib)
+	}
+	slot := slots[0]
+	if *pkcs11Slot >= 0 {
+		slot = uint(*pkcs11Slot)
+		found := false
+		for _, s := range slots {
+			found = found || s == slot
+		}
+		if !found {
+			return nil, fmt.Errorf("no PKCS#11 token found in slot %d, the slots holding one are %v", slot, slots)
+		}
+	}
+
+	session, err := p.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open a session with the PKCS#11 token in slot %d: %s", slot, err)
+	}
+	pin, err := pkcs11Pin()
+	if err != nil {
+		return nil, err
+	}
+	if pin != "" {
+		err := p.Login(session, pkcs11.CKU_USER, pin)
+		switch {
+		case errors.Is(err, pkcs11.Error(pkcs11.CKR_PIN_INCORRECT)), errors.Is(err, pkcs11.Error(pkcs11.CKR_PIN_INVALID)):
+			return nil, fmt.Errorf("the PKCS#11 token in slot %d rejected the PIN", slot)
+		case errors.Is(err, pkcs11.Error(pkcs11.CKR_PIN_LOCKED)):
+			return nil, fmt.Errorf("the PIN of the PKCS#11 token in slot %d is locked", slot)
+		case err != nil && !errors.Is(err, pkcs11.Error(pkcs11.CKR_USER_ALREADY_LOGGED_IN)):
+			return nil, fmt.Errorf("unable to log in to the PKCS#11 token in slot %d: %s", slot, err)
+		}
+	}
+
+	key, err := findPKCS11Object(p, session, pkcs11.CKO_PRIVATE_KEY, nil, pkcs11.NewAttribute(pkcs11.CKA_SIGN, true))
+	if err != nil {
+		return nil, err
+	}
+	attrs, err := p.GetAttributeValue(session, key, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, nil),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the PKCS#11 key: %s", err)
+	}
+	// the public key belongs to the private one by having the same ID
+	pub, err := findPKCS11Object(p, session, pkcs11.CKO_PUBLIC_KEY, attrs[1].Value)
+	if err != nil {
+		return nil, err
+	}
+	s := &pkcs11Key{ctx: p, session: session, key: key}
+	// the type is a CK_ULONG in the byte order of the provider
+	isType := func(keyType uint) bool {
+		return bytes.Equal(attrs[0].Value, pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, keyType).Value)
+	}
+	switch {
+	case isType(pkcs11.CKK_RSA):
+		s.public, err = pkcs11RSAKey(p, session, pub)
+	case isType(pkcs11.CKK_EC):
+		s.public, err = pkcs11ECKey(p, session, pub)
+	default:
+		// ssh supports no other keys on tokens either
+		return nil, errors.New("the PKCS#11 key is neither an RSA nor an ECDSA key")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ssh.NewSignerFromSigner(s)
+}
+
+// findPKCS11Object returns the object of the class with the attributes and
+// the ID, or else the only one labeled 'pkcs11-label', if that is given.
+func findPKCS11Object(p *pkcs11.Ctx, session pkcs11.SessionHandle, class uint, id []byte, attrs ...*pkcs11.Attribute) (pkcs11.ObjectHandle, error) {
+	what := map[uint]string{pkcs11.CKO_PRIVATE_KEY: "private key", pkcs11.CKO_PUBLIC_KEY: "public key"}[class]
+	template := append([]*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_CLASS, class)}, attrs...)
+	if id != nil {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_ID, id))
+	} else if *pkcs11Label != "" {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_LABEL, *pkcs11Label))
+	}
+	if err := p.FindObjectsInit(session, template); err != nil {
+		return 0, fmt.Errorf("unable to search the PKCS#11 token: %s", err)
+	}
+	objects, _, err := p.FindObjects(session, 2)
+	p.FindObjectsFinal(session)
+	if err != nil {
+		return 0, fmt.Errorf("unable to search the PKCS#11 token: %s", err)
+	}
+	switch {
+	case len(objects) == 0 && id == nil && *pkcs11Label != "":
+		return 0, fmt.Errorf("no %s labeled '%s' found on the PKCS#11 token", what, *pkcs11Label)
+	case len(objects) == 0:
+		return 0, fmt.Errorf("no %s found on the PKCS#11 token", what)
+	case len(objects) > 1 && id == nil:
+		return 0, fmt.Errorf("several %ss found on the PKCS#11 token, use 'pkcs11-label' to select one", what)
+	}
+	return objects[0], nil
+}
+
+func pkcs11RSAKey(p *pkcs11.Ctx, session pkcs11.SessionHandle, pub pkcs11.ObjectHandle) (crypto.PublicKey, error) {
+	attrs, err := p.GetAttributeValue(session, pub, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_MODULUS, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, nil),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the PKCS#11 public key: %s", err)
+	}
+	e := new(big.Int).SetBytes(attrs[1].Value)
+	if !e.IsInt64() || e.Int64() > 1<<31-1 {
+		return nil, errors.New("the PKCS#11 public key has an invalid exponent")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(attrs[0].Value), E: int(e.Int64())}, nil
+}
+
+// pkcs11Curves are the curves supported by ssh by the OID the token reports.
+var pkcs11Curves = map[string]elliptic.Curve{
+	"1.2.840.10045.3.1.7": elliptic.P256(),
+	"1.3.132.0.34":        elliptic.P384(),
+	"1.3.132.0.35":        elliptic.P521(),
+}
+
+func pkcs11ECKey(p *pkcs11.Ctx, session pkcs11.SessionHandle, pub pkcs11.ObjectHandle) (crypto.PublicKey, error) {
+	attrs, err := p.GetAttributeValue(session, pub, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the PKCS#11 public key: %s", err)
+	}
+	var oid asn1.ObjectIdentifier
+	if _, err := asn1.Unmarshal(attrs[0].Value, &oid); err != nil {
+		return nil, fmt.Errorf("the PKCS#11 public key has invalid parameters: %s", err)
+	}
+	curve, ok := pkcs11Curves[oid.String()]
+	if !ok {
+		return nil, fmt.Errorf("the PKCS#11 public key is on the unsupported curve %s", oid)
+	}
+	// the point is wrapped in an OCTET STRING
+	var point []byte
+	if _, err := asn1.Unmarshal(attrs[1].Value, &point); err != nil {
+		return nil, fmt.Errorf("the PKCS#11 public key has an invalid point: %s", err)
+	}
+	x, y := elliptic.Unmarshal(curve, point)
+	if x == nil {
+		return nil, errors.New("the PKCS#11 public key has an invalid point")
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+// pkcs11Key is a crypto.Signer signing with the private key on the token.
+// A session must not be used concurrently, and the repositories may be
+// checked at once.
+type pkcs11Key struct {
+	mu      sync.Mutex
+	ctx     *pkcs11.Ctx
+	session pkcs11.SessionHandle
+	key     pkcs11.ObjectHandle
+	public  crypto.PublicKey
+}
+
+func (k *pkcs11Key) Public() crypto.PublicKey { return k.public }
+
+// digestInfoPrefixes are the DER encoded DigestInfo headers PKCS #1 v1.5
+// signatures need before the digests used by ssh, which CKM_RSA_PKCS expects
+// to be given.
+var digestInfoPrefixes = map[crypto.Hash][]byte{
+	crypto.SHA1:   {0x30, 0x21, 0x30, 0x09, 0x06, 0x05, 0x2b, 0x0e, 0x03, 0x02, 0x1a, 0x05, 0x00, 0x04, 0x14},
+	crypto.SHA256: {0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+	crypto.SHA384: {0x30, 0x41, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x02, 0x05, 0x00, 0x04, 0x30},
+	crypto.SHA512: {0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40},
+}
+
+func (k *pkcs11Key) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	var mechanism uint
+	data := digest
+	switch k.public.(type) {
+	case *rsa.PublicKey:
+		prefix, ok := digestInfoPrefixes[opts.HashFunc()]
+		if !ok {
+			return nil, fmt.Errorf("unsupported hash %s for the PKCS#11 key", opts.HashFunc())
+		}
+		mechanism, data = pkcs11.CKM_RSA_PKCS, append(append([]byte(nil), prefix...), digest...)
+	case *ecdsa.PublicKey:
+		mechanism = pkcs11.CKM_ECDSA
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.ctx.SignInit(k.session, []*pkcs11.Mechanism{pkcs11.NewMechanism(mechanism, nil)}, k.key); err != nil {
+		return nil, fmt.Errorf("the PKCS#11 token refused to sign: %s", err)
+	}
+	sig, err := k.ctx.Sign(k.session, data)
+	if err != nil {
+		return nil, fmt.Errorf("the PKCS#11 token refused to sign: %s", err)
+	}
+	if mechanism != pkcs11.CKM_ECDSA {
+		return sig, nil
+	}
+	// the token returns r and s concatenated, crypto.Signer the ASN.1 form
+	r, s := new(big.Int).SetBytes(sig[:len(sig)/2]), new(big.Int).SetBytes(sig[len(sig)/2:])
+	return asn1.Marshal(struct{ R, S *big.Int }{r, s})
+}
@@ -0,0 +1,18 @@
+//go:build !pkcs11 || !cgo
+// +build !pkcs11 !cgo
+
+package main
+
+import (
+	"errors"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// pkcs11Supported reports whether the native client can use keys kept on a
+// PKCS#11 token, which needs cgo to load the provider.
+const pkcs11Supported = false
+
+func pkcs11Signer() (ssh.Signer, error) {
+	return nil, errors.New("built without PKCS#11 support")
+}
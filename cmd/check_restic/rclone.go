@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// rcloneServer starts 'rclone serve restic --stdio' for the rclone backend,
+// which speaks the REST protocol via HTTP/2 over its stdin and stdout, just
+// like restic uses it. A process is started whenever the transport needs a
+// connection, i.e. for the first request and after the previous process
+// died.
+type rcloneServer struct {
+	mu    sync.Mutex
+	conns []*rcloneConn
+}
+
+// rclone is the server of the rclone backend, nil for all other backends.
+var rclone *rcloneServer
+
+// transport returns the HTTP/2 transport using the server, which never
+// connects anywhere else.
+func (s *rcloneServer) transport() http.RoundTripper {
+	return rcloneTransport{s, &http2.Transport{
+		// this is not really HTTP, just stdin and stdout
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+			return s.start()
+		},
+	}}
+}
+
+func (s *rcloneServer) start() (net.Conn, error) {
+	args := append(strings.Fields(*rcloneArgs), *rcloneRemote)
+	cmd := exec.Command(*rcloneProgram, args...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	c := &rcloneConn{cmd: cmd, done: make(chan struct{})}
+	cmd.Stderr = &c.stderr
+	c.r, c.w = countingReader{stdout, &bytesReceived}, countingWriter{stdin, &bytesSent}
+	verbosef("running %s %s", *rcloneProgram, strings.Join(args, " "))
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("unable to start rclone: %s", err)
+	}
+	go func() {
+		cmd.Wait()
+		close(c.done)
+	}()
+
+	s.mu.Lock()
+	s.conns = append(s.conns, c)
+	s.mu.Unlock()
+	return c, nil
+}
+
+// stderr returns the last line rclone wrote to stderr, if any, which
+// explains why it failed far better than the broken connection.
+func (s *rcloneServer) stderr() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := len(s.conns) - 1; i >= 0; i-- {
+		if msg := s.conns[i].lastLine(); msg != "" {
+			return msg
+		}
+	}
+	return ""
+}
+
+// close stops all processes, it is safe to call on a nil server.
+func (s *rcloneServer) close() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.conns {
+		c.Close()
+	}
+	s.conns = nil
+}
+
+// rcloneTransport adds the complaint of rclone to failed requests.
+type rcloneTransport struct {
+	server *rcloneServer
+	*http2.Transport
+}
+
+func (t rcloneTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.Transport.RoundTrip(req)
+	if err != nil {
+		if msg := t.server.stderr(); msg != "" {
+			return nil, fmt.Errorf("%s (rclone: %s)", err, msg)
+		}
+	}
+	return resp, err
+}
+
+// rcloneConn is the connection to a single rclone process.
+type rcloneConn struct {
+	cmd  *exec.Cmd
+	r    io.Reader
+	w    io.WriteCloser
+	done chan struct{}
+
+	mu     sync.Mutex
+	stderr lockedBuffer
+	closed bool
+}
+
+func (c *rcloneConn) Read(p []byte) (int, error)  { return c.r.Read(p) }
+func (c *rcloneConn) Write(p []byte) (int, error) { return c.w.Write(p) }
+
+// Close closes stdin, which makes rclone exit, and kills it if it does not
+// do so in time.
+func (c *rcloneConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	c.w.Close()
+	select {
+	case <-c.done:
+	case <-time.After(5 * time.Second):
+		c.cmd.Process.Kill()
+		<-c.done
+	}
+	return nil
+}
+
+func (c *rcloneConn) lastLine() string {
+	lines := strings.Split(strings.TrimSpace(c.stderr.String()), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+// the pipes have no addresses and deadlines, requests are limited by the
+// timeout of the HTTP client instead
+func (c *rcloneConn) LocalAddr() net.Addr                { return rcloneAddr{} }
+func (c *rcloneConn) RemoteAddr() net.Addr               { return rcloneAddr{} }
+func (c *rcloneConn) SetDeadline(t time.Time) error      { return nil }
+func (c *rcloneConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *rcloneConn) SetWriteDeadline(t time.Time) error { return nil }
+
+type rcloneAddr struct{}
+
+func (rcloneAddr) Network() string { return "stdio" }
+func (rcloneAddr) String() string  { return "rclone" }
+
+// lockedBuffer collects the stderr of a process while it may be read.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
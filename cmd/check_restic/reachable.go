@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// reachableTimeout limits the TCP probe of the 'only-if-reachable' option.
+const reachableTimeout = 5 * time.Second
+
+var (
+	sourceProbe     sync.Once
+	sourceReachable bool
+)
+
+// sourceIsReachable probes the backed-up machine given by the
+// 'only-if-reachable' option. It is probed at most once per run.
+func sourceIsReachable() bool {
+	sourceProbe.Do(func() {
+		conn, err := net.DialTimeout("tcp", *onlyIfReachable, reachableTimeout)
+		if err == nil {
+			conn.Close()
+		} else {
+			verbosef("source host %s is unreachable: %s", *onlyIfReachable, err)
+		}
+		sourceReachable = err == nil
+	})
+	return sourceReachable
+}
+
+// gateUnreachableSource replaces a stale alert by 'unreachable-status' if the
+// backed-up machine is offline, since it could not have backed up anyway.
+// Failures to check the repository itself are still reported.
+func gateUnreachableSource(res *result) {
+	if res.Status != WARNING && res.Status != CRITICAL {
+		return
+	}
+	if sourceIsReachable() {
+		return
+	}
+	res.Status = unreachableStatus
+	res.Message = fmt.Sprintf("source host offline, suppressing stale alert (%s)", res.Message)
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// redactPatterns implements flag.Value for the repeatable 'redact' option.
+type redactPatterns []*regexp.Regexp
+
+func (p *redactPatterns) String() string {
+	patterns := make([]string, 0, len(*p))
+	for _, re := range *p {
+		patterns = append(patterns, re.String())
+	}
+	return strings.Join(patterns, " ")
+}
+
+func (p *redactPatterns) Set(s string) error {
+	re, err := regexp.Compile(s)
+	if err != nil {
+		return err
+	}
+	*p = append(*p, re)
+	return nil
+}
+
+// redactFlag holds the patterns given by the 'redact' option.
+var redactFlag redactPatterns
+
+// redactor replaces sensitive substrings by placeholders derived from their
+// hash, so that the same substring always gets the same placeholder and
+// outputs can still be correlated.
+type redactor struct {
+	re *regexp.Regexp
+}
+
+// newRedactor returns a redactor for the 'redact' patterns and, depending on
+// 'redact-hosts' and 'redact-paths', the hosts, users and paths of all
+// repositories as well as the given hostnames of snapshots. Literals are
+// replaced longest first, so that a path is not left half-redacted because
+// its label, a part of it, was replaced before.
+func newRedactor(hostnames []string) *redactor {
+	var literals []string
+	for _, repo := range repos {
+		if *redactHosts {
+			literals = append(literals, repo.Host, repo.User)
+		}
+		if *redactPaths {
+			literals = append(literals, repo.Path, repo.Label)
+		}
+	}
+	if *redactHosts {
+		literals = append(literals, hostnames...)
+	}
+	sort.SliceStable(literals, func(a, b int) bool {
+		return len(literals[a]) > len(literals[b])
+	})
+
+	// a single expression replaces everything in one pass, so placeholders
+	// are never redacted again
+	var alternatives []string
+	seen := make(map[string]bool)
+	for _, l := range literals {
+		if l != "" && !seen[l] {
+			seen[l] = true
+			alternatives = append(alternatives, literalPattern(l))
+		}
+	}
+	for _, re := range redactFlag {
+		alternatives = append(alternatives, "(?:"+re.String()+")")
+	}
+	if len(alternatives) == 0 {
+		return &redactor{}
+	}
+	return &redactor{re: regexp.MustCompile(strings.Join(alternatives, "|"))}
+}
+
+// literalPattern matches l unless it is only part of a longer word, e.g. the
+// host "db" within "dbackup".
+func literalPattern(l string) string {
+	isWord := func(b byte) bool {
+		return b == '_' || b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z'
+	}
+	p := regexp.QuoteMeta(l)
+	if isWord(l[0]) {
+		p = `\b` + p
+	}
+	if isWord(l[len(l)-1]) {
+		p += `\b`
+	}
+	return p
+}
+
+// active reports whether anything is to be redacted at all.
+func (r *redactor) active() bool {
+	return r.re != nil
+}
+
+// text redacts all matches in s.
+func (r *redactor) text(s string) string {
+	if r.re == nil {
+		return s
+	}
+	return r.re.ReplaceAllStringFunc(s, func(match string) string {
+		sum := sha256.Sum256([]byte(match))
+		return "redacted-" + hex.EncodeToString(sum[:4])
+	})
+}
+
+// texts redacts all matches in a copy of list, which may be shared with the
+// snapshots.
+func (r *redactor) texts(list []string) []string {
+	if list == nil {
+		return nil
+	}
+	out := make([]string, len(list))
+	for i, s := range list {
+		out[i] = r.text(s)
+	}
+	return out
+}
+
+// redacted redacts the output of the listing modes, which is plain text
+// without any metrics.
+func redacted(rc int, out string) (int, string) {
+	return rc, newRedactor(nil).text(out)
+}
+
+// perHostNames returns the hostnames of 'group-by=host' and of the latest
+// snapshots in the results.
+func perHostNames(results []result) []string {
+	var hosts []string
+	for _, res := range results {
+		if res.LatestHost != "" {
+			hosts = append(hosts, res.LatestHost)
+		}
+		for _, h := range res.PerHost {
+			hosts = append(hosts, h.Host)
+		}
+	}
+	return hosts
+}
+
+// results redacts the texts of the results in place: paths, labels, hosts
+// and messages. It runs before the results are rendered, so that numeric
+// values such as ages, counts and sizes are never altered, whatever the
+// patterns match.
+func (r *redactor) results(results []result) {
+	for i := range results {
+		res := &results[i]
+		res.Repo.Path = r.text(res.Repo.Path)
+		res.Repo.Label = r.text(res.Repo.Label)
+		res.Repo.Host = r.text(res.Repo.Host)
+		res.Repo.User = r.text(res.Repo.User)
+		res.Message = r.text(res.Message)
+		if len(res.Checks) > 0 {
+			checks := make([]subResult, len(res.Checks))
+			for j, c := range res.Checks {
+				c.Message = r.text(c.Message)
+				if c.Err != nil {
+					c.Err = errors.New(r.text(c.Err.Error()))
+				}
+				checks[j] = c
+			}
+			res.Checks = checks
+		}
+		res.LatestHost = r.text(res.LatestHost)
+		res.LatestTags = r.texts(res.LatestTags)
+		res.LatestPaths = r.texts(res.LatestPaths)
+		if len(res.PerHost) > 0 {
+			hosts := make([]hostSummary, len(res.PerHost))
+			for j, h := range res.PerHost {
+				h.Host = r.text(h.Host)
+				hosts[j] = h
+			}
+			res.PerHost = hosts
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"check_restic/pkg/checkrestic"
+)
+
+// errNoResticRepo is returned by resticSnapshots if restic reports that there
+// is no repository at the location.
+var errNoResticRepo = errors.New("restic found no repository")
+
+// resticNoRepoExitCode is the exit code of restic 0.17 and later if the
+// repository does not exist; older versions only say so on stderr.
+const resticNoRepoExitCode = 10
+
+// resticSnapshots lists the snapshots of the repository via 'restic snapshots
+// --json' for 'use-restic-binary'. Without a path, restic itself takes the
+// repository from RESTIC_REPOSITORY, just like the password from its
+// environment unless 'password-file' or 'password-command' is given. The
+// snapshots are decoded, but their modification times are unknown.
+func resticSnapshots(repo repository) ([]*checkrestic.Snapshot, error) {
+	args := []string{"snapshots", "--json", "--no-lock"}
+	if repo.Path != "" {
+		args = append(args, "--repo", repo.Path)
+	}
+	if repo.PasswordFile != "" {
+		args = append(args, "--password-file", repo.PasswordFile)
+	} else if *passwordFile != "" {
+		args = append(args, "--password-file", *passwordFile)
+	} else if *passwordCommand != "" {
+		args = append(args, "--password-command", *passwordCommand)
+	}
+	cmd := exec.CommandContext(runCtx, "restic", args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	verbosef("running restic %s", strings.Join(args, " "))
+	if err := cmd.Run(); err != nil {
+		msg := strings.Join(strings.Split(strings.TrimSpace(stderr.String()), "\n"), "; ")
+		if msg == "" {
+			msg = err.Error()
+		}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == resticNoRepoExitCode || strings.Contains(msg, "Is there a repository at the following location?") {
+			return nil, fmt.Errorf("%w (%s)", errNoResticRepo, msg)
+		}
+		return nil, fmt.Errorf("restic failed: %s", msg)
+	}
+
+	var raw []json.RawMessage
+	if err := json.Unmarshal(stdout.Bytes(), &raw); err != nil {
+		return nil, fmt.Errorf("unable to parse the output of restic: %s", err)
+	}
+	snapshots := make([]*checkrestic.Snapshot, 0, len(raw))
+	for _, r := range raw {
+		var sn checkrestic.Snapshot
+		var id struct {
+			ID string `json:"id"`
+		}
+		if err := json.Unmarshal(r, &sn); err != nil {
+			return nil, fmt.Errorf("unable to parse the output of restic: %s", err)
+		}
+		if err := json.Unmarshal(r, &id); err != nil {
+			return nil, fmt.Errorf("unable to parse the output of restic: %s", err)
+		}
+		sn.ID = id.ID
+		snapshots = append(snapshots, &sn)
+	}
+	return snapshots, nil
+}
+
+// validateResticBinary checks the options for 'use-restic-binary', which only
+// supports checks based on the decoded snapshots, since restic does not
+// expose the files of the repository.
+func validateResticBinary() error {
+	if interactive() {
+		return fmt.Errorf("The option 'use-restic-binary' only supports regular checks.")
+	}
+	if *newestByName != "" && *newestByName != "snapshot-time" {
+		return fmt.Errorf("The option 'use-restic-binary' needs 'newest-by=snapshot-time', since restic does not report modification times.")
+	}
+	for name, set := range map[string]bool{
+		"warn-on-time-drift":   *timeDrift > 0,
+		"expect-repo-version":  *expectRepoVersion > 0,
+		"warn-empty-snapshot":  *warnEmptySnapshot,
+		"check-writable":       *checkWritable,
+		"check-free-space":     checksFreeSpace(),
+		"check-locks":          *checkLocks,
+		"check-structure":      *checkStructure,
+		"index-lag-warning":    *indexLagWarning > 0,
+		"integrity-check-path": *integrityPath != "",
+		"detect-reinit":        *detectReinit,
+		"data-subset-stat":     *dataSubsetStat,
+		"size-warning":         sizeWarning > 0,
+		"size-critical":        sizeCritical > 0,
+		"growth-warning":       growthWarning > 0,
+		"growth-critical":      growthCritical > 0,
+	} {
+		if set {
+			return fmt.Errorf("The option '%s' is not supported with 'use-restic-binary'.", name)
+		}
+	}
+	if _, err := exec.LookPath("restic"); err != nil {
+		return fmt.Errorf("The option 'use-restic-binary' needs restic to be installed: %s", err)
+	}
+	return nil
+}
+
+// resticRepoFromEnv reports whether restic takes the repository from its
+// environment.
+func resticRepoFromEnv() bool {
+	return os.Getenv("RESTIC_REPOSITORY") != "" || os.Getenv("RESTIC_REPOSITORY_FILE") != ""
+}
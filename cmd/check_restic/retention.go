@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"check_restic/pkg/checkrestic"
+)
+
+// retentionRule is one of the 'keep-*' options, which keeps the newest
+// snapshot of each of the last Keep periods containing snapshots, like the
+// option of 'restic forget' it is named after.
+type retentionRule struct {
+	Name string
+	Keep int
+	// Bucket returns the period of a time, Back the time the given number of
+	// periods before it.
+	Bucket func(t time.Time) int
+	Back   func(t time.Time, n int) time.Time
+}
+
+// retentionRules returns the rules of the 'keep-*' options which are set.
+func retentionRules() []retentionRule {
+	rules := []retentionRule{
+		{"hourly", *keepHourly, func(t time.Time) int { return t.Year()*1000000 + int(t.Month())*10000 + t.Day()*100 + t.Hour() },
+			func(t time.Time, n int) time.Time { return t.Add(-time.Duration(n) * time.Hour) }},
+		{"daily", *keepDaily, func(t time.Time) int { return t.Year()*10000 + int(t.Month())*100 + t.Day() },
+			func(t time.Time, n int) time.Time { return t.AddDate(0, 0, -n) }},
+		{"weekly", *keepWeekly, func(t time.Time) int { y, w := t.ISOWeek(); return y*100 + w },
+			func(t time.Time, n int) time.Time { return t.AddDate(0, 0, -7*n) }},
+		{"monthly", *keepMonthly, func(t time.Time) int { return t.Year()*100 + int(t.Month()) },
+			func(t time.Time, n int) time.Time { return t.AddDate(0, -n, 0) }},
+		{"yearly", *keepYearly, func(t time.Time) int { return t.Year() },
+			func(t time.Time, n int) time.Time { return t.AddDate(-n, 0, 0) }},
+	}
+	var set []retentionRule
+	for _, r := range rules {
+		if r.Keep > 0 {
+			set = append(set, r)
+		}
+	}
+	return set
+}
+
+// checksRetention reports whether the snapshots are compared with a retention
+// policy.
+func checksRetention() bool {
+	return *keepLast > 0 || *keepHourly > 0 || *keepDaily > 0 || *keepWeekly > 0 || *keepMonthly > 0 || *keepYearly > 0
+}
+
+// checkRetention applies the policy of the 'keep-*' options to the snapshots
+// the way 'restic forget' does, i.e. separately for every host and set of
+// paths. It returns WARNING if snapshots remain which the policy would have
+// removed, since forget is then not running or uses another policy, and if
+// a rule keeps fewer snapshots than it should although the snapshots reach
+// back far enough, since backups are then missing or were removed by a
+// stricter policy. The snapshots need to be decoded.
+func checkRetention(snapshots []*checkrestic.Snapshot) subResult {
+	sub := subResult{Name: "retention-policy"}
+	groups := make(map[string][]*checkrestic.Snapshot)
+	for _, sn := range snapshots {
+		paths := append([]string(nil), sn.Paths...)
+		sort.Strings(paths)
+		key := sn.Hostname + " " + strings.Join(paths, ",")
+		groups[key] = append(groups[key], sn)
+	}
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	rules := retentionRules()
+	var problems []string
+	for _, key := range keys {
+		group := groups[key]
+		// newest first, as restic applies the policy
+		sort.SliceStable(group, func(a, b int) bool { return group[b].Time.Before(group[a].Time) })
+		latest, oldest := group[0].Time.Local(), group[len(group)-1].Time.Local()
+
+		kept := make([]int, len(rules))
+		last := make([]int, len(rules))
+		for i := range last {
+			last[i] = -1
+		}
+		excess := 0
+		for i, sn := range group {
+			keep := i < *keepLast
+			t := sn.Time.Local()
+			for j, r := range rules {
+				if b := r.Bucket(t); kept[j] < r.Keep && b != last[j] {
+					kept[j]++
+					last[j] = b
+					keep = true
+				}
+			}
+			if !keep {
+				excess++
+			}
+		}
+
+		var msgs []string
+		if excess > 0 {
+			msgs = append(msgs, fmt.Sprintf("%d snapshots not kept by the policy, 'restic forget' may not be running", excess))
+		}
+		for j, r := range rules {
+			if kept[j] < r.Keep && oldest.Before(r.Back(latest, r.Keep)) {
+				msgs = append(msgs, fmt.Sprintf("only %d of %d %s snapshots", kept[j], r.Keep, r.Name))
+			}
+		}
+		if len(msgs) == 0 {
+			continue
+		}
+		if len(keys) > 1 {
+			problems = append(problems, fmt.Sprintf("%s: %s", strings.TrimSpace(key), strings.Join(msgs, ", ")))
+		} else {
+			problems = append(problems, strings.Join(msgs, ", "))
+		}
+	}
+	if len(problems) > 0 {
+		sub.Status = WARNING
+		sub.Message = "retention policy not met: " + strings.Join(problems, "; ")
+	}
+	return sub
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"time"
+)
+
+// permanent reports whether err will not go away by retrying, e.g. a missing
+// repository or rejected credentials.
+func permanent(err error) bool {
+	return errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) ||
+		errors.Is(err, errHostKeyChanged) || errors.Is(err, errNoResticRepo)
+}
+
+// retry runs f until it succeeds or fails permanently, at most 'retries'
+// times more than once. It waits 'retry-delay' before the first retry and
+// twice as long before each further one, unless the 'timeout' expires
+// meanwhile. It returns the number of retries along with the last error.
+func retry(what string, f func() error) (int, error) {
+	delay := *retryDelay
+	for n := 0; ; n++ {
+		err := f()
+		if err == nil || permanent(err) || n == *retries {
+			return n, err
+		}
+		verbosef("%s failed, retrying in %s: %s", what, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-runCtx.Done():
+			return n, err
+		}
+		delay *= 2
+	}
+}
+
+// retriesNote describes the retries for the message, if there were any.
+func retriesNote(n int) string {
+	switch n {
+	case 0:
+		return ""
+	case 1:
+		return " (after 1 retry)"
+	}
+	return fmt.Sprintf(" (after %d retries)", n)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// schedule is a parsed cron expression of the 'schedule' option. Every field
+// holds the matching values as a bit set.
+type schedule struct {
+	minute, hour, dom, month, dow uint64
+	// domAny and dowAny are set for '*', since cron matches either the day
+	// of the month or the day of the week if both are restricted.
+	domAny, dowAny bool
+}
+
+// parseSchedule parses a cron expression of five fields: minute, hour, day of
+// the month, month and day of the week. A field may be '*', a value, a range
+// like '1-5' or a list of those, each optionally followed by a step like
+// '*/15'. Sunday is 0 or 7.
+func parseSchedule(expr string) (*schedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected 5 fields, got %d", len(fields))
+	}
+	var s schedule
+	var err error
+	if s.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("minute: %s", err)
+	}
+	if s.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("hour: %s", err)
+	}
+	if s.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("day of month: %s", err)
+	}
+	if s.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("month: %s", err)
+	}
+	if s.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("day of week: %s", err)
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domAny, s.dowAny = fields[2] == "*", fields[4] == "*"
+	if s.prev(time.Now()).IsZero() {
+		return nil, fmt.Errorf("the expression never matches")
+	}
+	return &s, nil
+}
+
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q", part[i+1:])
+			}
+			part = part[:i]
+		}
+		lo, hi := min, max
+		if part != "*" {
+			var err error
+			bounds := strings.SplitN(part, "-", 2)
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid value %q", bounds[0])
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid value %q", bounds[1])
+				}
+			} else if step > 1 {
+				// like cron, 'n/step' runs from n to the end of the range
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q is out of range %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func (s *schedule) matchesDay(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domAny || s.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// prev returns the latest scheduled time before t in the local time zone, or
+// zero if there is none within the last five years.
+func (s *schedule) prev(t time.Time) time.Time {
+	t = t.In(time.Local).Truncate(time.Minute).Add(-time.Minute)
+	limit := t.AddDate(-5, 0, 0)
+	for t.After(limit) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.Local).Add(-time.Minute)
+		case !s.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local).Add(-time.Minute)
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			// Truncate would round in absolute time, which is off by the
+			// fraction of an hour of zones like +05:30
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location()).Add(-time.Minute)
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(-time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// backupSchedule is the parsed value of the 'schedule' option, if any.
+var backupSchedule *schedule
+
+// maxMissedRuns limits counting the missed runs of very frequent schedules.
+const maxMissedRuns = 100
+
+// checkSchedule counts the runs of the 'schedule' since the latest snapshot
+// which should have finished by now, allowing for the 'schedule-grace'.
+func checkSchedule(res *result, latest, now time.Time) subResult {
+	missed := 0
+	var last time.Time
+	for t := backupSchedule.prev(now.Add(-*scheduleGrace).Add(time.Minute)); !t.IsZero() && t.After(latest) && missed < maxMissedRuns; t = backupSchedule.prev(t) {
+		if last.IsZero() {
+			last = t
+		}
+		missed++
+	}
+	res.MissedRuns = &missed
+
+	sub := subResult{Name: "schedule"}
+	switch {
+	case *scheduleMissedCrit > 0 && missed >= *scheduleMissedCrit:
+		sub.Status = CRITICAL
+	case *scheduleMissedWarn > 0 && missed >= *scheduleMissedWarn:
+		sub.Status = WARNING
+	}
+	if missed > 0 {
+		count := strconv.Itoa(missed)
+		if missed == maxMissedRuns {
+			count = "at least " + count
+		}
+		runs := "runs"
+		if missed == 1 {
+			runs = "run"
+		}
+		sub.Message = fmt.Sprintf("missed %s scheduled %s, the latest at %s", count, runs, last.Format("2006-01-02 15:04 MST"))
+	}
+	return sub
+}
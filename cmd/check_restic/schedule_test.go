@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// inZone runs f with the zone as the local time zone.
+func inZone(t *testing.T, loc *time.Location, f func()) {
+	t.Helper()
+	saved := time.Local
+	time.Local = loc
+	defer func() { time.Local = saved }()
+	f()
+}
+
+func TestSchedulePrev(t *testing.T) {
+	zones := []*time.Location{
+		time.UTC,
+		time.FixedZone("IST", 5*3600+30*60),
+		time.FixedZone("NPT", 5*3600+45*60),
+		time.FixedZone("NST", -(3*3600 + 30*60)),
+	}
+	tests := []struct {
+		expr string
+		now  string
+		want string
+	}{
+		{"45 1 * * *", "2026-01-14 12:00", "2026-01-14 01:45"},
+		{"15 * * * *", "2026-01-14 12:00", "2026-01-14 11:15"},
+		{"59 * * * *", "2026-01-14 12:00", "2026-01-14 11:59"},
+		{"0 * * * *", "2026-01-14 12:00", "2026-01-14 11:00"},
+		{"*/20 2 * * *", "2026-01-14 12:00", "2026-01-14 02:40"},
+		{"0 3 * * 1", "2026-01-14 12:00", "2026-01-12 03:00"},
+		{"30 12 * * *", "2026-01-14 12:30", "2026-01-13 12:30"},
+		{"0 0 1 * *", "2026-01-14 12:00", "2026-01-01 00:00"},
+	}
+	for _, loc := range zones {
+		inZone(t, loc, func() {
+			for _, tt := range tests {
+				s, err := parseSchedule(tt.expr)
+				if err != nil {
+					t.Errorf("%s: parseSchedule(%q): %s", loc, tt.expr, err)
+					continue
+				}
+				now, _ := time.ParseInLocation("2006-01-02 15:04", tt.now, loc)
+				want, _ := time.ParseInLocation("2006-01-02 15:04", tt.want, loc)
+				if got := s.prev(now); !got.Equal(want) {
+					t.Errorf("%s: prev(%q, %s) = %s, want %s", loc, tt.expr, tt.now, got.Format("2006-01-02 15:04"), tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseScheduleInvalid(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "*/0 * * * *", "0 0 31 2 *"} {
+		if _, err := parseSchedule(expr); err == nil {
+			t.Errorf("parseSchedule(%q) succeeded", expr)
+		}
+	}
+}
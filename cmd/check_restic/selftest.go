@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"time"
+)
+
+// runSelfTest verifies the transport to the repository's host end-to-end
+// without looking at any snapshots. Unless a writable directory was given, it
+// only makes sure the repository path can be stat'ed, so that a read-only
+// backup target is never written to.
+func runSelfTest(repo repository) result {
+	res := result{Repo: repo, Snapshots: -1}
+	done := func(status int, msg string) result {
+		res.Status, res.Message = status, msg
+		return res
+	}
+	start := time.Now()
+
+	client, disconnect, err := connect(repo)
+	if err != nil {
+		return done(UNKNOWN, err.Error())
+	}
+	defer disconnect()
+
+	if *selfTestDir == "" {
+		if _, err := client.Stat(repo.Path); err != nil {
+			return done(UNKNOWN, err.Error())
+		}
+		return done(OK, fmt.Sprintf("self-test passed, repository reachable within %s", time.Since(start).Round(time.Millisecond)))
+	}
+
+	name := path.Join(*selfTestDir, fmt.Sprintf(".check_restic-self-test-%d-%d", os.Getpid(), start.UnixNano()))
+	payload := []byte("check_restic self-test " + start.Format(time.RFC3339Nano))
+
+	// write the file, read it back and remove it again
+	f, err := client.Create(name)
+	if err != nil {
+		return done(UNKNOWN, fmt.Sprintf("self-test could not create %s: %s", name, err))
+	}
+	_, err = f.Write(payload)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	removed := false
+	defer func() {
+		// clean up even if a later step failed
+		if !removed {
+			client.Remove(name)
+		}
+	}()
+	if err != nil {
+		return done(UNKNOWN, fmt.Sprintf("self-test could not write %s: %s", name, err))
+	}
+
+	f, err = client.Open(name)
+	if err != nil {
+		return done(UNKNOWN, fmt.Sprintf("self-test could not open %s: %s", name, err))
+	}
+	data, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		return done(UNKNOWN, fmt.Sprintf("self-test could not read %s: %s", name, err))
+	}
+	if !bytes.Equal(data, payload) {
+		return done(UNKNOWN, fmt.Sprintf("self-test read back different content from %s", name))
+	}
+
+	if err := client.Remove(name); err != nil {
+		return done(UNKNOWN, fmt.Sprintf("self-test could not remove %s: %s", name, err))
+	}
+	removed = true
+	return done(OK, fmt.Sprintf("self-test passed, round-tripped a temporary file within %s", time.Since(start).Round(time.Millisecond)))
+}
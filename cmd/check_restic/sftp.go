@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// connect returns an SFTP session to the host of the repository. Unless
+// 'reuse-connections' is disabled, the session is shared with all
+// repositories on the same host and the returned function does nothing, since
+// the sessions are only closed by connections.close.
+func connect(repo repository) (*sftp.Client, func(), error) {
+	if !*reuseConns {
+		return dial(repo)
+	}
+	client, err := connections.get(repo)
+	return client, func() {}, err
+}
+
+// pool holds one SFTP session per host, user and port. It is safe for
+// concurrent use, and a session is only opened once even if several
+// repositories ask for it at the same time.
+type pool struct {
+	mu     sync.Mutex
+	conns  map[string]*pooledConn
+	opened int
+}
+
+type pooledConn struct {
+	mu     sync.Mutex
+	client *sftp.Client
+	close  func()
+}
+
+// connections is the pool used by connect.
+var connections = &pool{conns: make(map[string]*pooledConn)}
+
+// get returns the session for the host of the repository, opening it if there
+// is none yet or the existing one no longer responds, e.g. because the ssh
+// process died. Failed attempts are not remembered, so that every repository
+// reports the connection error on its own.
+func (p *pool) get(repo repository) (*sftp.Client, error) {
+	key := repo.User + "@" + net.JoinHostPort(repo.Host, repo.Port)
+	p.mu.Lock()
+	pc, ok := p.conns[key]
+	if !ok {
+		pc = &pooledConn{}
+		p.conns[key] = pc
+	}
+	p.mu.Unlock()
+
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if pc.client != nil {
+		if _, err := pc.client.Getwd(); err == nil {
+			verbosef("reusing connection to %s for %s", key, repo.Path)
+			return pc.client, nil
+		}
+		verbosef("connection to %s was lost, reconnecting", key)
+		pc.close()
+		pc.client, pc.close = nil, nil
+	}
+	client, closeFn, err := dial(repo)
+	if err != nil {
+		return nil, err
+	}
+	pc.client, pc.close = client, closeFn
+	p.mu.Lock()
+	p.opened++
+	p.mu.Unlock()
+	return client, nil
+}
+
+// close closes all sessions of the pool.
+func (p *pool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.opened > 0 {
+		verbosef("opened %d ssh connection(s) for %d repositories", p.opened, len(repos))
+	}
+	for key, pc := range p.conns {
+		pc.mu.Lock()
+		if pc.client != nil {
+			pc.close()
+		}
+		pc.mu.Unlock()
+		delete(p.conns, key)
+	}
+	p.opened = 0
+}
+
+// bytesReceived and bytesSent count the data exchanged with all ssh
+// processes, they are updated atomically.
+var bytesReceived, bytesSent int64
+
+// countingReader counts the bytes read from r in *n.
+type countingReader struct {
+	r io.Reader
+	n *int64
+}
+
+func (c countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddInt64(c.n, int64(n))
+	return n, err
+}
+
+// countingWriter counts the bytes written to w in *n.
+type countingWriter struct {
+	w io.WriteCloser
+	n *int64
+}
+
+func (c countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	atomic.AddInt64(c.n, int64(n))
+	return n, err
+}
+
+func (c countingWriter) Close() error {
+	return c.w.Close()
+}
+
+// dial opens an SFTP session to the host of the repository with the
+// 'ssh-client'.
+func dial(repo repository) (*sftp.Client, func(), error) {
+	if *sshClient == "native" {
+		return dialNative(repo)
+	}
+	return dialOpenSSH(repo)
+}
+
+// sshArgs returns the ssh command line requesting the sftp subsystem on the
+// host of the repository.
+func sshArgs(repo repository) ([]string, error) {
+	args := []string{"ssh", repo.Host}
+	if *sshConfigFile != "" {
+		args = append(args, "-F", *sshConfigFile)
+	}
+	if repo.User != "" {
+		args = append(args, "-l", repo.User)
+	}
+	// the default port leaves it to the 'ssh-config'
+	if *sshConfigFile == "" || repo.Port != defaultPort {
+		args = append(args, "-p", repo.Port)
+	}
+	if *proxyCommand != "" {
+		// ssh expands the %h and %p tokens on its own
+		args = append(args, "-o", "ProxyCommand="+*proxyCommand)
+	}
+	if *proxyJump != "" {
+		args = append(args, "-J", *proxyJump)
+	}
+	if *knownHostsFile != "" {
+		args = append(args, "-o", "UserKnownHostsFile="+*knownHostsFile, "-o", "StrictHostKeyChecking=yes")
+	}
+	identity, err := identityArgs()
+	if err != nil {
+		return nil, err
+	}
+	args = append(append(args, identity...), pkcs11Args()...)
+	return append(args, "-s", "sftp"), nil
+}
+
+// dialOpenSSH opens an SFTP session to the host of the repository via the ssh
+// command. The returned function closes the session and waits for the ssh
+// process to exit.
+func dialOpenSSH(repo repository) (*sftp.Client, func(), error) {
+	// Connect to a remote host and request the sftp subsystem via the 'ssh'
+	// command, or the 'ssh-command'. This assumes that passwordless login is
+	// correctly configured.
+	var args []string
+	var err error
+	if sshCommand != nil {
+		args, err = sshCommandArgs(repo)
+	} else {
+		args, err = sshArgs(repo)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	cmd := exec.CommandContext(connCtx(), args[0], args[1:]...)
+	env, err := sshEnv()
+	if err != nil {
+		return nil, nil, err
+	}
+	cmd.Env = env
+
+	// send errors from ssh to stderr, but also keep them to explain a failed
+	// connection attempt
+	var stderr bytes.Buffer
+	cmd.Stderr = io.MultiWriter(&logWriter{step: "ssh"}, &stderr)
+
+	// get stdin and stdout
+	wr, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	rd, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// start the process
+	debugf("connect", "starting ssh", "host", repo.Host, "command", strings.Join(cmd.Args, " "))
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		return nil, nil, err
+	}
+
+	// open the SFTP session
+	client, err := sftp.NewClientPipe(countingReader{rd, &bytesReceived}, countingWriter{wr, &bytesSent})
+	if err != nil {
+		wr.Close()
+		cmd.Wait()
+		msg := strings.TrimSpace(stderr.String())
+		if strings.Contains(msg, "REMOTE HOST IDENTIFICATION HAS CHANGED") {
+			return nil, nil, fmt.Errorf("%w: ssh refused to connect to %s", errHostKeyChanged, repo.Host)
+		}
+		if msg != "" {
+			return nil, nil, fmt.Errorf("%s (%s)", err, strings.Join(strings.Split(msg, "\n"), "; "))
+		}
+		return nil, nil, err
+	}
+	debugf("handshake", "sftp session established", "host", repo.Host, "took", time.Since(start))
+	return client, func() {
+		client.Close()
+		cmd.Wait()
+	}, nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"check_restic/pkg/checkrestic"
+)
+
+// estimateDataSize sums the sizes of the pack files below 'data/'. Walking
+// every shard directory is slow for large repositories, so only pct percent
+// of them, evenly spread, are listed and the total is extrapolated from them.
+// The sampled directories are listed 'list-concurrency' at once. Pack files
+// listed directly below 'data/' are summed up exactly. It returns
+// the estimated size and the percentage actually sampled.
+func (c *repoCheck) estimateDataSize(pct int) (int64, int, error) {
+	dir := path.Join(c.repo.Path, "data")
+	entries, err := c.fs.ReadDir(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+	shards := make([]string, 0, len(entries))
+	var flat int64
+	for _, fi := range entries {
+		if fi.IsDir() {
+			shards = append(shards, fi.Name())
+		} else if fi.Mode().IsRegular() {
+			flat += fi.Size()
+		}
+	}
+	// the rest backend lists all pack files at once instead of the shards
+	if len(shards) == 0 {
+		return flat, 100, nil
+	}
+	sort.Strings(shards)
+
+	n := (len(shards)*pct + 99) / 100
+	sampled := make([]string, n)
+	for i := range sampled {
+		sampled[i] = path.Join(dir, shards[i*len(shards)/n])
+	}
+	listings, err := checkrestic.ReadDirs(c.fs, sampled, *listConcurrency)
+	if err != nil {
+		return 0, 0, err
+	}
+	var size int64
+	for _, files := range listings {
+		for _, fi := range files {
+			if fi.Mode().IsRegular() {
+				size += fi.Size()
+			}
+		}
+	}
+	verbosef("sampled %d of %d directories below %s", n, len(shards), dir)
+	return size * int64(len(shards)) / int64(n), n * 100 / len(shards), nil
+}
+
+// checkSize reports the size of the repository data and compares it with
+// 'size-warning' and 'size-critical'.
+func checkSize(size int64, sampled int) subResult {
+	sub := subResult{Name: "size", Message: fmt.Sprintf("data %s", formatBytes(size))}
+	if sampled < 100 {
+		sub.Message = fmt.Sprintf("data ~%s (sampled %d%%)", formatBytes(size), sampled)
+	}
+	if sizeCritical > 0 && size > sizeCritical {
+		sub.Status = CRITICAL
+		sub.Message += fmt.Sprintf(", expected at most %s", formatBytes(sizeCritical))
+	} else if sizeWarning > 0 && size > sizeWarning {
+		sub.Status = WARNING
+		sub.Message += fmt.Sprintf(", expected at most %s", formatBytes(sizeWarning))
+	}
+	return sub
+}
+
+// sizeUnits maps the suffixes understood by parseSize to their factors. Like
+// restic, all of them are binary units.
+var sizeUnits = []struct {
+	suffix string
+	factor int64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+	{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30}, {"T", 1 << 40},
+	{"B", 1},
+}
+
+// parseSize parses a size like '500G' or '1.5TiB'. A number without a suffix
+// is a number of bytes.
+func parseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	factor := int64(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(strings.ToUpper(s), strings.ToUpper(u.suffix)) {
+			s, factor = strings.TrimSpace(s[:len(s)-len(u.suffix)]), u.factor
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(v * float64(factor)), nil
+}
+
+// formatBytes formats a size using binary units, just like restic does.
+func formatBytes(size int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB"}
+	v, i := float64(size), 0
+	for v >= 1024 && i < len(units)-1 {
+		v /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%d B", size)
+	}
+	return fmt.Sprintf("%.1f %s", v, units[i])
+}
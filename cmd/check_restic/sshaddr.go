@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// defaultPort is the port of ssh, which the 'ssh-config' may override.
+const defaultPort = "22"
+
+// splitAddress separates a port given along with the host, like 'host:2222'
+// or '[2001:db8::1]:2222', which takes precedence over port. An IPv6 literal
+// may also be given without brackets if it has no port, e.g. '2001:db8::1'.
+func splitAddress(host, port string) (string, string, error) {
+	if strings.HasPrefix(host, "[") {
+		if strings.HasSuffix(host, "]") {
+			host = host[1 : len(host)-1]
+		} else {
+			h, p, err := net.SplitHostPort(host)
+			if err != nil {
+				return "", "", err
+			}
+			host, port = h, p
+		}
+		if net.ParseIP(host) == nil {
+			return "", "", fmt.Errorf("%q is not an IPv6 address", host)
+		}
+	} else if strings.Count(host, ":") == 1 {
+		h, p, err := net.SplitHostPort(host)
+		if err != nil {
+			return "", "", err
+		}
+		host, port = h, p
+	} else if strings.Contains(host, ":") && net.ParseIP(host) == nil {
+		return "", "", fmt.Errorf("%q is neither a hostname nor an IPv6 address", host)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return "", "", fmt.Errorf("invalid port %q", port)
+	}
+	return host, port, nil
+}
+
+// sshHostConfig holds the settings of ssh_config for a host the native ssh
+// client uses. Empty fields are not set.
+type sshHostConfig struct {
+	HostName     string
+	Port         string
+	User         string
+	IdentityFile string
+}
+
+// sshConfigBlock is a 'Host' section of an ssh_config file.
+type sshConfigBlock struct {
+	patterns []string
+	settings map[string]string
+}
+
+// sshConfig is the parsed file of the 'ssh-config' option, nil if there is
+// none.
+var sshConfig []sshConfigBlock
+
+// loadSSHConfig reads the 'Host' sections of an ssh_config file. 'Match'
+// sections are skipped, as are 'Include' directives.
+func loadSSHConfig(name string) ([]sshConfigBlock, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// the settings before the first section apply to every host
+	blocks := []sshConfigBlock{{patterns: []string{"*"}, settings: map[string]string{}}}
+	current := &blocks[0]
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		keyword, args := line, ""
+		if i := strings.IndexAny(line, " \t="); i >= 0 {
+			keyword, args = line[:i], strings.TrimLeft(strings.TrimSpace(line[i:]), "= \t")
+		}
+		switch keyword = strings.ToLower(keyword); keyword {
+		case "host":
+			blocks = append(blocks, sshConfigBlock{patterns: strings.Fields(args), settings: map[string]string{}})
+			current = &blocks[len(blocks)-1]
+		case "match":
+			blocks = append(blocks, sshConfigBlock{settings: map[string]string{}})
+			current = &blocks[len(blocks)-1]
+		default:
+			if _, ok := current.settings[keyword]; !ok {
+				current.settings[keyword] = strings.Trim(args, `"`)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return blocks, nil
+}
+
+// matches reports whether the host matches any of the patterns of the block
+// and none of its negated ones.
+func (b sshConfigBlock) matches(host string) bool {
+	matched := false
+	for _, p := range b.patterns {
+		negated := strings.HasPrefix(p, "!")
+		if ok, _ := path.Match(strings.ToLower(strings.TrimPrefix(p, "!")), strings.ToLower(host)); ok {
+			if negated {
+				return false
+			}
+			matched = true
+		}
+	}
+	return matched
+}
+
+// lookupSSHConfig returns the settings of the 'ssh-config' for the host, by
+// the first value obtained for each like ssh does.
+func lookupSSHConfig(host string) sshHostConfig {
+	var hc sshHostConfig
+	for _, b := range sshConfig {
+		if !b.matches(host) {
+			continue
+		}
+		for _, s := range []struct {
+			keyword string
+			field   *string
+		}{
+			{"hostname", &hc.HostName},
+			{"port", &hc.Port},
+			{"user", &hc.User},
+			{"identityfile", &hc.IdentityFile},
+		} {
+			if v, ok := b.settings[s.keyword]; ok && *s.field == "" {
+				*s.field = v
+			}
+		}
+	}
+	hc.HostName = strings.ReplaceAll(hc.HostName, "%h", host)
+	if strings.HasPrefix(hc.IdentityFile, "~/") {
+		home, _ := os.UserHomeDir()
+		hc.IdentityFile = filepath.Join(home, hc.IdentityFile[2:])
+	}
+	return hc
+}
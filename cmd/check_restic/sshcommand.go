@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// sshCommand is the parsed 'ssh-command', nil if ssh is run with the
+// arguments derived from the other options.
+var sshCommand []string
+
+// sshCommandPlaceholder matches the placeholders of the 'ssh-command'.
+var sshCommandPlaceholder = regexp.MustCompile(`\{[^{}]*\}`)
+
+// sshCommandPlaceholders are the placeholders the 'ssh-command' may contain.
+var sshCommandPlaceholders = map[string]bool{"{host}": true, "{port}": true, "{user}": true, "{identity}": true}
+
+// splitCommand splits a command line into its arguments like a POSIX shell,
+// without expanding anything: arguments are separated by whitespace, single
+// quotes keep everything up to the next one and double quotes everything but
+// an escaped double quote or backslash. Outside of single quotes a backslash
+// only escapes a quote, a backslash or whitespace and is kept otherwise, so
+// Windows paths like 'C:\Tools\plink.exe' need no quoting.
+func splitCommand(s string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	inArg := false
+	var quote rune
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case r == '\\' && i+1 < len(runes) && (runes[i+1] == '"' || runes[i+1] == '\\' || quote == 0 && strings.ContainsRune("' \t\n", runes[i+1])):
+			i++
+			arg.WriteRune(runes[i])
+			inArg = true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, inArg = r, true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+// parseSSHCommand parses and validates the 'ssh-command' template.
+func parseSSHCommand(s string) ([]string, error) {
+	args, err := splitCommand(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 {
+		return nil, errors.New("the command is empty")
+	}
+	if sshCommandPlaceholder.MatchString(args[0]) {
+		return nil, errors.New("the program must not be a placeholder")
+	}
+	found := false
+	for _, arg := range args {
+		for _, p := range sshCommandPlaceholder.FindAllString(arg, -1) {
+			if !sshCommandPlaceholders[p] {
+				return nil, fmt.Errorf("unknown placeholder %s", p)
+			}
+			found = found || p == "{host}"
+		}
+	}
+	if !found {
+		return nil, errors.New("the placeholder {host} is missing")
+	}
+	return args, nil
+}
+
+// sshCommandUses reports whether the 'ssh-command' contains the placeholder.
+func sshCommandUses(placeholder string) bool {
+	for _, arg := range sshCommand {
+		if strings.Contains(arg, placeholder) {
+			return true
+		}
+	}
+	return false
+}
+
+// sshCommandArgs expands the placeholders of the 'ssh-command' for the
+// repository. The values are substituted into the arguments after splitting
+// them, so they are passed on unchanged whatever characters they contain.
+func sshCommandArgs(repo repository) ([]string, error) {
+	if repo.User == "" && sshCommandUses("{user}") {
+		return nil, fmt.Errorf("the 'ssh-command' needs a user for %s", repo.Host)
+	}
+	r := strings.NewReplacer("{host}", repo.Host, "{port}", repo.Port, "{user}", repo.User, "{identity}", *identityFile)
+	args := make([]string, len(sshCommand))
+	for i, arg := range sshCommand {
+		args[i] = r.Replace(arg)
+	}
+	return args, nil
+}
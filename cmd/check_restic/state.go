@@ -0,0 +1,555 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// state is persisted in the file given via the 'state-file' option to relate
+// the results of consecutive runs.
+type state struct {
+	Repositories map[string]*repoState `json:"repositories"`
+}
+
+// repoState is the state kept for a single repository.
+type repoState struct {
+	// History lists the statuses of the most recent runs, oldest first.
+	History []stateEntry `json:"history,omitempty"`
+
+	// Oldest is the time of the oldest snapshot as first seen at
+	// OldestSince, when the repository held OldestCount snapshots.
+	Oldest      time.Time `json:"oldest,omitempty"`
+	OldestSince time.Time `json:"oldest_since,omitempty"`
+	OldestCount int       `json:"oldest_count,omitempty"`
+
+	// Cached is the result of the most recent run, reused for the
+	// 'result-cache-ttl' by runs with the same parameters.
+	Cached *cachedResult `json:"cached,omitempty"`
+
+	// Reported is the status reported by the most recent run, after any
+	// suppression, for the 'on-change-command'.
+	Reported *int `json:"reported,omitempty"`
+
+	// Paths is the baseline of 'snapshot-paths-change-detection': every path
+	// backed up by the latest snapshots since it was established, sorted.
+	Paths []string `json:"paths,omitempty"`
+
+	// Identity is the identity of the repository as first seen at
+	// IdentitySince, for 'detect-reinit'.
+	Identity      *repoIdentity `json:"identity,omitempty"`
+	IdentitySince time.Time     `json:"identity_since,omitempty"`
+
+	// Sizes are the sizes of the repository data measured within the
+	// 'growth-window', oldest first.
+	Sizes []sizeEntry `json:"sizes,omitempty"`
+
+	// Seen is what the most recent successful run saw, for 'detect-trends'.
+	Seen *seenEntry `json:"seen,omitempty"`
+}
+
+type seenEntry struct {
+	Time      time.Time `json:"time"`
+	LatestID  string    `json:"latest_id,omitempty"`
+	Latest    time.Time `json:"latest,omitempty"`
+	Snapshots int       `json:"snapshots"`
+	DataSize  int64     `json:"data_size,omitempty"`
+}
+
+type sizeEntry struct {
+	Time time.Time `json:"time"`
+	Size int64     `json:"size"`
+}
+
+// maxSizes limits the number of sizes kept within the 'growth-window', runs
+// measuring more often only replace the latest one.
+const maxSizes = 100
+
+// minGrowthPeriod is the shortest period the growth is averaged over, since
+// the sizes estimated from samples vary too much in between.
+const minGrowthPeriod = time.Hour
+
+// repoIdentity is taken from the config of a repository, which 'restic init'
+// creates anew for every repository.
+type repoIdentity struct {
+	ID                string `json:"id"`
+	ChunkerPolynomial string `json:"chunker_polynomial"`
+}
+
+type cachedResult struct {
+	Time        time.Time `json:"time"`
+	Key         string    `json:"key"`
+	Status      int       `json:"status"`
+	Message     string    `json:"message"`
+	Snapshots   int       `json:"snapshots"`
+	LatestID    string    `json:"latest_id,omitempty"`
+	Latest      time.Time `json:"latest,omitempty"`
+	LatestHost  string    `json:"latest_host,omitempty"`
+	LatestTags  []string  `json:"latest_tags,omitempty"`
+	LatestPaths []string  `json:"latest_paths,omitempty"`
+	Age         int64     `json:"age,omitempty"`
+	Oldest      time.Time `json:"oldest,omitempty"`
+	RepoVersion int       `json:"repo_version,omitempty"`
+
+	DataSize        int64 `json:"data_size,omitempty"`
+	DataSizeSampled int   `json:"data_size_sampled,omitempty"`
+
+	PerHost  []hostSummary `json:"per_host,omitempty"`
+	Headroom time.Duration `json:"headroom,omitempty"`
+
+	ActualStatus int `json:"actual_status,omitempty"`
+
+	MissedRuns        *int           `json:"missed_runs,omitempty"`
+	IntegrityCheckAge *time.Duration `json:"integrity_check_age,omitempty"`
+	CadenceGap        *time.Duration `json:"cadence_gap,omitempty"`
+	FreeSpace         *freeSpace     `json:"free_space,omitempty"`
+
+	Locks  *lockSummary `json:"locks,omitempty"`
+	Checks []jsonCheck  `json:"checks,omitempty"`
+}
+
+type stateEntry struct {
+	Time   time.Time `json:"time"`
+	Status int       `json:"status"`
+}
+
+// maxHistory limits the number of runs kept in the history of a repository.
+const maxHistory = 32
+
+// key identifies the repository in the state file.
+func (repo repository) key() string {
+	return fmt.Sprintf("%s@%s:%s:%s", repo.User, repo.Host, repo.Port, repo.Path)
+}
+
+// loadState reads the state file, treating a missing file as empty state.
+func loadState(name string) (*state, error) {
+	st := &state{Repositories: make(map[string]*repoState)}
+	data, err := os.ReadFile(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return st, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, st); err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+	if st.Repositories == nil {
+		st.Repositories = make(map[string]*repoState)
+	}
+	return st, nil
+}
+
+// save atomically replaces the state file, so that it is never left behind
+// partially written.
+func (st *state) save(name string) error {
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(name, data)
+}
+
+// writeFileAtomic writes data to a temporary file next to name and renames it
+// to name afterwards.
+func writeFileAtomic(name string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
+
+// repo returns the state of the repository, creating it if necessary.
+func (st *state) repo(repo repository) *repoState {
+	rs, ok := st.Repositories[repo.key()]
+	if !ok {
+		rs = &repoState{}
+		st.Repositories[repo.key()] = rs
+	}
+	return rs
+}
+
+// record appends the status of the current run to the history.
+func (rs *repoState) record(now time.Time, status int) {
+	rs.History = append(rs.History, stateEntry{Time: now, Status: status})
+	if len(rs.History) > maxHistory {
+		rs.History = rs.History[len(rs.History)-maxHistory:]
+	}
+}
+
+// report stores the status reported by this run and returns whether it
+// differs from the one reported by the previous run. The very first run has
+// nothing to compare with and is therefore not a change.
+func (rs *repoState) report(status int) bool {
+	changed := rs.Reported != nil && *rs.Reported != status
+	rs.Reported = &status
+	return changed
+}
+
+// cacheIgnoredFlags lists the options which do not influence the result of a
+// check, only how it is presented or what happens afterwards.
+var cacheIgnoredFlags = map[string]bool{
+	"output": true, "color": true, "splay": true,
+	"ping-url": true, "ping-timeout": true, "proxy-url": true,
+	"state-file": true, "result-cache-ttl": true, "on-change-command": true,
+	"unknown-as": true, "unknown-as-invalid-args": true,
+	"reuse-connections": true, "summarize": true, "decode-concurrency": true,
+	"status-file": true, "list-concurrency": true,
+	"debug": true, "log-file": true, "log-format": true,
+}
+
+// cacheKey identifies the parameters a result was obtained with: the settings
+// of the repository as well as all options influencing the check.
+func cacheKey(repo repository) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%+v", repo)
+	flag.Visit(func(f *flag.Flag) {
+		if !cacheIgnoredFlags[f.Name] {
+			fmt.Fprintf(h, "\x00%s=%s", f.Name, f.Value)
+		}
+	})
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// cache stores the result for reuse by subsequent runs.
+func (rs *repoState) cache(res result, now time.Time) {
+	rs.Cached = &cachedResult{
+		Time:        now,
+		Key:         cacheKey(res.Repo),
+		Status:      res.Status,
+		Message:     res.Message,
+		Snapshots:   res.Snapshots,
+		LatestID:    res.LatestID,
+		Latest:      res.Latest,
+		LatestHost:  res.LatestHost,
+		LatestTags:  res.LatestTags,
+		LatestPaths: res.LatestPaths,
+		Age:         int64(res.Age),
+		Oldest:      res.Oldest,
+		RepoVersion: res.RepoVersion,
+
+		DataSize:        res.DataSize,
+		DataSizeSampled: res.DataSizeSampled,
+
+		PerHost:  res.PerHost,
+		Headroom: res.Headroom,
+
+		ActualStatus: res.ActualStatus,
+
+		MissedRuns:        res.MissedRuns,
+		IntegrityCheckAge: res.IntegrityCheckAge,
+		CadenceGap:        res.CadenceGap,
+		FreeSpace:         res.FreeSpace,
+
+		Locks:  res.Locks,
+		Checks: jsonChecks(res.Checks),
+	}
+}
+
+// cachedResult returns the result of a previous run with the same parameters
+// if it is not older than 'result-cache-ttl'.
+func (rs *repoState) cachedResult(repo repository, now time.Time) (result, bool) {
+	c := rs.Cached
+	if c == nil || c.Key != cacheKey(repo) || now.Sub(c.Time) > *resultCacheTTL || now.Before(c.Time) {
+		return result{}, false
+	}
+	verbosef("using result for %s cached %s ago", repo.Path, now.Sub(c.Time).Round(time.Second))
+	return result{
+		Repo:        repo,
+		Status:      c.Status,
+		Message:     c.Message,
+		Snapshots:   c.Snapshots,
+		LatestID:    c.LatestID,
+		Latest:      c.Latest,
+		LatestHost:  c.LatestHost,
+		LatestTags:  c.LatestTags,
+		LatestPaths: c.LatestPaths,
+		Age:         time.Duration(c.Age),
+		Oldest:      c.Oldest,
+		RepoVersion: c.RepoVersion,
+
+		DataSize:        c.DataSize,
+		DataSizeSampled: c.DataSizeSampled,
+
+		PerHost:  c.PerHost,
+		Headroom: c.Headroom,
+
+		ActualStatus: c.ActualStatus,
+
+		MissedRuns:        c.MissedRuns,
+		IntegrityCheckAge: c.IntegrityCheckAge,
+		CadenceGap:        c.CadenceGap,
+		FreeSpace:         c.FreeSpace,
+
+		Locks:  c.Locks,
+		Checks: cachedChecks(c.Checks),
+	}, true
+}
+
+// cachedChecks restores the results of the individual checks of a cached
+// result.
+func cachedChecks(checks []jsonCheck) []subResult {
+	var out []subResult
+	for _, c := range checks {
+		sub := subResult{Name: c.Name, Status: c.StatusCode, Message: c.Message}
+		if c.Error != "" {
+			sub.Err = errors.New(c.Error)
+		}
+		out = append(out, sub)
+	}
+	return out
+}
+
+// suppressFlapping downgrades a CRITICAL or UNKNOWN result to WARNING if the
+// repository was OK within the 'flap-window' and has not failed for
+// 'flap-count' consecutive runs yet, including the current one. This trades
+// detection latency for fewer pages caused by transient failures, e.g. a
+// backup server rebooting nightly. It must be called before the current run
+// is recorded.
+func (rs *repoState) suppressFlapping(res *result, now time.Time) {
+	if res.Status != CRITICAL && res.Status != UNKNOWN {
+		return
+	}
+
+	failures := 1
+	recentOK := false
+	for i := len(rs.History) - 1; i >= 0; i-- {
+		entry := rs.History[i]
+		if entry.Status == OK {
+			recentOK = now.Sub(entry.Time) <= *flapWindow
+			break
+		}
+		failures++
+	}
+	if !recentOK || failures >= *flapCount {
+		return
+	}
+
+	res.Message += fmt.Sprintf(" (%s suppressed as possible flapping, %d of %d)", getStatusStr(res.Status), failures, *flapCount)
+	res.Status = WARNING
+}
+
+// checkPrune returns WARNING if the oldest snapshot has not changed for longer
+// than the 'prune-stale-window' while the number of snapshots grew, which
+// suggests that old snapshots are never forgotten and pruned.
+func (rs *repoState) checkPrune(res *result, now time.Time) subResult {
+	sub := subResult{Name: "prune"}
+	if res.Snapshots <= 0 {
+		return sub
+	}
+	if !res.Oldest.Equal(rs.Oldest) {
+		rs.Oldest, rs.OldestSince, rs.OldestCount = res.Oldest, now, res.Snapshots
+		return sub
+	}
+
+	static := now.Sub(rs.OldestSince)
+	if static > *pruneStaleWindow && res.Snapshots > rs.OldestCount {
+		sub.Status = WARNING
+		sub.Message = fmt.Sprintf("oldest snapshot created %s ago has not changed for %s while the number of snapshots grew from %d to %d, forget/prune may not be running",
+			now.Sub(res.Oldest).Round(agePrecision), static.Round(agePrecision), rs.OldestCount, res.Snapshots)
+	}
+	return sub
+}
+
+// checkPaths compares the paths of the latest snapshot with the baseline and
+// returns WARNING if any path of the baseline is missing, since the backup
+// then silently covers less than it used to. New paths are only mentioned and
+// become part of the baseline. The first run, as well as one with
+// 'reset-baseline', establishes the baseline from the latest snapshot.
+func (rs *repoState) checkPaths(res *result) subResult {
+	sub := subResult{Name: "paths-change"}
+	if res.LatestPaths == nil {
+		return sub
+	}
+	latest := make(map[string]bool)
+	for _, p := range res.LatestPaths {
+		latest[path.Clean(p)] = true
+	}
+	if rs.Paths == nil || *resetBaseline {
+		rs.Paths = sortedKeys(latest)
+		if *resetBaseline {
+			sub.Message = "baseline of backed-up paths reset to " + strings.Join(rs.Paths, ", ")
+		}
+		return sub
+	}
+
+	baseline := make(map[string]bool)
+	var removed []string
+	for _, p := range rs.Paths {
+		baseline[p] = true
+		if !latest[p] {
+			removed = append(removed, p)
+		}
+	}
+	var added []string
+	for _, p := range sortedKeys(latest) {
+		if !baseline[p] {
+			added = append(added, p)
+			baseline[p] = true
+		}
+	}
+	rs.Paths = sortedKeys(baseline)
+
+	var found []string
+	if len(removed) > 0 {
+		sub.Status = WARNING
+		found = append(found, fmt.Sprintf("no longer backed up by the latest snapshot: %s, use 'reset-baseline' if this is intended", strings.Join(removed, ", ")))
+	}
+	if len(added) > 0 {
+		found = append(found, "newly backed up: "+strings.Join(added, ", "))
+	}
+	sub.Message = strings.Join(found, "; ")
+	return sub
+}
+
+// checkIdentity returns CRITICAL if the identity of the repository differs
+// from the recorded one: the repository was re-initialized or replaced, so
+// the snapshots made before are gone even if the latest one is fresh. The
+// recorded identity is kept until 'reset-baseline', so the alert persists.
+// The first run, as well as one with 'reset-baseline', records the identity.
+func (rs *repoState) checkIdentity(res *result, now time.Time) subResult {
+	sub := subResult{Name: "identity"}
+	if res.Identity == nil {
+		return sub
+	}
+	if rs.Identity == nil || *resetBaseline {
+		if rs.Identity != nil && *rs.Identity != *res.Identity {
+			sub.Message = fmt.Sprintf("recorded repository ID reset to %s", shortID(res.Identity.ID))
+		}
+		rs.Identity, rs.IdentitySince = res.Identity, now
+		return sub
+	}
+	if *rs.Identity == *res.Identity {
+		return sub
+	}
+
+	change := fmt.Sprintf("ID %s instead of %s", shortID(res.Identity.ID), shortID(rs.Identity.ID))
+	if res.Identity.ID == rs.Identity.ID {
+		change = fmt.Sprintf("chunker polynomial %s instead of %s", res.Identity.ChunkerPolynomial, rs.Identity.ChunkerPolynomial)
+	}
+	sub.Status = CRITICAL
+	sub.Message = fmt.Sprintf("repository identity changed (%s, recorded since %s), possible re-init or data loss, use 'reset-baseline' if this is intended",
+		change, rs.IdentitySince.Format(time.RFC3339))
+	return sub
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// checkGrowth records the size of the repository data and returns WARNING or
+// CRITICAL if it grew by more than 'growth-warning' or 'growth-critical' per
+// day, averaged since the oldest size recorded within the 'growth-window'.
+// Nothing is evaluated until the sizes span at least the minGrowthPeriod.
+func (rs *repoState) checkGrowth(res *result, now time.Time) subResult {
+	sub := subResult{Name: "growth"}
+	if res.DataSizeSampled == 0 {
+		return sub
+	}
+	kept := rs.Sizes[:0]
+	for _, e := range rs.Sizes {
+		if now.Sub(e.Time) <= *growthWindow && !e.Time.After(now) {
+			kept = append(kept, e)
+		}
+	}
+	entry := sizeEntry{Time: now, Size: res.DataSize}
+	if n := len(kept); n > 1 && now.Sub(kept[n-1].Time) < *growthWindow/maxSizes {
+		kept[n-1] = entry
+	} else {
+		kept = append(kept, entry)
+	}
+	rs.Sizes = kept
+
+	period := now.Sub(kept[0].Time)
+	if period < minGrowthPeriod {
+		return sub
+	}
+	growth := int64(float64(res.DataSize-kept[0].Size) * float64(24*time.Hour) / float64(period))
+	res.DataGrowth = &growth
+
+	status, limit := OK, int64(0)
+	if growthCritical > 0 && growth > growthCritical {
+		status, limit = CRITICAL, growthCritical
+	} else if growthWarning > 0 && growth > growthWarning {
+		status, limit = WARNING, growthWarning
+	}
+	if status != OK {
+		sub.Status = status
+		sub.Message = fmt.Sprintf("data grew by %s per day over the last %s, expected at most %s",
+			formatBytes(growth), period.Round(time.Minute), formatBytes(limit))
+	}
+	return sub
+}
+
+// checkTrends compares the snapshots and the data with what the previous run
+// saw and returns WARNING for changes which regular backups and pruning do not
+// cause: the number of snapshots dropping by more than the
+// 'count-drop-tolerance', the latest snapshot being replaced by an older one,
+// the data shrinking by more than half and, with a 'schedule', no new
+// snapshot appearing although a run was due since the previous run. Runs
+// which could not list the snapshots are not recorded.
+func (rs *repoState) checkTrends(res *result, now time.Time) subResult {
+	sub := subResult{Name: "trends"}
+	if res.Snapshots < 0 {
+		return sub
+	}
+	seen := &seenEntry{Time: now, LatestID: res.LatestID, Latest: res.Latest, Snapshots: res.Snapshots}
+	if res.DataSizeSampled > 0 {
+		seen.DataSize = res.DataSize
+	}
+	prev := rs.Seen
+	rs.Seen = seen
+	if prev == nil || !now.After(prev.Time) {
+		return sub
+	}
+
+	var anomalies []string
+	if drop := prev.Snapshots - res.Snapshots; drop > *countDropTolerance {
+		anomalies = append(anomalies, fmt.Sprintf("number of snapshots decreased from %d to %d", prev.Snapshots, res.Snapshots))
+	}
+	if prev.LatestID != "" && res.LatestID != "" && res.Latest.Before(prev.Latest) {
+		anomalies = append(anomalies, fmt.Sprintf("latest snapshot %s was replaced by the older %s", shortID(prev.LatestID), shortID(res.LatestID)))
+	}
+	if prev.DataSize > 0 && seen.DataSize > 0 && seen.DataSize < prev.DataSize/2 {
+		anomalies = append(anomalies, fmt.Sprintf("data shrank from %s to %s", formatBytes(prev.DataSize), formatBytes(seen.DataSize)))
+	} else if prev.DataSize > 0 && seen.DataSize == 0 {
+		// keep the size for the next run measuring it
+		seen.DataSize = prev.DataSize
+	}
+	if backupSchedule != nil && res.LatestID == prev.LatestID {
+		// the most recent run which should have finished by now
+		due := backupSchedule.prev(now.Add(-*scheduleGrace).Add(time.Minute))
+		if !due.IsZero() && due.Add(*scheduleGrace).After(prev.Time) {
+			anomalies = append(anomalies, fmt.Sprintf("no new snapshot since the previous check at %s despite the scheduled run at %s",
+				prev.Time.Local().Format("2006-01-02 15:04 MST"), due.Format("2006-01-02 15:04 MST")))
+		}
+	}
+	if len(anomalies) > 0 {
+		sub.Status = WARNING
+		sub.Message = strings.Join(anomalies, "; ")
+	}
+	return sub
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStateChecksAsSubResults(t *testing.T) {
+	setFlag(t, stateFile, t.TempDir()+"/state.json")
+	savedReinit, savedRepos := *detectReinit, repos
+	defer func() { *detectReinit, repos = savedReinit, savedRepos }()
+	*detectReinit = true
+	repos = []repository{{Path: "/srv/restic"}}
+
+	id := "1c2afc0e939761a8140ae562f6e4568e7f6001e2e8e9c1bd4aeb464e0d7ad0e8"
+	retries := 0
+	check := func(repo repository) result {
+		res := result{Repo: repo, Status: OK, Snapshots: 3, Retries: retries, Identity: &repoIdentity{ID: id, ChunkerPolynomial: "3dea92648f6e83"}}
+		res.Checks = []subResult{{Name: "age", Message: "latest snapshot created 2h0m0s ago"}, {Name: "repo-id"}}
+		res.Status, res.Message = aggregate(res.Checks)
+		res.Message += retriesNote(retries)
+		return res
+	}
+	now := time.Date(2026, 1, 14, 12, 0, 0, 0, time.UTC)
+	if _, results, err := checkAll(check, now); err != nil || results[0].Status != OK || len(results[0].Checks) != 3 {
+		t.Fatalf("recording the identity: %+v, %v", results, err)
+	}
+
+	id, retries = strings.Repeat("2", 64), 1
+	rc, results, err := checkAll(check, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := results[0]
+	if rc != CRITICAL || res.Status != CRITICAL {
+		t.Errorf("got %s", getStatusStr(res.Status))
+	}
+	last := res.Checks[len(res.Checks)-1]
+	if len(res.Checks) != 3 || last.Name != "identity" || last.Status != CRITICAL {
+		t.Errorf("got the checks %+v", res.Checks)
+	}
+	if want := "latest snapshot created 2h0m0s ago; repository identity changed (ID 22222222 instead of 1c2afc0e"; !strings.HasPrefix(res.Message, want) || !strings.HasSuffix(res.Message, " (after 1 retry)") {
+		t.Errorf("got %q", res.Message)
+	}
+	var found bool
+	for _, c := range jsonChecks(res.Checks) {
+		found = found || c.Name == "identity" && c.Status == "CRITICAL"
+	}
+	if !found {
+		t.Errorf("identity missing from the JSON checks %+v", jsonChecks(res.Checks))
+	}
+}
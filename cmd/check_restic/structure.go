@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+
+	"check_restic/pkg/checkrestic"
+)
+
+// structureDirs are the directories 'restic init' creates besides 'keys'.
+var structureDirs = []string{"data", "index", "snapshots"}
+
+// structureProblems returns what is missing from the repository for
+// 'check-structure': the config, a key, or one of the structureDirs. Object
+// stores have no directories, so only the config and the keys are verified
+// for them. Errors other than missing files, e.g. a lack of permissions, are
+// returned instead.
+func structureProblems(fsys checkrestic.FS, repoPath string) ([]string, error) {
+	var problems []string
+	_, err := fsys.ReadAt(path.Join(repoPath, "config"), 0, 1)
+	if errors.Is(err, fs.ErrNotExist) {
+		problems = append(problems, "config missing")
+	} else if err != nil {
+		return nil, err
+	}
+
+	keys, err := fsys.ReadDir(path.Join(repoPath, "keys"))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		problems = append(problems, "no keys")
+	}
+
+	s, ok := fsys.(checkrestic.StatFS)
+	if !ok {
+		return problems, nil
+	}
+	for _, dir := range structureDirs {
+		_, err := s.Stat(path.Join(repoPath, dir))
+		if errors.Is(err, fs.ErrNotExist) {
+			problems = append(problems, dir+"/ missing")
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	return problems, nil
+}
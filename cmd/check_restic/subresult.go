@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// subResult is the outcome of one of the checks of a repository, e.g. the age
+// of its latest snapshot or the per-host thresholds.
+type subResult struct {
+	// Name identifies the check in the message if it failed.
+	Name   string
+	Status int
+	// Message describes the result, it may be empty if there is nothing to
+	// report.
+	Message string
+	// Err is set if the check could not be performed, Status is UNKNOWN then.
+	Err error
+}
+
+// failed returns the result of a check which could not be performed.
+func failed(name string, err error) subResult {
+	return subResult{Name: name, Status: UNKNOWN, Err: err}
+}
+
+// aggregate combines the results of all checks of a repository into the
+// worst status and a single message. Since UNKNOWN ranks below WARNING and
+// CRITICAL, a check which failed does not hide problems found by the others.
+// If any check failed, the message ends with the status of the checks which
+// completed, so that it is clear which ones could still be relied on.
+func aggregate(checks []subResult) (int, string) {
+	status := OK
+	var parts, completed []string
+	anyFailed := false
+	for _, c := range checks {
+		status = worseStatus(status, c.Status)
+		if c.Err != nil {
+			anyFailed = true
+			parts = append(parts, fmt.Sprintf("%s %s (%s)", c.Name, getStatusStr(c.Status), c.Err))
+			continue
+		}
+		completed = append(completed, fmt.Sprintf("%s %s", c.Name, getStatusStr(c.Status)))
+		if c.Message != "" {
+			parts = append(parts, c.Message)
+		}
+	}
+	if anyFailed && len(completed) > 0 {
+		parts = append(parts, "completed: "+strings.Join(completed, ", "))
+	}
+	return status, strings.Join(parts, "; ")
+}
+
+// addChecks adds the results of further checks to those of the repository,
+// e.g. the ones comparing with the state of previous runs, and aggregates
+// all of them again.
+func (res *result) addChecks(checks ...subResult) {
+	if len(checks) == 0 {
+		return
+	}
+	res.Checks = append(res.Checks, checks...)
+	status, msg := aggregate(res.Checks)
+	res.Status, res.Message = status, msg+retriesNote(res.Retries)
+}
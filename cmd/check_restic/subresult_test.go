@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestAggregate(t *testing.T) {
+	denied := errors.New("permission denied")
+	tests := []struct {
+		name    string
+		checks  []subResult
+		status  int
+		message string
+	}{
+		{"none", nil, OK, ""},
+		{
+			"all completed",
+			[]subResult{
+				{Name: "age", Status: OK, Message: "latest snapshot 1c2afc0e created 2h0m0s ago"},
+				{Name: "size", Status: OK},
+				{Name: "locks", Status: WARNING, Message: "1 stale lock"},
+			},
+			WARNING, "latest snapshot 1c2afc0e created 2h0m0s ago; 1 stale lock",
+		},
+		{
+			"one failed",
+			[]subResult{
+				{Name: "age", Status: OK, Message: "latest snapshot 1c2afc0e created 2h0m0s ago"},
+				failed("locks", denied),
+				{Name: "size", Status: OK},
+			},
+			UNKNOWN, "latest snapshot 1c2afc0e created 2h0m0s ago; locks UNKNOWN (permission denied); completed: age OK, size OK",
+		},
+		{
+			// a failed check must not mask what the others found
+			"failed next to critical",
+			[]subResult{
+				failed("locks", denied),
+				{Name: "age", Status: CRITICAL, Message: "latest snapshot 1c2afc0e created 50h0m0s ago"},
+			},
+			CRITICAL, "locks UNKNOWN (permission denied); latest snapshot 1c2afc0e created 50h0m0s ago; completed: age CRITICAL",
+		},
+		{
+			"failed next to warning",
+			[]subResult{
+				{Name: "age", Status: WARNING, Message: "latest snapshot 1c2afc0e created 30h0m0s ago"},
+				failed("size", denied),
+			},
+			WARNING, "latest snapshot 1c2afc0e created 30h0m0s ago; size UNKNOWN (permission denied); completed: age WARNING",
+		},
+		{
+			"all failed",
+			[]subResult{failed("age", denied), failed("locks", errors.New("connection lost"))},
+			UNKNOWN, "age UNKNOWN (permission denied); locks UNKNOWN (connection lost)",
+		},
+	}
+	for _, tt := range tests {
+		status, message := aggregate(tt.checks)
+		if status != tt.status || message != tt.message {
+			t.Errorf("%s: got %s %q, want %s %q", tt.name, getStatusStr(status), message, getStatusStr(tt.status), tt.message)
+		}
+	}
+}
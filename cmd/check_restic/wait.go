@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"check_restic/pkg/checkrestic"
+)
+
+// waitSince is the parsed value of the 'wait-since' option, which defaults
+// to the time of invocation.
+var waitSince = time.Now()
+
+// runWait blocks until every repository has a snapshot newer than
+// 'wait-since', e.g. to verify a backup right after it was made even if the
+// backend lists new files with a delay. It returns CRITICAL once
+// 'wait-timeout' passes without one, and UNKNOWN if interrupted.
+func runWait() (int, string) {
+	deadline := time.Now().Add(*waitTimeout)
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupted)
+
+	results := make([]result, 0, len(repos))
+	for _, repo := range repos {
+		res := waitForSnapshot(repo, deadline, interrupted)
+		results = append(results, res)
+		if res.Status != OK {
+			break
+		}
+	}
+	newRedactor(nil).results(results)
+	rc, _ := summarize(results)
+	return rc, formatText(results)
+}
+
+func waitForSnapshot(repo repository, deadline time.Time, interrupted <-chan os.Signal) result {
+	res := result{Repo: repo, Snapshots: -1}
+	done := func(status int, msg string) result {
+		res.Status, res.Message = status, msg
+		return res
+	}
+
+	fsys, client, disconnect, err := openFS(repo)
+	if err != nil {
+		return done(UNKNOWN, err.Error())
+	}
+	defer disconnect()
+
+	c := &repoCheck{repo: repo, fs: fsys, client: client}
+	for {
+		files, layout, err := checkrestic.ListSnapshotFilesConcurrently(fsys, repo.Path, *repoLayout, *listConcurrency)
+		if err != nil {
+			return done(UNKNOWN, err.Error())
+		}
+		c.files, c.layout, c.snapshots = checkrestic.SnapshotFiles(files), layout, nil
+		snapshots := checkrestic.ListedSnapshots(c.files)
+		if newestBy != "modtime" {
+			if snapshots, err = c.decoded(); err != nil {
+				return done(UNKNOWN, err.Error())
+			}
+		}
+		var latest *checkrestic.Snapshot
+		for _, sn := range snapshots {
+			if latest == nil || snapshotTime(sn).After(snapshotTime(latest)) {
+				latest = sn
+			}
+		}
+		if latest != nil && snapshotTime(latest).After(waitSince) {
+			res.Snapshots, res.LatestID, res.Latest = len(snapshots), latest.ID, snapshotTime(latest)
+			return done(OK, fmt.Sprintf("snapshot %s created %s appeared", shortID(latest.ID), res.Latest.Format(time.RFC3339)))
+		}
+		verbosef("no snapshot newer than %s in %s yet", waitSince.Format(time.RFC3339), repo.Path)
+
+		wait := *waitInterval
+		if remaining := time.Until(deadline); remaining <= 0 {
+			return done(CRITICAL, fmt.Sprintf("no snapshot newer than %s appeared within %s", waitSince.Format(time.RFC3339), *waitTimeout))
+		} else if remaining < wait {
+			wait = remaining
+		}
+		select {
+		case <-time.After(wait):
+		case sig := <-interrupted:
+			return done(UNKNOWN, fmt.Sprintf("interrupted by %s while waiting for a snapshot", sig))
+		}
+	}
+}
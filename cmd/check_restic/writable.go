@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// sshFxNoSpaceOnFilesystem is the status code some SFTP servers report if the
+// disk is full, most others only report a generic failure.
+const sshFxNoSpaceOnFilesystem = 14
+
+// probeWritable creates and deletes a tiny file below tmp/, where restic
+// stages its own files, since a repository which went read-only still looks
+// fresh until the next backup fails. Recent versions of restic no longer
+// create tmp/, the repository itself is used then. It returns a description
+// of the problem if the repository is not writable.
+func (c *repoCheck) probeWritable() string {
+	dir := path.Join(c.repo.Path, "tmp")
+	if _, err := c.client.Stat(dir); errors.Is(err, os.ErrNotExist) {
+		dir = c.repo.Path
+	}
+	name := path.Join(dir, fmt.Sprintf(".check_restic-writable-%d-%d", os.Getpid(), time.Now().UnixNano()))
+	f, err := c.client.Create(name)
+	if err != nil {
+		return writeProblem(err)
+	}
+	_, err = f.Write([]byte("check_restic\n"))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	// remove the probe even if writing it failed
+	if rerr := c.client.Remove(name); err == nil {
+		err = rerr
+	}
+	if err != nil {
+		return writeProblem(err)
+	}
+	return ""
+}
+
+// writeProblem explains why writing to the repository failed.
+func writeProblem(err error) string {
+	var se *sftp.StatusError
+	switch {
+	case errors.As(err, &se) && se.Code == sshFxNoSpaceOnFilesystem,
+		strings.Contains(strings.ToLower(err.Error()), "no space left"):
+		return "repository is not writable, the disk is full"
+	case errors.Is(err, os.ErrPermission):
+		return "repository is not writable, permission denied"
+	}
+	return fmt.Sprintf("repository is not writable: %s", err)
+}
@@ -2,10 +2,17 @@ module check_restic
 
 go 1.17
 
-require github.com/pkg/sftp v1.13.4
+require (
+	github.com/klauspost/compress v1.15.15
+	github.com/miekg/pkcs11 v1.1.1
+	github.com/pkg/sftp v1.13.4
+	golang.org/x/crypto v0.11.0
+	golang.org/x/net v0.12.0
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
 	github.com/kr/fs v0.1.0 // indirect
-	golang.org/x/crypto v0.0.0-20220214200702-86341886e292 // indirect
-	golang.org/x/sys v0.0.0-20220209214540-3681064d5158 // indirect
+	golang.org/x/sys v0.10.0 // indirect
+	golang.org/x/text v0.11.0 // indirect
 )
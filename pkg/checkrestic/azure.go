@@ -0,0 +1,277 @@
+package checkrestic
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// azureVersion is the version of the Blob service REST API used, the oldest
+// one accepting OAuth tokens is 2017-11-09.
+const azureVersion = "2020-04-08"
+
+// AzureFS implements FS on top of a container of the Azure Blob service.
+type AzureFS struct {
+	Client *http.Client
+	// Endpoint is the URL of the blob service of the storage account, e.g.
+	// https://account.blob.core.windows.net.
+	Endpoint  string
+	Container string
+	// Authorize adds the credentials, see AzureSharedKey, AzureSAS and
+	// AzureManagedIdentity.
+	Authorize Authorizer
+}
+
+func (fs AzureFS) blobURL(name string) string {
+	segments := strings.Split(objectName(name), "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.TrimSuffix(fs.Endpoint, "/") + "/" + url.PathEscape(fs.Container) + "/" + strings.Join(segments, "/")
+}
+
+func (fs AzureFS) newRequest(rawURL string) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("x-ms-version", azureVersion)
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	return req, nil
+}
+
+type azureListResult struct {
+	Blobs struct {
+		Blob []struct {
+			Name       string `xml:"Name"`
+			Properties struct {
+				LastModified  string `xml:"Last-Modified"`
+				ContentLength int64  `xml:"Content-Length"`
+			} `xml:"Properties"`
+		} `xml:"Blob"`
+		BlobPrefix []struct {
+			Name string `xml:"Name"`
+		} `xml:"BlobPrefix"`
+	} `xml:"Blobs"`
+	NextMarker string `xml:"NextMarker"`
+}
+
+// ReadDir lists the blobs directly below the prefix of the directory, common
+// prefixes of deeper blobs are returned as directories. Just like for a
+// directory without files, the result is empty if there are no such blobs.
+func (fs AzureFS) ReadDir(name string) ([]os.FileInfo, error) {
+	prefix := objectPrefix(name)
+	var entries []os.FileInfo
+	marker := ""
+	for {
+		q := url.Values{}
+		q.Set("restype", "container")
+		q.Set("comp", "list")
+		q.Set("delimiter", "/")
+		if prefix != "" {
+			q.Set("prefix", prefix)
+		}
+		if marker != "" {
+			q.Set("marker", marker)
+		}
+		req, err := fs.newRequest(strings.TrimSuffix(fs.Endpoint, "/") + "/" + url.PathEscape(fs.Container) + "?" + q.Encode())
+		if err != nil {
+			return nil, err
+		}
+		body, err := do(fs.Client, fs.Authorize, req, "list "+name)
+		if err != nil {
+			return nil, err
+		}
+		var res azureListResult
+		err = xml.NewDecoder(body).Decode(&res)
+		body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("list %s: %s", name, err)
+		}
+		for _, b := range res.Blobs.Blob {
+			modTime, err := time.Parse(http.TimeFormat, b.Properties.LastModified)
+			if err != nil {
+				return nil, fmt.Errorf("list %s: %s: invalid modification time %q", name, b.Name, b.Properties.LastModified)
+			}
+			entries = append(entries, objectInfo{name: path.Base(b.Name), size: b.Properties.ContentLength, modTime: modTime})
+		}
+		for _, p := range res.Blobs.BlobPrefix {
+			entries = append(entries, objectInfo{name: path.Base(strings.TrimSuffix(p.Name, "/")), dir: true})
+		}
+		if res.NextMarker == "" {
+			return entries, nil
+		}
+		marker = res.NextMarker
+	}
+}
+
+func (fs AzureFS) ReadFile(name string) ([]byte, error) {
+	return fs.read(name, 0, -1)
+}
+
+func (fs AzureFS) ReadAt(name string, off int64, n int) ([]byte, error) {
+	return fs.read(name, off, n)
+}
+
+func (fs AzureFS) read(name string, off int64, n int) ([]byte, error) {
+	// the Range header needs to be part of the shared key signature, so the
+	// range is set before authorizing
+	authorize := func(req *http.Request) error {
+		req.Header.Set("x-ms-version", azureVersion)
+		req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+		if r := req.Header.Get("Range"); r != "" {
+			req.Header.Del("Range")
+			req.Header.Set("x-ms-range", r)
+		}
+		if fs.Authorize == nil {
+			return nil
+		}
+		return fs.Authorize(req)
+	}
+	return readRange(fs.Client, authorize, fs.blobURL(name), name, off, n)
+}
+
+// AzureSharedKey authorizes requests with the access key of the storage
+// account.
+func AzureSharedKey(account, key string) (Authorizer, error) {
+	secret, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid account key: %s", err)
+	}
+	return func(req *http.Request) error {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(azureStringToSign(account, req)))
+		req.Header.Set("Authorization", fmt.Sprintf("SharedKey %s:%s", account, base64.StdEncoding.EncodeToString(mac.Sum(nil))))
+		return nil
+	}, nil
+}
+
+// azureStringToSign builds the string signed for the shared key
+// authorization of a request without a body.
+func azureStringToSign(account string, req *http.Request) string {
+	var b strings.Builder
+	b.WriteString(req.Method + "\n")
+	for _, h := range []string{"Content-Encoding", "Content-Language", "Content-Length", "Content-MD5", "Content-Type", "Date",
+		"If-Modified-Since", "If-Match", "If-None-Match", "If-Unmodified-Since", "Range"} {
+		b.WriteString(req.Header.Get(h) + "\n")
+	}
+
+	var headers []string
+	for name := range req.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-ms-") {
+			headers = append(headers, lower)
+		}
+	}
+	sort.Strings(headers)
+	for _, h := range headers {
+		b.WriteString(h + ":" + strings.TrimSpace(req.Header.Get(h)) + "\n")
+	}
+
+	b.WriteString("/" + account + req.URL.EscapedPath())
+	query := req.URL.Query()
+	params := make([]string, 0, len(query))
+	for name := range query {
+		params = append(params, name)
+	}
+	sort.Strings(params)
+	for _, p := range params {
+		values := query[p]
+		sort.Strings(values)
+		b.WriteString("\n" + strings.ToLower(p) + ":" + strings.Join(values, ","))
+	}
+	return b.String()
+}
+
+// AzureSAS authorizes requests with a shared access signature, the query
+// string of a SAS URL.
+func AzureSAS(sas string) (Authorizer, error) {
+	values, err := url.ParseQuery(strings.TrimPrefix(sas, "?"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid shared access signature: %s", err)
+	}
+	return func(req *http.Request) error {
+		q := req.URL.Query()
+		for name, v := range values {
+			q[name] = v
+		}
+		req.URL.RawQuery = q.Encode()
+		return nil
+	}, nil
+}
+
+// AzureManagedIdentity authorizes requests with tokens of the managed
+// identity of the Azure VM or container the check runs on, obtained from the
+// instance metadata service. clientID selects a user-assigned identity, the
+// system-assigned one is used if it is empty.
+func AzureManagedIdentity(client *http.Client, clientID string) Authorizer {
+	t := &BearerToken{Fetch: func() (string, time.Duration, error) {
+		q := url.Values{}
+		q.Set("api-version", "2018-02-01")
+		q.Set("resource", "https://storage.azure.com/")
+		if clientID != "" {
+			q.Set("client_id", clientID)
+		}
+		req, err := http.NewRequest(http.MethodGet, "http://169.254.169.254/metadata/identity/oauth2/token?"+q.Encode(), nil)
+		if err != nil {
+			return "", 0, err
+		}
+		req.Header.Set("Metadata", "true")
+		return fetchToken(client, req)
+	}}
+	return t.Authorize
+}
+
+// AzureConnectionString holds the settings of a storage account connection
+// string as shown in the Azure portal.
+type AzureConnectionString struct {
+	Account  string
+	Key      string
+	SAS      string
+	Endpoint string
+}
+
+// ParseAzureConnectionString parses a connection string like
+// 'DefaultEndpointsProtocol=https;AccountName=...;AccountKey=...;EndpointSuffix=core.windows.net'.
+func ParseAzureConnectionString(s string) (AzureConnectionString, error) {
+	settings := make(map[string]string)
+	for _, part := range strings.Split(strings.TrimSpace(s), ";") {
+		if part == "" {
+			continue
+		}
+		i := strings.Index(part, "=")
+		if i <= 0 {
+			return AzureConnectionString{}, fmt.Errorf("invalid connection string")
+		}
+		settings[part[:i]] = part[i+1:]
+	}
+
+	cs := AzureConnectionString{
+		Account:  settings["AccountName"],
+		Key:      settings["AccountKey"],
+		SAS:      settings["SharedAccessSignature"],
+		Endpoint: settings["BlobEndpoint"],
+	}
+	if cs.Endpoint == "" {
+		if cs.Account == "" {
+			return AzureConnectionString{}, fmt.Errorf("the connection string lacks AccountName and BlobEndpoint")
+		}
+		protocol, suffix := settings["DefaultEndpointsProtocol"], settings["EndpointSuffix"]
+		if protocol == "" {
+			protocol = "https"
+		}
+		if suffix == "" {
+			suffix = "core.windows.net"
+		}
+		cs.Endpoint = fmt.Sprintf("%s://%s.blob.%s", protocol, cs.Account, suffix)
+	}
+	return cs, nil
+}
@@ -0,0 +1,15 @@
+package checkrestic
+
+import "context"
+
+// Check is the check of a single repository: where its snapshots are listed
+// and how they are evaluated.
+type Check struct {
+	Lister SnapshotLister
+	Checker
+}
+
+// Run lists the snapshots and evaluates them, see Checker.Check.
+func (c *Check) Run(ctx context.Context) (Result, error) {
+	return c.Checker.Check(ctx, c.Lister)
+}
@@ -0,0 +1,382 @@
+// Package checkrestic checks the age of the snapshots of restic repositories
+// without restic itself, just like the check_restic plugin does, e.g. to
+// embed the checks into a monitoring agent:
+//
+//	check := checkrestic.Check{
+//		Lister: &checkrestic.FSLister{FS: checkrestic.LocalFS{}, Path: "/srv/restic/web1"},
+//		Checker: checkrestic.Checker{
+//			Thresholds: checkrestic.Thresholds{Warning: 26 * time.Hour, Critical: 50 * time.Hour},
+//		},
+//	}
+//	res, err := check.Run(ctx)
+//	fmt.Println(res) // e.g. OK: latest snapshot 1c2afc0e created 3h0m0s ago | 'age'=10800s;93600;180000;0 'snapshots'=42;;;0
+//
+// The backends implement FS, the listers SnapshotLister. The exported API is
+// kept backwards compatible; the plugin itself adds what needs to outlive a
+// single check on top of it, like pooled ssh connections and the state file.
+package checkrestic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// The statuses of a check, which are also the exit codes of the plugin.
+const (
+	OK       = 0
+	WARNING  = 1
+	CRITICAL = 2
+	UNKNOWN  = 3
+)
+
+// WorseStatus returns the more severe of both statuses. CRITICAL is worse
+// than WARNING, which is worse than UNKNOWN, since failing backups should
+// be noticed before failing checks.
+func WorseStatus(a, b int) int {
+	rank := func(status int) int {
+		switch status {
+		case OK:
+			return 0
+		case UNKNOWN:
+			return 1
+		case WARNING:
+			return 2
+		default:
+			return 3
+		}
+	}
+	if rank(b) > rank(a) {
+		return b
+	}
+	return a
+}
+
+// SnapshotTime returns the time of the snapshot as selected by newestBy, one
+// of 'modtime' (of the snapshot file), 'snapshot-time' (recorded in the
+// snapshot), 'min-of-both' or 'max-of-both'.
+func SnapshotTime(sn *Snapshot, newestBy string) time.Time {
+	switch newestBy {
+	case "modtime":
+		return sn.ModTime
+	case "min-of-both":
+		if sn.ModTime.Before(sn.Time) {
+			return sn.ModTime
+		}
+		return sn.Time
+	case "max-of-both":
+		if sn.ModTime.After(sn.Time) {
+			return sn.ModTime
+		}
+		return sn.Time
+	default:
+		return sn.Time
+	}
+}
+
+// ShortID abbreviates a snapshot id the same way restic does.
+func ShortID(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
+
+// SnapshotLister provides the snapshots of a repository to a Checker.
+type SnapshotLister interface {
+	// ListSnapshots returns all snapshots of the repository. Unless they
+	// were decoded, only their IDs and modification times are known.
+	ListSnapshots(ctx context.Context) ([]*Snapshot, error)
+}
+
+// SnapshotList is a SnapshotLister of snapshots which were already listed.
+type SnapshotList []*Snapshot
+
+func (l SnapshotList) ListSnapshots(ctx context.Context) ([]*Snapshot, error) {
+	return l, nil
+}
+
+// ListedSnapshots returns the snapshots stored in the given files below
+// snapshots/ with only what is known from listing them, i.e. their IDs and
+// modification times.
+func ListedSnapshots(files []os.FileInfo) []*Snapshot {
+	snapshots := make([]*Snapshot, 0, len(files))
+	for _, fi := range files {
+		snapshots = append(snapshots, &Snapshot{ID: fi.Name(), ModTime: ModTime(fi)})
+	}
+	return snapshots
+}
+
+// FSLister lists the snapshots of the repository at Path on any backend
+// implementing FS. If Password is set, the snapshots are decoded,
+// DecodeConcurrency at once. Layout defaults to LayoutAuto, whose shard
+// directories are listed ListConcurrency at once.
+type FSLister struct {
+	FS       FS
+	Path     string
+	Password string
+	Layout   string
+
+	DecodeConcurrency int
+	ListConcurrency   int
+}
+
+func (l *FSLister) ListSnapshots(ctx context.Context) ([]*Snapshot, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	layout := l.Layout
+	if layout == "" {
+		layout = LayoutAuto
+	}
+	files, layout, err := ListSnapshotFilesConcurrently(l.FS, l.Path, layout, l.ListConcurrency)
+	if err != nil {
+		return nil, err
+	}
+	files = SnapshotFiles(files)
+	if l.Password == "" {
+		return ListedSnapshots(files), nil
+	}
+	r, err := OpenRepo(l.FS, l.Path, l.Password)
+	if err != nil {
+		return nil, err
+	}
+	r.SnapshotLayout = layout
+	r.DecodeConcurrency = l.DecodeConcurrency
+	return r.SnapshotsContext(ctx, files)
+}
+
+// SFTPLister is an FSLister over an already open SFTP session.
+type SFTPLister struct {
+	Client   *sftp.Client
+	Path     string
+	Password string
+	Layout   string
+
+	DecodeConcurrency int
+	ListConcurrency   int
+}
+
+func (l *SFTPLister) ListSnapshots(ctx context.Context) ([]*Snapshot, error) {
+	fl := FSLister{
+		FS:                SFTPFS{l.Client},
+		Path:              l.Path,
+		Password:          l.Password,
+		Layout:            l.Layout,
+		DecodeConcurrency: l.DecodeConcurrency,
+		ListConcurrency:   l.ListConcurrency,
+	}
+	return fl.ListSnapshots(ctx)
+}
+
+// Thresholds are the limits for the age of the latest snapshot. A threshold
+// of 0 or less is not set.
+type Thresholds struct {
+	Warning  time.Duration
+	Critical time.Duration
+}
+
+// Status returns the status of a latest snapshot of the given age.
+func (t Thresholds) Status(age time.Duration) int {
+	if t.Critical > 0 && age > t.Critical {
+		return CRITICAL
+	}
+	if t.Warning > 0 && age > t.Warning {
+		return WARNING
+	}
+	return OK
+}
+
+// Checker evaluates the age of the latest snapshot of a repository.
+type Checker struct {
+	Thresholds
+
+	// NewestBy selects the time of a snapshot, see SnapshotTime. It defaults
+	// to 'modtime', the other choices require decoded snapshots.
+	NewestBy string
+
+	// AgePrecision is the duration ages in messages are rounded to, it
+	// defaults to a second.
+	AgePrecision time.Duration
+
+	// Hosts, Tags and Paths restrict the check to matching snapshots, if
+	// set, like the corresponding filters of restic: a snapshot needs to be
+	// of any of the hosts, have any of the tags and include all of the paths.
+	// A tag may list several comma-separated tags which all need to be
+	// present. All of them require decoded snapshots.
+	Hosts []string
+	Tags  []string
+	Paths []string
+
+	// ExcludeTags ignores the snapshots with any of the tags, e.g. those of
+	// a separate heartbeat backup. It requires decoded snapshots as well.
+	ExcludeTags []string
+
+	// Now returns the current time, it defaults to time.Now.
+	Now func() time.Time
+}
+
+// Result is the outcome of a Check.
+type Result struct {
+	Status  int
+	Message string
+
+	// Thresholds are those the age was evaluated with.
+	Thresholds Thresholds
+
+	// Count is the number of snapshots checked. Latest, LatestTime and Age
+	// describe the latest of them, Oldest is the time of the oldest one.
+	Count      int
+	Latest     *Snapshot
+	LatestTime time.Time
+	Age        time.Duration
+	Oldest     time.Time
+
+	// Snapshots are those checked, newest first.
+	Snapshots []*Snapshot
+
+	// PerHost and PerTag break the snapshots down by hostname and tag, if
+	// they were decoded.
+	PerHost []*Group
+	PerTag  []*Group
+}
+
+var errNotDecoded = errors.New("the snapshots need to be decoded")
+
+// Check lists the snapshots and evaluates the age of the latest of them. Its
+// error is only set if the snapshots could not be listed, the status of the
+// result is UNKNOWN in that case.
+func (c *Checker) Check(ctx context.Context, lister SnapshotLister) (Result, error) {
+	snapshots, err := lister.ListSnapshots(ctx)
+	if err != nil {
+		return Result{Status: UNKNOWN, Message: err.Error()}, err
+	}
+
+	decoded := len(snapshots) > 0 && !snapshots[0].Time.IsZero()
+	newestBy := c.NewestBy
+	if newestBy == "" {
+		newestBy = "modtime"
+	}
+	if !decoded && len(snapshots) > 0 && (newestBy != "modtime" || c.filters() || len(c.ExcludeTags) > 0) {
+		return Result{Status: UNKNOWN, Message: errNotDecoded.Error()}, errNotDecoded
+	}
+	snapshots = c.filter(snapshots)
+
+	res := Result{Count: len(snapshots), Thresholds: c.Thresholds}
+	if len(snapshots) == 0 {
+		res.Status, res.Message = CRITICAL, "no snapshots found"
+		if c.filters() {
+			res.Message = "no snapshots matching the filter found"
+		}
+		return res, nil
+	}
+	if decoded {
+		res.PerHost = GroupSnapshots(snapshots, ByHost)
+		res.PerTag = GroupSnapshots(snapshots, ByTag)
+	}
+
+	// sort snapshots by time, newest first
+	sorted := make([]*Snapshot, len(snapshots))
+	copy(sorted, snapshots)
+	sort.SliceStable(sorted, func(a, b int) bool {
+		return SnapshotTime(sorted[b], newestBy).Before(SnapshotTime(sorted[a], newestBy))
+	})
+
+	now := time.Now
+	if c.Now != nil {
+		now = c.Now
+	}
+	precision := c.AgePrecision
+	if precision <= 0 {
+		precision = time.Second
+	}
+
+	res.Snapshots = sorted
+	res.Latest = sorted[0]
+	res.LatestTime = SnapshotTime(res.Latest, newestBy)
+	res.Oldest = SnapshotTime(sorted[len(sorted)-1], newestBy)
+	res.Age = now().Sub(res.LatestTime)
+
+	// sanity check
+	if res.Age < 0 {
+		res.Status, res.Message = CRITICAL, "latest snapshot is in the future"
+		return res, nil
+	}
+	res.Message = fmt.Sprintf("latest snapshot %s created %s ago", ShortID(res.Latest.ID), res.Age.Round(precision))
+	res.Status = c.Status(res.Age)
+	return res, nil
+}
+
+// filters reports whether the snapshots are restricted by Hosts, Tags or
+// Paths.
+func (c *Checker) filters() bool {
+	return len(c.Hosts) > 0 || len(c.Tags) > 0 || len(c.Paths) > 0
+}
+
+// filter returns the snapshots matching Hosts, Tags and Paths but none of
+// ExcludeTags.
+func (c *Checker) filter(snapshots []*Snapshot) []*Snapshot {
+	if !c.filters() && len(c.ExcludeTags) == 0 {
+		return snapshots
+	}
+	contains := func(values []string, v string) bool {
+		for _, value := range values {
+			if value == v {
+				return true
+			}
+		}
+		return false
+	}
+	// hasTags reports whether the snapshot has all tags of any of the lists
+	hasTags := func(sn *Snapshot, lists []string) bool {
+		for _, list := range lists {
+			all := true
+			for _, tag := range strings.Split(list, ",") {
+				if tag != "" && !contains(sn.Tags, tag) {
+					all = false
+					break
+				}
+			}
+			if all {
+				return true
+			}
+		}
+		return false
+	}
+	hasPaths := func(sn *Snapshot) bool {
+		for _, p := range c.Paths {
+			found := false
+			for _, sp := range sn.Paths {
+				if path.Clean(sp) == path.Clean(p) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	}
+	filtered := make([]*Snapshot, 0, len(snapshots))
+	for _, sn := range snapshots {
+		if len(c.Hosts) > 0 && !contains(c.Hosts, sn.Hostname) {
+			continue
+		}
+		if len(c.Tags) > 0 && !hasTags(sn, c.Tags) {
+			continue
+		}
+		if !hasPaths(sn) || len(c.ExcludeTags) > 0 && hasTags(sn, c.ExcludeTags) {
+			continue
+		}
+		filtered = append(filtered, sn)
+	}
+	return filtered
+}
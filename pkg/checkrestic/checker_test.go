@@ -0,0 +1,221 @@
+package checkrestic
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+var testNow = time.Date(2026, 1, 14, 12, 0, 0, 0, time.UTC)
+
+func fixedNow() time.Time { return testNow }
+
+// decodedSnapshot returns a decoded snapshot created the given duration
+// before testNow.
+func decodedSnapshot(id string, ago time.Duration, host string, paths []string, tags ...string) *Snapshot {
+	return &Snapshot{ID: id, ModTime: testNow.Add(-ago), Time: testNow.Add(-ago), Hostname: host, Paths: paths, Tags: tags}
+}
+
+func TestThresholdsStatus(t *testing.T) {
+	tests := []struct {
+		th   Thresholds
+		age  time.Duration
+		want int
+	}{
+		{Thresholds{Warning: time.Hour, Critical: 2 * time.Hour}, 30 * time.Minute, OK},
+		{Thresholds{Warning: time.Hour, Critical: 2 * time.Hour}, time.Hour, OK},
+		{Thresholds{Warning: time.Hour, Critical: 2 * time.Hour}, time.Hour + time.Second, WARNING},
+		{Thresholds{Warning: time.Hour, Critical: 2 * time.Hour}, 2 * time.Hour, WARNING},
+		{Thresholds{Warning: time.Hour, Critical: 2 * time.Hour}, 3 * time.Hour, CRITICAL},
+		{Thresholds{Critical: 2 * time.Hour}, 90 * time.Minute, OK},
+		{Thresholds{Warning: time.Hour}, 100 * time.Hour, WARNING},
+		{Thresholds{Warning: -1, Critical: -1}, 100 * time.Hour, OK},
+		{Thresholds{}, 100 * time.Hour, OK},
+	}
+	for _, tt := range tests {
+		if got := tt.th.Status(tt.age); got != tt.want {
+			t.Errorf("%+v.Status(%s) = %d, want %d", tt.th, tt.age, got, tt.want)
+		}
+	}
+}
+
+func TestWorseStatus(t *testing.T) {
+	tests := []struct{ a, b, want int }{
+		{OK, OK, OK},
+		{OK, WARNING, WARNING},
+		{OK, UNKNOWN, UNKNOWN},
+		{UNKNOWN, WARNING, WARNING},
+		{WARNING, UNKNOWN, WARNING},
+		{CRITICAL, UNKNOWN, CRITICAL},
+		{WARNING, CRITICAL, CRITICAL},
+		{CRITICAL, OK, CRITICAL},
+	}
+	for _, tt := range tests {
+		if got := WorseStatus(tt.a, tt.b); got != tt.want {
+			t.Errorf("WorseStatus(%d, %d) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestSnapshotTime(t *testing.T) {
+	mod := testNow.Add(-time.Hour)
+	created := testNow.Add(-2 * time.Hour)
+	sn := &Snapshot{ModTime: mod, Time: created}
+	tests := []struct {
+		newestBy string
+		want     time.Time
+	}{
+		{"modtime", mod},
+		{"snapshot-time", created},
+		{"min-of-both", created},
+		{"max-of-both", mod},
+		{"", created},
+	}
+	for _, tt := range tests {
+		if got := SnapshotTime(sn, tt.newestBy); !got.Equal(tt.want) {
+			t.Errorf("SnapshotTime(%q) = %s, want %s", tt.newestBy, got, tt.want)
+		}
+	}
+}
+
+func TestCheckerCheck(t *testing.T) {
+	snapshots := SnapshotList{
+		decodedSnapshot("aaaaaaaa11", 3*time.Hour, "web1", []string{"/srv"}, "daily"),
+		decodedSnapshot("bbbbbbbb22", 30*time.Minute, "web1", []string{"/srv", "/etc"}, "daily", "full"),
+		decodedSnapshot("cccccccc33", 26*time.Hour, "db1", []string{"/var/lib/db"}),
+		decodedSnapshot("dddddddd44", 5*time.Minute, "web1", []string{"/tmp/hb"}, "heartbeat"),
+	}
+	th := Thresholds{Warning: time.Hour, Critical: 24 * time.Hour}
+	tests := []struct {
+		name    string
+		checker Checker
+		status  int
+		latest  string
+		count   int
+		message string
+	}{
+		{"all", Checker{Thresholds: th}, OK, "dddddddd44", 4, "latest snapshot dddddddd created 5m0s ago"},
+		{"exclude heartbeat", Checker{Thresholds: th, NewestBy: "snapshot-time", ExcludeTags: []string{"heartbeat"}}, OK, "bbbbbbbb22", 3, ""},
+		{"host", Checker{Thresholds: th, Hosts: []string{"db1"}}, CRITICAL, "cccccccc33", 1, ""},
+		{"any host", Checker{Thresholds: th, Hosts: []string{"db1", "web1"}, ExcludeTags: []string{"heartbeat"}}, OK, "bbbbbbbb22", 3, ""},
+		{"all tags of a list", Checker{Thresholds: th, Tags: []string{"daily,full"}}, OK, "bbbbbbbb22", 1, ""},
+		{"any tag list", Checker{Thresholds: th, Tags: []string{"full", "heartbeat"}}, OK, "dddddddd44", 2, ""},
+		{"all paths", Checker{Thresholds: th, Paths: []string{"/srv/", "/etc"}}, OK, "bbbbbbbb22", 1, ""},
+		{"path", Checker{Thresholds: th, Paths: []string{"/srv"}}, OK, "bbbbbbbb22", 2, ""},
+		{"warning", Checker{Thresholds: th, Tags: []string{"daily"}, Paths: []string{"/srv"}, Hosts: []string{"web1"}, ExcludeTags: []string{"full"}}, WARNING, "aaaaaaaa11", 1, "latest snapshot aaaaaaaa created 3h0m0s ago"},
+		{"no match", Checker{Thresholds: th, Hosts: []string{"mail1"}}, CRITICAL, "", 0, "no snapshots matching the filter found"},
+		{"excluded all", Checker{Thresholds: th, ExcludeTags: []string{"daily", "heartbeat"}, Hosts: []string{"web1"}}, CRITICAL, "", 0, "no snapshots matching the filter found"},
+	}
+	for _, tt := range tests {
+		tt.checker.Now = fixedNow
+		res, err := tt.checker.Check(context.Background(), snapshots)
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		latest := ""
+		if res.Latest != nil {
+			latest = res.Latest.ID
+		}
+		if res.Status != tt.status || latest != tt.latest || res.Count != tt.count {
+			t.Errorf("%s: got status %d, latest %q and %d snapshots, want %d, %q and %d", tt.name, res.Status, latest, res.Count, tt.status, tt.latest, tt.count)
+		}
+		if tt.message != "" && res.Message != tt.message {
+			t.Errorf("%s: got message %q, want %q", tt.name, res.Message, tt.message)
+		}
+		if res.Thresholds != th {
+			t.Errorf("%s: got thresholds %+v", tt.name, res.Thresholds)
+		}
+	}
+}
+
+func TestCheckerCheckOrder(t *testing.T) {
+	snapshots := SnapshotList{
+		decodedSnapshot("b", 2*time.Hour, "h", nil),
+		decodedSnapshot("c", 3*time.Hour, "h", nil),
+		decodedSnapshot("a", time.Hour, "h", nil),
+	}
+	c := Checker{Thresholds: Thresholds{Warning: 24 * time.Hour, Critical: 48 * time.Hour}, Now: fixedNow}
+	res, err := c.Check(context.Background(), snapshots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids string
+	for _, sn := range res.Snapshots {
+		ids += sn.ID
+	}
+	if ids != "abc" {
+		t.Errorf("snapshots in order %q, want newest first", ids)
+	}
+	if !res.Oldest.Equal(testNow.Add(-3*time.Hour)) || res.Age != time.Hour {
+		t.Errorf("got oldest %s and age %s", res.Oldest, res.Age)
+	}
+}
+
+func TestCheckerCheckEdgeCases(t *testing.T) {
+	c := Checker{Thresholds: Thresholds{Warning: time.Hour, Critical: 2 * time.Hour}, Now: fixedNow}
+
+	res, err := c.Check(context.Background(), SnapshotList{})
+	if err != nil || res.Status != CRITICAL || res.Message != "no snapshots found" {
+		t.Errorf("empty: got %d %q %v", res.Status, res.Message, err)
+	}
+
+	res, err = c.Check(context.Background(), SnapshotList{decodedSnapshot("f", -time.Hour, "h", nil)})
+	if err != nil || res.Status != CRITICAL || res.Message != "latest snapshot is in the future" {
+		t.Errorf("future: got %d %q %v", res.Status, res.Message, err)
+	}
+
+	// the times of listed snapshots are unknown
+	listed := SnapshotList{{ID: "l", ModTime: testNow.Add(-time.Minute)}}
+	res, err = c.Check(context.Background(), listed)
+	if err != nil || res.Status != OK {
+		t.Errorf("listed: got %d %q %v", res.Status, res.Message, err)
+	}
+	for _, needs := range []Checker{{NewestBy: "snapshot-time"}, {Hosts: []string{"h"}}, {ExcludeTags: []string{"t"}}} {
+		needs.Now = fixedNow
+		res, err = needs.Check(context.Background(), listed)
+		if err == nil || res.Status != UNKNOWN {
+			t.Errorf("%+v on listed snapshots: got %d %q %v", needs, res.Status, res.Message, err)
+		}
+	}
+
+	precise := c
+	precise.AgePrecision = time.Minute
+	res, _ = precise.Check(context.Background(), SnapshotList{decodedSnapshot("abcdefabcdef", 90*time.Second, "h", nil)})
+	if res.Message != "latest snapshot abcdefab created 2m0s ago" {
+		t.Errorf("precision: got %q", res.Message)
+	}
+}
+
+func TestCheckerAgePrecision(t *testing.T) {
+	tests := []struct {
+		precision time.Duration
+		age       time.Duration
+		want      string
+	}{
+		{0, 1500 * time.Millisecond, "2s"},
+		{0, 1499 * time.Millisecond, "1s"},
+		{time.Second, 59*time.Second + 500*time.Millisecond, "1m0s"},
+		{time.Minute, 29*time.Second + 999*time.Millisecond, "0s"},
+		{time.Minute, 30 * time.Second, "1m0s"},
+		{time.Minute, 89 * time.Second, "1m0s"},
+		{time.Minute, 90 * time.Second, "2m0s"},
+		{time.Hour, 29*time.Minute + 59*time.Second, "0s"},
+		{time.Hour, 30 * time.Minute, "1h0m0s"},
+		{time.Hour, 25*time.Hour + 30*time.Minute, "26h0m0s"},
+	}
+	for _, tt := range tests {
+		// the age is only rounded in the message, not for the thresholds
+		c := Checker{Thresholds: Thresholds{Warning: tt.age - time.Millisecond}, AgePrecision: tt.precision, Now: fixedNow}
+		res, err := c.Check(context.Background(), SnapshotList{decodedSnapshot("0123456789ab", tt.age, "h", nil)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "latest snapshot 01234567 created " + tt.want + " ago"; res.Message != want {
+			t.Errorf("precision %s, age %s: got %q, want %q", tt.precision, tt.age, res.Message, want)
+		}
+		if res.Age != tt.age || res.Status != WARNING {
+			t.Errorf("precision %s, age %s: got age %s and status %d", tt.precision, tt.age, res.Age, res.Status)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+// Package checkrestictest writes restic repositories for tests of the
+// checkrestic package and its users, just like restic would: the config, a
+// key opened by a password and the encrypted snapshots.
+package checkrestictest
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/crypto/poly1305"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Snapshot is a snapshot to write. The modification time of its file is set
+// to ModTime, or to Time if it is zero.
+type Snapshot struct {
+	Time     time.Time
+	ModTime  time.Time
+	Hostname string
+	Paths    []string
+	Tags     []string
+}
+
+// Repo is a repository written by WriteRepo.
+type Repo struct {
+	Path     string
+	Password string
+
+	// IDs are those of the snapshots, in the order they were given.
+	IDs []string
+
+	key key
+}
+
+// The scrypt parameters of the keys, which are far weaker than those of
+// restic to keep the tests fast.
+const (
+	scryptN = 1024
+	scryptR = 8
+	scryptP = 1
+)
+
+type macKey struct {
+	K []byte `json:"k"`
+	R []byte `json:"r"`
+}
+
+type key struct {
+	MAC     macKey `json:"mac"`
+	Encrypt []byte `json:"encrypt"`
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func newKey(data []byte) key {
+	return key{Encrypt: data[:32], MAC: macKey{K: data[32:48], R: data[48:64]}}
+}
+
+// encrypt returns IV || AES-256-CTR(plaintext) || Poly1305-AES MAC, the
+// format of every encrypted file of restic.
+func (k key) encrypt(plaintext []byte) []byte {
+	iv := randomBytes(aes.BlockSize)
+	c, err := aes.NewCipher(k.Encrypt)
+	if err != nil {
+		panic(err)
+	}
+	data := make([]byte, len(plaintext))
+	cipher.NewCTR(c, iv).XORKeyStream(data, plaintext)
+
+	mc, err := aes.NewCipher(k.MAC.K)
+	if err != nil {
+		panic(err)
+	}
+	var polyKey [32]byte
+	mask := [16]byte{0xff, 0xff, 0xff, 0x0f, 0xfc, 0xff, 0xff, 0x0f, 0xfc, 0xff, 0xff, 0x0f, 0xfc, 0xff, 0xff, 0x0f}
+	for i := range mask {
+		polyKey[i] = k.MAC.R[i] & mask[i]
+	}
+	mc.Encrypt(polyKey[16:], iv)
+	var tag [poly1305.TagSize]byte
+	poly1305.Sum(&tag, data, &polyKey)
+
+	out := append(iv, data...)
+	return append(out, tag[:]...)
+}
+
+// WriteRepo creates a repository below dir containing the snapshots, with
+// the snapshot files sharded into subdirectories if sharded is set.
+func WriteRepo(dir, password string, sharded bool, snapshots ...Snapshot) (*Repo, error) {
+	r := &Repo{Path: dir, Password: password, key: newKey(randomBytes(64))}
+	for _, sub := range []string{"keys", "snapshots", "data", "index", "locks"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			return nil, err
+		}
+	}
+
+	salt := randomBytes(64)
+	derived, err := scrypt.Key([]byte(password), salt, scryptN, scryptR, scryptP, 64)
+	if err != nil {
+		return nil, err
+	}
+	master, err := json.Marshal(r.key)
+	if err != nil {
+		return nil, err
+	}
+	kf, err := json.Marshal(map[string]interface{}{
+		"hostname": "test",
+		"username": "test",
+		"kdf":      "scrypt",
+		"N":        scryptN,
+		"r":        scryptR,
+		"p":        scryptP,
+		"salt":     salt,
+		"data":     newKey(derived).encrypt(master),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.writeFile("keys", kf, false); err != nil {
+		return nil, err
+	}
+
+	cfg, err := json.Marshal(map[string]interface{}{"version": 1, "id": hex.EncodeToString(randomBytes(32)), "chunker_polynomial": "3da3358b4dc173"})
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "config"), r.key.encrypt(cfg), 0o644); err != nil {
+		return nil, err
+	}
+
+	for _, sn := range snapshots {
+		id, err := r.AddSnapshot(sn, sharded)
+		if err != nil {
+			return nil, err
+		}
+		r.IDs = append(r.IDs, id)
+	}
+	return r, nil
+}
+
+// AddSnapshot writes another snapshot and returns its ID.
+func (r *Repo) AddSnapshot(sn Snapshot, sharded bool) (string, error) {
+	paths := sn.Paths
+	if paths == nil {
+		paths = []string{"/"}
+	}
+	data, err := json.Marshal(map[string]interface{}{
+		"time":     sn.Time,
+		"tree":     hex.EncodeToString(randomBytes(32)),
+		"paths":    paths,
+		"hostname": sn.Hostname,
+		"tags":     sn.Tags,
+	})
+	if err != nil {
+		return "", err
+	}
+	name, err := r.writeFile("snapshots", r.key.encrypt(data), sharded)
+	if err != nil {
+		return "", err
+	}
+	mtime := sn.ModTime
+	if mtime.IsZero() {
+		mtime = sn.Time
+	}
+	if err := os.Chtimes(name, mtime, mtime); err != nil {
+		return "", err
+	}
+	return filepath.Base(name), nil
+}
+
+// writeFile stores data below the directory, named by its hash like restic
+// does, and returns the name of the file.
+func (r *Repo) writeFile(dir string, data []byte, sharded bool) (string, error) {
+	sum := sha256.Sum256(data)
+	id := hex.EncodeToString(sum[:])
+	d := filepath.Join(r.Path, dir)
+	if sharded {
+		d = filepath.Join(d, id[:2])
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			return "", err
+		}
+	}
+	name := filepath.Join(d, id)
+	return name, os.WriteFile(name, data, 0o644)
+}
@@ -0,0 +1,135 @@
+package checkrestic
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/poly1305"
+	"golang.org/x/crypto/scrypt"
+)
+
+// The constants and the layout of encrypted data follow the restic design
+// document: every ciphertext is stored as IV || AES-256-CTR(plaintext) || MAC,
+// where the MAC is a Poly1305-AES tag over the encrypted plaintext.
+const (
+	ivSize  = aes.BlockSize
+	macSize = poly1305.TagSize
+)
+
+var errUnauthenticated = errors.New("ciphertext verification failed")
+
+// macKey is the Poly1305-AES key pair used to authenticate ciphertexts.
+type macKey struct {
+	K []byte `json:"k"`
+	R []byte `json:"r"`
+}
+
+// masterKey is the decrypted key of a repository, as stored in the encrypted
+// part of the files below keys/.
+type masterKey struct {
+	MAC     macKey `json:"mac"`
+	Encrypt []byte `json:"encrypt"`
+}
+
+// keyFile is the unencrypted JSON document stored below keys/.
+type keyFile struct {
+	Hostname string `json:"hostname"`
+	Username string `json:"username"`
+	KDF      string `json:"kdf"`
+	N        int    `json:"N"`
+	R        int    `json:"r"`
+	P        int    `json:"p"`
+	Salt     []byte `json:"salt"`
+	Data     []byte `json:"data"`
+}
+
+// poly1305KeyMask clears the bits of r which must be zero for Poly1305.
+var poly1305KeyMask = [16]byte{
+	0xff, 0xff, 0xff, 0x0f,
+	0xfc, 0xff, 0xff, 0x0f,
+	0xfc, 0xff, 0xff, 0x0f,
+	0xfc, 0xff, 0xff, 0x0f,
+}
+
+func (k *macKey) valid() bool {
+	return len(k.K) == 16 && len(k.R) == 16
+}
+
+func (k *macKey) mac(nonce, msg []byte) ([]byte, error) {
+	c, err := aes.NewCipher(k.K)
+	if err != nil {
+		return nil, err
+	}
+	var key [32]byte
+	for i := range poly1305KeyMask {
+		key[i] = k.R[i] & poly1305KeyMask[i]
+	}
+	c.Encrypt(key[16:], nonce)
+
+	var out [macSize]byte
+	poly1305.Sum(&out, msg, &key)
+	return out[:], nil
+}
+
+// decrypt verifies and decrypts ciphertext, returning the plaintext.
+func (k *masterKey) decrypt(ciphertext []byte) ([]byte, error) {
+	if !k.MAC.valid() || len(k.Encrypt) != 32 {
+		return nil, errors.New("invalid key")
+	}
+	if len(ciphertext) < ivSize+macSize {
+		return nil, errors.New("ciphertext too short")
+	}
+
+	iv := ciphertext[:ivSize]
+	data := ciphertext[ivSize : len(ciphertext)-macSize]
+	tag := ciphertext[len(ciphertext)-macSize:]
+
+	expected, err := k.MAC.mac(iv, data)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare(expected, tag) != 1 {
+		return nil, errUnauthenticated
+	}
+
+	c, err := aes.NewCipher(k.Encrypt)
+	if err != nil {
+		return nil, err
+	}
+	plaintext := make([]byte, len(data))
+	cipher.NewCTR(c, iv).XORKeyStream(plaintext, data)
+	return plaintext, nil
+}
+
+// openKeyFile derives the user key from password and uses it to decrypt the
+// master key stored in the key file.
+func openKeyFile(kf *keyFile, password string) (*masterKey, error) {
+	if kf.KDF != "scrypt" {
+		return nil, fmt.Errorf("unsupported key derivation function %q", kf.KDF)
+	}
+	derived, err := scrypt.Key([]byte(password), kf.Salt, kf.N, kf.R, kf.P, 64)
+	if err != nil {
+		return nil, err
+	}
+	user := &masterKey{
+		Encrypt: derived[:32],
+		MAC:     macKey{K: derived[32:48], R: derived[48:]},
+	}
+
+	plaintext, err := user.decrypt(kf.Data)
+	if err != nil {
+		return nil, err
+	}
+	var key masterKey
+	if err := json.Unmarshal(plaintext, &key); err != nil {
+		return nil, err
+	}
+	if !key.MAC.valid() || len(key.Encrypt) != 32 {
+		return nil, errors.New("invalid master key")
+	}
+	return &key, nil
+}
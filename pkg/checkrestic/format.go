@@ -0,0 +1,53 @@
+package checkrestic
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// StatusName returns the name of a status as shown by the plugin.
+func StatusName(status int) string {
+	switch status {
+	case OK:
+		return "OK"
+	case WARNING:
+		return "WARNING"
+	case CRITICAL:
+		return "CRITICAL"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// Perfdata returns the performance data of the result in the plugin format:
+// the age of the latest snapshot in seconds along with the thresholds, and
+// the number of snapshots.
+func (r Result) Perfdata() string {
+	var perf []string
+	if r.Latest != nil && r.Age >= 0 {
+		perf = append(perf, fmt.Sprintf("'age'=%ds;%s;%s;0", int64(r.Age.Seconds()), perfSeconds(r.Thresholds.Warning), perfSeconds(r.Thresholds.Critical)))
+	}
+	if r.Status != UNKNOWN || r.Count > 0 {
+		perf = append(perf, fmt.Sprintf("'snapshots'=%d;;;0", r.Count))
+	}
+	return strings.Join(perf, " ")
+}
+
+// String returns the status line of the plugin for the result, e.g.
+// "OK: latest snapshot 1c2afc0e created 3h0m0s ago | 'age'=10800s;...".
+func (r Result) String() string {
+	line := StatusName(r.Status) + ": " + r.Message
+	if perf := r.Perfdata(); perf != "" {
+		line += " | " + perf
+	}
+	return line
+}
+
+// perfSeconds returns the perfdata threshold for a limit, if it is set.
+func perfSeconds(limit time.Duration) string {
+	if limit <= 0 {
+		return ""
+	}
+	return fmt.Sprint(int64(limit.Seconds()))
+}
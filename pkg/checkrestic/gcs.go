@@ -0,0 +1,241 @@
+package checkrestic
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// gcsScope only allows reading the buckets.
+const gcsScope = "https://www.googleapis.com/auth/devstorage.read_only"
+
+// GCSFS implements FS on top of a Google Cloud Storage bucket via its JSON
+// API.
+type GCSFS struct {
+	Client *http.Client
+	// Endpoint is the URL of the API, it defaults to
+	// https://storage.googleapis.com.
+	Endpoint string
+	Bucket   string
+	// Authorize adds the credentials, see GCSServiceAccount and
+	// GCSDefaultCredentials. It may be nil for an emulator.
+	Authorize Authorizer
+}
+
+func (fs GCSFS) url(suffix string) string {
+	endpoint := fs.Endpoint
+	if endpoint == "" {
+		endpoint = "https://storage.googleapis.com"
+	}
+	return strings.TrimSuffix(endpoint, "/") + "/storage/v1/b/" + url.PathEscape(fs.Bucket) + "/o" + suffix
+}
+
+type gcsListResult struct {
+	Items []struct {
+		Name    string    `json:"name"`
+		Size    string    `json:"size"`
+		Updated time.Time `json:"updated"`
+	} `json:"items"`
+	Prefixes      []string `json:"prefixes"`
+	NextPageToken string   `json:"nextPageToken"`
+}
+
+// ReadDir lists the objects directly below the prefix of the directory,
+// common prefixes of deeper objects are returned as directories. Just like
+// for a directory without files, the result is empty if there are no such
+// objects.
+func (fs GCSFS) ReadDir(name string) ([]os.FileInfo, error) {
+	prefix := objectPrefix(name)
+	var entries []os.FileInfo
+	token := ""
+	for {
+		q := url.Values{}
+		q.Set("delimiter", "/")
+		q.Set("fields", "items(name,size,updated),prefixes,nextPageToken")
+		if prefix != "" {
+			q.Set("prefix", prefix)
+		}
+		if token != "" {
+			q.Set("pageToken", token)
+		}
+		req, err := http.NewRequest(http.MethodGet, fs.url("?"+q.Encode()), nil)
+		if err != nil {
+			return nil, err
+		}
+		body, err := do(fs.Client, fs.Authorize, req, "list "+name)
+		if err != nil {
+			return nil, err
+		}
+		var res gcsListResult
+		err = json.NewDecoder(body).Decode(&res)
+		body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("list %s: %s", name, err)
+		}
+		for _, item := range res.Items {
+			size, _ := strconv.ParseInt(item.Size, 10, 64)
+			entries = append(entries, objectInfo{name: path.Base(item.Name), size: size, modTime: item.Updated})
+		}
+		for _, p := range res.Prefixes {
+			entries = append(entries, objectInfo{name: path.Base(strings.TrimSuffix(p, "/")), dir: true})
+		}
+		if res.NextPageToken == "" {
+			return entries, nil
+		}
+		token = res.NextPageToken
+	}
+}
+
+func (fs GCSFS) ReadFile(name string) ([]byte, error) {
+	return readRange(fs.Client, fs.Authorize, fs.url("/"+url.PathEscape(objectName(name))+"?alt=media"), name, 0, -1)
+}
+
+func (fs GCSFS) ReadAt(name string, off int64, n int) ([]byte, error) {
+	return readRange(fs.Client, fs.Authorize, fs.url("/"+url.PathEscape(objectName(name))+"?alt=media"), name, off, n)
+}
+
+// gcsCredentials is the content of a service account key file or of the
+// application default credentials written by 'gcloud auth
+// application-default login'.
+type gcsCredentials struct {
+	Type string `json:"type"`
+
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// GCSServiceAccount authorizes requests with tokens for the service account
+// whose JSON key is given, or for the user of application default
+// credentials in the same format.
+func GCSServiceAccount(client *http.Client, keyJSON []byte) (Authorizer, error) {
+	var creds gcsCredentials
+	if err := json.Unmarshal(keyJSON, &creds); err != nil {
+		return nil, fmt.Errorf("invalid credentials file: %s", err)
+	}
+	if creds.TokenURI == "" {
+		creds.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+
+	var fetch func() (string, time.Duration, error)
+	switch creds.Type {
+	case "service_account":
+		block, _ := pem.Decode([]byte(creds.PrivateKey))
+		if block == nil {
+			return nil, errors.New("invalid credentials file: no private key found")
+		}
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		}
+		key, ok := parsed.(*rsa.PrivateKey)
+		if err != nil || !ok {
+			return nil, errors.New("invalid credentials file: the private key is not an RSA key")
+		}
+		fetch = func() (string, time.Duration, error) {
+			assertion, err := gcsAssertion(creds, key)
+			if err != nil {
+				return "", 0, err
+			}
+			return postToken(client, creds.TokenURI, url.Values{
+				"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+				"assertion":  {assertion},
+			})
+		}
+	case "authorized_user":
+		fetch = func() (string, time.Duration, error) {
+			return postToken(client, creds.TokenURI, url.Values{
+				"grant_type":    {"refresh_token"},
+				"client_id":     {creds.ClientID},
+				"client_secret": {creds.ClientSecret},
+				"refresh_token": {creds.RefreshToken},
+			})
+		}
+	default:
+		return nil, fmt.Errorf("unsupported credentials type %q", creds.Type)
+	}
+	t := &BearerToken{Fetch: fetch}
+	return t.Authorize, nil
+}
+
+// gcsAssertion returns a JWT asking for a token of the service account.
+func gcsAssertion(creds gcsCredentials, key *rsa.PrivateKey) (string, error) {
+	now := time.Now()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   creds.ClientEmail,
+		"scope": gcsScope,
+		"aud":   creds.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + enc.EncodeToString(sig), nil
+}
+
+func postToken(client *http.Client, tokenURI string, form url.Values) (string, time.Duration, error) {
+	req, err := http.NewRequest(http.MethodPost, tokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return fetchToken(client, req)
+}
+
+// GCSDefaultCredentials looks up the application default credentials like
+// the Google Cloud SDKs do: the file named by GOOGLE_APPLICATION_CREDENTIALS,
+// the one written by gcloud, or else the service account of the VM the check
+// runs on, via the metadata server.
+func GCSDefaultCredentials(client *http.Client) (Authorizer, error) {
+	if name := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); name != "" {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		return GCSServiceAccount(client, data)
+	}
+	if dir, err := os.UserConfigDir(); err == nil {
+		data, err := os.ReadFile(filepath.Join(dir, "gcloud", "application_default_credentials.json"))
+		if err == nil {
+			return GCSServiceAccount(client, data)
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	t := &BearerToken{Fetch: func() (string, time.Duration, error) {
+		req, err := http.NewRequest(http.MethodGet, "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token", nil)
+		if err != nil {
+			return "", 0, err
+		}
+		req.Header.Set("Metadata-Flavor", "Google")
+		return fetchToken(client, req)
+	}}
+	return t.Authorize, nil
+}
@@ -0,0 +1,54 @@
+package checkrestic
+
+import "sort"
+
+// Group collects the snapshots sharing the same key, e.g. hostname.
+type Group struct {
+	Key       string
+	Snapshots []*Snapshot
+	Newest    *Snapshot
+}
+
+// GroupSnapshots groups the snapshots by the keys returned for each of them,
+// sorted by key. A snapshot belongs to every group it has a key for.
+func GroupSnapshots(snapshots []*Snapshot, keys func(*Snapshot) []string) []*Group {
+	groups := make(map[string]*Group)
+	for _, sn := range snapshots {
+		for _, key := range keys(sn) {
+			g, ok := groups[key]
+			if !ok {
+				g = &Group{Key: key}
+				groups[key] = g
+			}
+			g.Snapshots = append(g.Snapshots, sn)
+			if g.Newest == nil || sn.Time.After(g.Newest.Time) {
+				g.Newest = sn
+			}
+		}
+	}
+
+	sorted := make([]*Group, 0, len(groups))
+	for _, g := range groups {
+		sorted = append(sorted, g)
+	}
+	sort.Slice(sorted, func(a, b int) bool {
+		return sorted[a].Key < sorted[b].Key
+	})
+	return sorted
+}
+
+// ByHost returns the hostname of a snapshot as key for GroupSnapshots.
+func ByHost(sn *Snapshot) []string {
+	return []string{sn.Hostname}
+}
+
+// ByPath returns the backed-up paths of a snapshot as keys for
+// GroupSnapshots.
+func ByPath(sn *Snapshot) []string {
+	return sn.Paths
+}
+
+// ByTag returns the tags of a snapshot as keys for GroupSnapshots.
+func ByTag(sn *Snapshot) []string {
+	return sn.Tags
+}
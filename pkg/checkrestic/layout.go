@@ -0,0 +1,70 @@
+package checkrestic
+
+import (
+	"fmt"
+	"os"
+	"path"
+)
+
+// The layouts of the snapshots directory. Most repositories store the
+// snapshot files directly in it, some backends shard them into
+// subdirectories named by the first two characters of their IDs, just like
+// the files below data/.
+const (
+	LayoutAuto    = "auto"
+	LayoutFlat    = "flat"
+	LayoutSharded = "sharded"
+)
+
+// isShard reports whether fi looks like a shard directory, i.e. is named by
+// two lowercase hex digits.
+func isShard(fi os.FileInfo) bool {
+	return fi.IsDir() && len(fi.Name()) == 2 && isHex(fi.Name())
+}
+
+// ListSnapshotFiles lists the files below snapshots/ in the given layout. The
+// 'auto' layout descends into any shard directories found next to the files.
+// It returns the entries unfiltered, see SnapshotFiles, along with the layout
+// found, which Repo.SnapshotLayout needs to be set to for decoding them.
+func ListSnapshotFiles(fs FS, repoPath, layout string) ([]os.FileInfo, string, error) {
+	return ListSnapshotFilesConcurrently(fs, repoPath, layout, 1)
+}
+
+// ListSnapshotFilesConcurrently is like ListSnapshotFiles, but lists up to
+// workers shard directories at once, see ReadDirs.
+func ListSnapshotFilesConcurrently(fs FS, repoPath, layout string, workers int) ([]os.FileInfo, string, error) {
+	dir := path.Join(repoPath, "snapshots")
+	entries, err := fs.ReadDir(dir)
+	if err != nil {
+		return nil, "", err
+	}
+	switch layout {
+	case LayoutFlat:
+		return entries, LayoutFlat, nil
+	case LayoutAuto, LayoutSharded:
+	default:
+		return nil, "", fmt.Errorf("unknown layout %q", layout)
+	}
+
+	files := make([]os.FileInfo, 0, len(entries))
+	var shards []string
+	for _, fi := range entries {
+		if isShard(fi) {
+			shards = append(shards, path.Join(dir, fi.Name()))
+		} else if layout == LayoutAuto {
+			files = append(files, fi)
+		}
+	}
+	listings, err := ReadDirs(fs, shards, workers)
+	if err != nil {
+		return nil, "", err
+	}
+	for _, shard := range listings {
+		files = append(files, shard...)
+	}
+	found := LayoutFlat
+	if len(shards) > 0 || layout == LayoutSharded {
+		found = LayoutSharded
+	}
+	return files, found, nil
+}
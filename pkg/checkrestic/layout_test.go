@@ -0,0 +1,160 @@
+package checkrestic
+
+import (
+	"errors"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeFileInfo is an os.FileInfo of a fake directory listing.
+type fakeFileInfo struct {
+	name    string
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (fi fakeFileInfo) Name() string       { return fi.name }
+func (fi fakeFileInfo) Size() int64        { return 0 }
+func (fi fakeFileInfo) Mode() os.FileMode  { return fi.mode }
+func (fi fakeFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi fakeFileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi fakeFileInfo) Sys() interface{}   { return nil }
+
+func file(name string) os.FileInfo { return fakeFileInfo{name: name} }
+func dir(name string) os.FileInfo  { return fakeFileInfo{name: name, mode: os.ModeDir} }
+
+// fakeFS is an FS serving directory listings only. It records the
+// directories listed.
+type fakeFS struct {
+	dirs map[string][]os.FileInfo
+	errs map[string]error
+
+	mu     sync.Mutex
+	listed []string
+}
+
+func (fs *fakeFS) ReadDir(name string) ([]os.FileInfo, error) {
+	fs.mu.Lock()
+	fs.listed = append(fs.listed, name)
+	fs.mu.Unlock()
+	if err := fs.errs[name]; err != nil {
+		return nil, err
+	}
+	entries, ok := fs.dirs[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return entries, nil
+}
+
+func (fs *fakeFS) ReadFile(name string) ([]byte, error) { return nil, os.ErrNotExist }
+
+func (fs *fakeFS) ReadAt(name string, off int64, n int) ([]byte, error) {
+	return nil, os.ErrNotExist
+}
+
+var (
+	id1 = strings.Repeat("1", 64)
+	id2 = "ab" + strings.Repeat("2", 62)
+	id3 = "ab" + strings.Repeat("3", 62)
+	id4 = "cd" + strings.Repeat("4", 62)
+)
+
+// flatFS and shardedFS hold the same snapshots in either layout, mixedFS has
+// both plain files and shards.
+func flatFS() *fakeFS {
+	return &fakeFS{dirs: map[string][]os.FileInfo{
+		"/repo/snapshots": {file(id1), file(id2), file(id3), file(id4)},
+	}}
+}
+
+func shardedFS() *fakeFS {
+	return &fakeFS{dirs: map[string][]os.FileInfo{
+		"/repo/snapshots":    {dir("ab"), dir("cd"), dir("tmp")},
+		"/repo/snapshots/ab": {file(id2), file(id3)},
+		"/repo/snapshots/cd": {file(id4)},
+	}}
+}
+
+func mixedFS() *fakeFS {
+	return &fakeFS{dirs: map[string][]os.FileInfo{
+		"/repo/snapshots":    {file(id1), dir("ab"), dir("cd")},
+		"/repo/snapshots/ab": {file(id2), file(id3)},
+		"/repo/snapshots/cd": {file(id4)},
+	}}
+}
+
+func names(files []os.FileInfo) string {
+	var n []string
+	for _, fi := range files {
+		name := fi.Name()
+		if len(name) > 3 {
+			name = name[:3]
+		}
+		n = append(n, name)
+	}
+	sort.Strings(n)
+	return strings.Join(n, " ")
+}
+
+func TestListSnapshotFiles(t *testing.T) {
+	tests := []struct {
+		name       string
+		fs         *fakeFS
+		layout     string
+		want       string
+		wantLayout string
+	}{
+		{"flat as flat", flatFS(), LayoutFlat, "111 ab2 ab3 cd4", LayoutFlat},
+		{"flat as auto", flatFS(), LayoutAuto, "111 ab2 ab3 cd4", LayoutFlat},
+		{"flat as sharded", flatFS(), LayoutSharded, "", LayoutSharded},
+		{"sharded as flat", shardedFS(), LayoutFlat, "ab cd tmp", LayoutFlat},
+		{"sharded as auto", shardedFS(), LayoutAuto, "ab2 ab3 cd4 tmp", LayoutSharded},
+		{"sharded as sharded", shardedFS(), LayoutSharded, "ab2 ab3 cd4", LayoutSharded},
+		{"mixed as auto", mixedFS(), LayoutAuto, "111 ab2 ab3 cd4", LayoutSharded},
+		{"mixed as sharded", mixedFS(), LayoutSharded, "ab2 ab3 cd4", LayoutSharded},
+	}
+	for _, tt := range tests {
+		for _, workers := range []int{0, 1, 4} {
+			files, layout, err := ListSnapshotFilesConcurrently(tt.fs, "/repo", tt.layout, workers)
+			if err != nil {
+				t.Errorf("%s with %d workers: %s", tt.name, workers, err)
+				continue
+			}
+			if got := names(files); got != tt.want || layout != tt.wantLayout {
+				t.Errorf("%s with %d workers: got %q in layout %s, want %q in layout %s", tt.name, workers, got, layout, tt.want, tt.wantLayout)
+			}
+		}
+	}
+}
+
+func TestListSnapshotFilesAutoOnlyListsShards(t *testing.T) {
+	fs := shardedFS()
+	fs.dirs["/repo/snapshots/tmp"] = []os.FileInfo{file(id1)}
+	if _, _, err := ListSnapshotFiles(fs, "/repo", LayoutAuto); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(fs.listed)
+	if got := strings.Join(fs.listed, " "); got != "/repo/snapshots /repo/snapshots/ab /repo/snapshots/cd" {
+		t.Errorf("listed %s", got)
+	}
+}
+
+func TestListSnapshotFilesErrors(t *testing.T) {
+	failure := errors.New("connection lost")
+	fs := shardedFS()
+	fs.errs = map[string]error{"/repo/snapshots/cd": failure}
+	if _, _, err := ListSnapshotFilesConcurrently(fs, "/repo", LayoutAuto, 2); !errors.Is(err, failure) {
+		t.Errorf("got %v for a failing shard", err)
+	}
+	if _, _, err := ListSnapshotFiles(&fakeFS{}, "/repo", LayoutAuto); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("got %v for a missing snapshots directory", err)
+	}
+	if _, _, err := ListSnapshotFiles(flatFS(), "/repo", "nested"); err == nil {
+		t.Error("unknown layout accepted")
+	}
+}
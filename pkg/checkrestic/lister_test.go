@@ -0,0 +1,119 @@
+package checkrestic
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"check_restic/pkg/checkrestic/checkrestictest"
+)
+
+// writeTestRepo writes a repository with snapshots of two hosts, created one
+// and three hours ago, with their files modified a minute later.
+func writeTestRepo(t *testing.T, sharded bool) *checkrestictest.Repo {
+	t.Helper()
+	now := time.Now().UTC().Truncate(time.Second)
+	r, err := checkrestictest.WriteRepo(t.TempDir(), "secret", sharded,
+		checkrestictest.Snapshot{Time: now.Add(-time.Hour), ModTime: now.Add(-time.Hour + time.Minute), Hostname: "web1", Paths: []string{"/srv"}, Tags: []string{"daily"}},
+		checkrestictest.Snapshot{Time: now.Add(-3 * time.Hour), ModTime: now.Add(-3*time.Hour + time.Minute), Hostname: "db1", Paths: []string{"/var/lib/db"}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func sortedIDs(snapshots []*Snapshot) []string {
+	ids := make([]string, 0, len(snapshots))
+	for _, sn := range snapshots {
+		ids = append(ids, sn.ID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func equalIDs(t *testing.T, got []*Snapshot, want []string) {
+	t.Helper()
+	want = append([]string(nil), want...)
+	sort.Strings(want)
+	ids := sortedIDs(got)
+	if len(ids) != len(want) {
+		t.Fatalf("got snapshots %v, want %v", ids, want)
+	}
+	for i := range ids {
+		if ids[i] != want[i] {
+			t.Fatalf("got snapshots %v, want %v", ids, want)
+		}
+	}
+}
+
+func TestFSListerListed(t *testing.T) {
+	for _, sharded := range []bool{false, true} {
+		r := writeTestRepo(t, sharded)
+		l := &FSLister{FS: LocalFS{}, Path: r.Path}
+		snapshots, err := l.ListSnapshots(context.Background())
+		if err != nil {
+			t.Fatalf("sharded=%v: %s", sharded, err)
+		}
+		equalIDs(t, snapshots, r.IDs)
+		for _, sn := range snapshots {
+			if !sn.Time.IsZero() || sn.Hostname != "" {
+				t.Errorf("sharded=%v: %s was decoded without a password", sharded, sn.ID)
+			}
+			if sn.ModTime.IsZero() || sn.ModTime.Location() != time.UTC {
+				t.Errorf("sharded=%v: %s has modification time %s", sharded, sn.ID, sn.ModTime)
+			}
+		}
+	}
+}
+
+func TestFSListerDecoded(t *testing.T) {
+	for _, sharded := range []bool{false, true} {
+		r := writeTestRepo(t, sharded)
+		l := &FSLister{FS: LocalFS{}, Path: r.Path, Password: r.Password, DecodeConcurrency: 2, ListConcurrency: 2}
+		snapshots, err := l.ListSnapshots(context.Background())
+		if err != nil {
+			t.Fatalf("sharded=%v: %s", sharded, err)
+		}
+		equalIDs(t, snapshots, r.IDs)
+		hosts := map[string]bool{}
+		for _, sn := range snapshots {
+			hosts[sn.Hostname] = true
+			if sn.Time.IsZero() || !sn.ModTime.After(sn.Time) {
+				t.Errorf("sharded=%v: %s has time %s and modification time %s", sharded, sn.ID, sn.Time, sn.ModTime)
+			}
+		}
+		if !hosts["web1"] || !hosts["db1"] {
+			t.Errorf("sharded=%v: got hosts %v", sharded, hosts)
+		}
+
+		// the lister works with the Checker just like the plugin uses it
+		c := Checker{Thresholds: Thresholds{Warning: 2 * time.Hour, Critical: 4 * time.Hour}, NewestBy: "snapshot-time", Hosts: []string{"db1"}}
+		res, err := (&Check{Lister: l, Checker: c}).Run(context.Background())
+		if err != nil || res.Status != WARNING || res.Latest == nil || res.Latest.Hostname != "db1" {
+			t.Errorf("sharded=%v: got %s %v", sharded, res, err)
+		}
+	}
+}
+
+func TestFSListerErrors(t *testing.T) {
+	r := writeTestRepo(t, false)
+
+	l := &FSLister{FS: LocalFS{}, Path: r.Path, Password: "wrong"}
+	if _, err := l.ListSnapshots(context.Background()); err == nil {
+		t.Error("wrong password accepted")
+	}
+
+	l = &FSLister{FS: LocalFS{}, Path: t.TempDir()}
+	if _, err := l.ListSnapshots(context.Background()); err == nil {
+		t.Error("missing repository listed")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	l = &FSLister{FS: LocalFS{}, Path: r.Path, Password: r.Password}
+	if _, err := l.ListSnapshots(ctx); err != context.Canceled {
+		t.Errorf("got %v for a canceled context", err)
+	}
+}
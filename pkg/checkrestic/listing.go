@@ -0,0 +1,56 @@
+package checkrestic
+
+import (
+	"os"
+	"sync"
+)
+
+// ReadDirs lists the directories, up to workers of them at once, which hides
+// the latency of remote backends when listing the many shard directories of
+// large repositories. The listings are returned in the order of dirs. If any
+// of them fails, the error of the first such directory is returned and no
+// further directories are listed.
+func ReadDirs(fs FS, dirs []string, workers int) ([][]os.FileInfo, error) {
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(dirs) {
+		workers = len(dirs)
+	}
+
+	listings := make([][]os.FileInfo, len(dirs))
+	errs := make([]error, len(dirs))
+	next := make(chan int)
+	stop := make(chan struct{})
+	var once sync.Once
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				listings[i], errs[i] = fs.ReadDir(dirs[i])
+				if errs[i] != nil {
+					once.Do(func() { close(stop) })
+				}
+			}
+		}()
+	}
+feed:
+	for i := range dirs {
+		select {
+		case next <- i:
+		case <-stop:
+			break feed
+		}
+	}
+	close(next)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return listings, nil
+}
@@ -0,0 +1,183 @@
+package checkrestic
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HTTPError is returned by the object store backends for unexpected
+// responses. It matches fs.ErrNotExist for missing objects and
+// fs.ErrPermission for rejected credentials, so that callers can tell those
+// apart from other failures via errors.Is.
+type HTTPError struct {
+	Op         string
+	StatusCode int
+	Message    string
+}
+
+func (e *HTTPError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("%s: %s (%s)", e.Op, http.StatusText(e.StatusCode), e.Message)
+	}
+	return fmt.Sprintf("%s: %s", e.Op, http.StatusText(e.StatusCode))
+}
+
+func (e *HTTPError) Is(target error) bool {
+	switch target {
+	case fs.ErrNotExist:
+		return e.StatusCode == http.StatusNotFound
+	case fs.ErrPermission:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	}
+	return false
+}
+
+// Authorizer adds credentials to a request of an object store backend.
+type Authorizer func(req *http.Request) error
+
+// do sends the request after authorizing it and returns the body of a
+// successful response, which the caller needs to close.
+func do(client *http.Client, authorize Authorizer, req *http.Request, op string) (io.ReadCloser, error) {
+	if authorize != nil {
+		if err := authorize(req); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		// the URL may contain a shared access signature
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return nil, fmt.Errorf("%s: %s", op, err)
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, &HTTPError{Op: op, StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(body))}
+	}
+	return resp.Body, nil
+}
+
+// readRange reads n bytes at off, or the whole object if n is negative, via
+// the 'Range' header.
+func readRange(client *http.Client, authorize Authorizer, url, name string, off int64, n int) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if n >= 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+int64(n)-1))
+	}
+	body, err := do(client, authorize, req, "read "+name)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	if n >= 0 && len(data) != n {
+		return nil, fmt.Errorf("read %s: got %d bytes instead of %d", name, len(data), n)
+	}
+	return data, nil
+}
+
+// objectPrefix turns the path of a directory into the prefix of the objects
+// below it. Object names never start with a slash.
+func objectPrefix(dir string) string {
+	p := strings.TrimPrefix(path.Clean(dir), "/")
+	if p == "" || p == "." {
+		return ""
+	}
+	return p + "/"
+}
+
+// objectName turns the path of a file into the name of its object.
+func objectName(name string) string {
+	return strings.TrimPrefix(path.Clean(name), "/")
+}
+
+// objectInfo describes an object, or a common prefix as a directory, to
+// ReadDir callers.
+type objectInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (fi objectInfo) Name() string       { return fi.name }
+func (fi objectInfo) Size() int64        { return fi.size }
+func (fi objectInfo) ModTime() time.Time { return fi.modTime }
+func (fi objectInfo) IsDir() bool        { return fi.dir }
+func (fi objectInfo) Sys() interface{}   { return nil }
+
+func (fi objectInfo) Mode() os.FileMode {
+	if fi.dir {
+		return os.ModeDir | 0o755
+	}
+	return 0o644
+}
+
+// BearerToken caches an OAuth access token until shortly before it expires.
+type BearerToken struct {
+	// Fetch obtains a new token along with its lifetime.
+	Fetch func() (string, time.Duration, error)
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// Authorize implements Authorizer.
+func (t *BearerToken) Authorize(req *http.Request) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token == "" || time.Now().After(t.expires) {
+		token, lifetime, err := t.Fetch()
+		if err != nil {
+			return fmt.Errorf("unable to obtain an access token: %s", err)
+		}
+		t.token, t.expires = token, time.Now().Add(lifetime-time.Minute)
+	}
+	req.Header.Set("Authorization", "Bearer "+t.token)
+	return nil
+}
+
+// fetchToken decodes the response of an OAuth token endpoint. Some services
+// return the lifetime as a string rather than a number.
+func fetchToken(client *http.Client, req *http.Request) (string, time.Duration, error) {
+	body, err := do(client, nil, req, "fetch token")
+	if err != nil {
+		return "", 0, err
+	}
+	defer body.Close()
+	var resp struct {
+		AccessToken string      `json:"access_token"`
+		ExpiresIn   json.Number `json:"expires_in"`
+	}
+	if err := json.NewDecoder(body).Decode(&resp); err != nil {
+		return "", 0, err
+	}
+	if resp.AccessToken == "" {
+		return "", 0, fmt.Errorf("no access token returned")
+	}
+	seconds, err := resp.ExpiresIn.Int64()
+	if err != nil {
+		seconds = 300
+	}
+	return resp.AccessToken, time.Duration(seconds) * time.Second, nil
+}
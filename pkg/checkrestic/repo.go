@@ -0,0 +1,517 @@
+package checkrestic
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/sftp"
+)
+
+// FS is the subset of file operations needed to read a repository. It is
+// the interface to implement for a new backend, see S3FS for an example of
+// one without directories.
+type FS interface {
+	ReadDir(name string) ([]os.FileInfo, error)
+	ReadFile(name string) ([]byte, error)
+	ReadAt(name string, off int64, n int) ([]byte, error)
+}
+
+// StatFS is implemented by the backends which can look up a single file or
+// directory, which object stores cannot do for directories.
+type StatFS interface {
+	FS
+	Stat(name string) (os.FileInfo, error)
+}
+
+// SFTPFS implements FS on top of an SFTP session.
+type SFTPFS struct {
+	Client *sftp.Client
+}
+
+func (fs SFTPFS) ReadDir(name string) ([]os.FileInfo, error) {
+	return fs.Client.ReadDir(name)
+}
+
+func (fs SFTPFS) Stat(name string) (os.FileInfo, error) {
+	return fs.Client.Stat(name)
+}
+
+func (fs SFTPFS) ReadFile(name string) ([]byte, error) {
+	f, err := fs.Client.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+func (fs SFTPFS) ReadAt(name string, off int64, n int) ([]byte, error) {
+	f, err := fs.Client.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	buf := make([]byte, n)
+	if m, err := f.ReadAt(buf, off); err != nil && !(err == io.EOF && m == n) {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// LocalFS implements FS on top of the local filesystem, e.g. on the backup
+// server itself.
+type LocalFS struct{}
+
+func (LocalFS) ReadDir(name string) ([]os.FileInfo, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Readdir(-1)
+}
+
+func (LocalFS) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (LocalFS) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(name)
+}
+
+func (LocalFS) ReadAt(name string, off int64, n int) ([]byte, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	buf := make([]byte, n)
+	if m, err := f.ReadAt(buf, off); err != nil && !(err == io.EOF && m == n) {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// Snapshot is the decoded content of a file below snapshots/. ID and ModTime
+// describe the file itself, the other fields are only set if it was decoded.
+type Snapshot struct {
+	ID      string    `json:"-"`
+	ModTime time.Time `json:"-"`
+
+	Time     time.Time `json:"time"`
+	Parent   string    `json:"parent,omitempty"`
+	Tree     string    `json:"tree"`
+	Paths    []string  `json:"paths"`
+	Hostname string    `json:"hostname,omitempty"`
+	Username string    `json:"username,omitempty"`
+	Tags     []string  `json:"tags,omitempty"`
+}
+
+// Lock is the decoded content of a file below locks/.
+type Lock struct {
+	ID string `json:"-"`
+
+	Time      time.Time `json:"time"`
+	Exclusive bool      `json:"exclusive"`
+	Hostname  string    `json:"hostname"`
+	Username  string    `json:"username"`
+	PID       int       `json:"pid"`
+	UID       uint32    `json:"uid,omitempty"`
+	GID       uint32    `json:"gid,omitempty"`
+}
+
+// Config is the decoded content of the config file of a repository.
+type Config struct {
+	Version           int    `json:"version"`
+	ID                string `json:"id"`
+	ChunkerPolynomial string `json:"chunker_polynomial"`
+}
+
+// tree is the decoded content of a tree blob.
+type tree struct {
+	Nodes []struct {
+		Name    string `json:"name"`
+		Type    string `json:"type"`
+		Subtree string `json:"subtree,omitempty"`
+	} `json:"nodes"`
+}
+
+// blobLocation describes where a blob is stored inside a pack file.
+type blobLocation struct {
+	Pack               string
+	Offset             int64
+	Length             int
+	UncompressedLength int
+}
+
+// indexFile is the decoded content of a file below index/.
+type indexFile struct {
+	Packs []struct {
+		ID    string `json:"id"`
+		Blobs []struct {
+			ID                 string `json:"id"`
+			Type               string `json:"type"`
+			Offset             int64  `json:"offset"`
+			Length             int    `json:"length"`
+			UncompressedLength int    `json:"uncompressed_length,omitempty"`
+		} `json:"blobs"`
+	} `json:"packs"`
+}
+
+// Repo provides read access to the encrypted contents of a repository.
+type Repo struct {
+	fs   FS
+	path string
+	key  *masterKey
+
+	// SnapshotLayout is the layout of the snapshots directory, see
+	// ListSnapshotFiles. It defaults to a flat one.
+	SnapshotLayout string
+
+	// DecodeConcurrency is the number of snapshots Snapshots reads and
+	// decodes at once. It defaults to one at a time.
+	DecodeConcurrency int
+
+	indexOnce sync.Once
+	index     map[string]blobLocation
+	indexErr  error
+}
+
+var zstdDecoder, _ = zstd.NewReader(nil)
+
+// Logf logs details about reading a repository, it discards them by default.
+var Logf = func(format string, args ...interface{}) {}
+
+// OpenRepo tries every key of the repository until one can be opened with
+// password.
+func OpenRepo(fs FS, repoPath, password string) (*Repo, error) {
+	files, err := fs.ReadDir(path.Join(repoPath, "keys"))
+	if err != nil {
+		return nil, err
+	}
+	for _, fi := range files {
+		data, err := fs.ReadFile(path.Join(repoPath, "keys", fi.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var kf keyFile
+		if err := json.Unmarshal(data, &kf); err != nil {
+			continue
+		}
+		key, err := openKeyFile(&kf, password)
+		if err != nil {
+			continue
+		}
+		return &Repo{fs: fs, path: repoPath, key: key}, nil
+	}
+	return nil, errors.New("wrong password or no key found")
+}
+
+// loadUnpacked reads, decrypts and decompresses a file stored on its own in
+// the repository, e.g. a snapshot or an index.
+func (r *Repo) loadUnpacked(name string) ([]byte, error) {
+	data, err := r.fs.ReadFile(path.Join(r.path, name))
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := r.key.decrypt(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+
+	// Repository format version 2 prefixes compressed files with a version
+	// byte, while uncompressed files remain plain JSON.
+	if len(plaintext) == 0 || plaintext[0] == '{' || plaintext[0] == '[' {
+		return plaintext, nil
+	}
+	if plaintext[0] != 2 {
+		return nil, fmt.Errorf("%s: unsupported encoding %d", name, plaintext[0])
+	}
+	return zstdDecoder.DecodeAll(plaintext[1:], nil)
+}
+
+func (r *Repo) loadJSON(name string, v interface{}) error {
+	data, err := r.loadUnpacked(name)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%s: %s", name, err)
+	}
+	return nil
+}
+
+// Config decodes the config file of the repository.
+func (r *Repo) Config() (*Config, error) {
+	var cfg Config
+	if err := r.loadJSON("config", &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// Snapshot decodes the snapshot with the given id.
+func (r *Repo) Snapshot(id string) (*Snapshot, error) {
+	sn := Snapshot{ID: id}
+	name := path.Join("snapshots", id)
+	if r.SnapshotLayout == LayoutSharded && len(id) > 2 {
+		err := r.loadJSON(path.Join("snapshots", id[:2], id), &sn)
+		// sharded repositories may still hold some files in the flat layout
+		if !errors.Is(err, os.ErrNotExist) {
+			if err != nil {
+				return nil, err
+			}
+			return &sn, nil
+		}
+	}
+	if err := r.loadJSON(name, &sn); err != nil {
+		return nil, err
+	}
+	return &sn, nil
+}
+
+// Locks decodes every lock of the repository. Locks may vanish while they
+// are being read, which is not an error.
+func (r *Repo) Locks() ([]*Lock, error) {
+	files, err := r.fs.ReadDir(path.Join(r.path, "locks"))
+	if err != nil {
+		return nil, err
+	}
+	locks := make([]*Lock, 0, len(files))
+	for _, fi := range files {
+		if !fi.Mode().IsRegular() || !IsID(fi.Name()) {
+			continue
+		}
+		l := Lock{ID: fi.Name()}
+		if err := r.loadJSON(path.Join("locks", fi.Name()), &l); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		locks = append(locks, &l)
+	}
+	return locks, nil
+}
+
+// IsID reports whether name looks like the ID of a restic file, i.e. 64
+// lowercase hex digits.
+func IsID(name string) bool {
+	return len(name) == 64 && isHex(name)
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// SnapshotFiles filters a listing of the snapshots directory, keeping only
+// regular files named like snapshot IDs. Some SFTP servers also return
+// entries like '.', '..', temporary files or directories.
+func SnapshotFiles(files []os.FileInfo) []os.FileInfo {
+	valid := make([]os.FileInfo, 0, len(files))
+	for _, fi := range files {
+		if !fi.Mode().IsRegular() || !IsID(fi.Name()) {
+			Logf("ignoring %s in snapshots directory", fi.Name())
+			continue
+		}
+		valid = append(valid, fi)
+	}
+	return valid
+}
+
+// ModTime returns the modification time of a file in the repository. SFTP
+// transfers it as seconds since the epoch, which do not depend on any time
+// zone, so it is normalized to UTC: ages are then independent of the local
+// TZ and the times are rendered the same way on every machine.
+func ModTime(fi os.FileInfo) time.Time {
+	return fi.ModTime().UTC()
+}
+
+// Snapshots decodes the snapshots stored in the given files below
+// snapshots/.
+func (r *Repo) Snapshots(files []os.FileInfo) ([]*Snapshot, error) {
+	return r.SnapshotsContext(context.Background(), files)
+}
+
+// SnapshotsContext decodes the snapshots stored in the given files below
+// snapshots/, up to DecodeConcurrency of them at once. The snapshots are
+// returned in the order of the files regardless of the order in which they
+// were decoded. If any of them cannot be decoded, the error of the first
+// such file is returned. No further files are read once ctx is done or a
+// file failed.
+func (r *Repo) SnapshotsContext(ctx context.Context, files []os.FileInfo) ([]*Snapshot, error) {
+	workers := r.DecodeConcurrency
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(files) {
+		workers = len(files)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	snapshots := make([]*Snapshot, len(files))
+	errs := make([]error, len(files))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				sn, err := r.Snapshot(files[i].Name())
+				if err != nil {
+					errs[i] = err
+					cancel()
+					continue
+				}
+				sn.ModTime = ModTime(files[i])
+				snapshots[i] = sn
+			}
+		}()
+	}
+feed:
+	for i := range files {
+		select {
+		case next <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(next)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	// only reached without any failed file if the caller gave up
+	for _, sn := range snapshots {
+		if sn == nil {
+			return nil, ctx.Err()
+		}
+	}
+	return snapshots, nil
+}
+
+// loadIndex reads every index file of the repository. This is done at most
+// once per repository since it is expensive for large repositories.
+func (r *Repo) loadIndex() error {
+	r.indexOnce.Do(func() {
+		files, err := r.fs.ReadDir(path.Join(r.path, "index"))
+		if err != nil {
+			r.indexErr = err
+			return
+		}
+
+		r.index = make(map[string]blobLocation)
+		for _, fi := range files {
+			data, err := r.loadUnpacked(path.Join("index", fi.Name()))
+			if err != nil {
+				r.indexErr = err
+				return
+			}
+			var idx indexFile
+			if strings.HasPrefix(string(data), "[") {
+				// legacy index format consisting of the packs only
+				err = json.Unmarshal(data, &idx.Packs)
+			} else {
+				err = json.Unmarshal(data, &idx)
+			}
+			if err != nil {
+				r.indexErr = fmt.Errorf("index/%s: %s", fi.Name(), err)
+				return
+			}
+
+			for _, pack := range idx.Packs {
+				for _, blob := range pack.Blobs {
+					r.index[blob.ID] = blobLocation{
+						Pack:               pack.ID,
+						Offset:             blob.Offset,
+						Length:             blob.Length,
+						UncompressedLength: blob.UncompressedLength,
+					}
+				}
+			}
+		}
+	})
+	return r.indexErr
+}
+
+// loadBlob reads, decrypts and decompresses a blob from its pack file.
+func (r *Repo) loadBlob(id string) ([]byte, error) {
+	if err := r.loadIndex(); err != nil {
+		return nil, err
+	}
+	loc, ok := r.index[id]
+	if !ok {
+		return nil, fmt.Errorf("blob %s not found in index", id)
+	}
+	if len(loc.Pack) < 2 {
+		return nil, fmt.Errorf("invalid pack id %q", loc.Pack)
+	}
+
+	data, err := r.fs.ReadAt(path.Join(r.path, "data", loc.Pack[:2], loc.Pack), loc.Offset, loc.Length)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := r.key.decrypt(data)
+	if err != nil {
+		return nil, fmt.Errorf("blob %s: %s", id, err)
+	}
+	if loc.UncompressedLength != 0 {
+		return zstdDecoder.DecodeAll(plaintext, make([]byte, 0, loc.UncompressedLength))
+	}
+	return plaintext, nil
+}
+
+func (r *Repo) loadTree(id string) (*tree, error) {
+	data, err := r.loadBlob(id)
+	if err != nil {
+		return nil, err
+	}
+	var t tree
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("tree %s: %s", id, err)
+	}
+	return &t, nil
+}
+
+// TreeIsEmpty walks the tree below id and reports whether it consists of
+// directories only, i.e. does not contain a single file, symlink or other
+// non-directory node. The walk stops at the first such node, so non-empty
+// trees usually only require loading a few blobs.
+func (r *Repo) TreeIsEmpty(id string) (bool, error) {
+	t, err := r.loadTree(id)
+	if err != nil {
+		return false, err
+	}
+	for _, node := range t.Nodes {
+		if node.Type != "dir" {
+			return false, nil
+		}
+		if node.Subtree == "" {
+			continue
+		}
+		empty, err := r.TreeIsEmpty(node.Subtree)
+		if err != nil || !empty {
+			return empty, err
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,75 @@
+package checkrestic
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSnapshotFiles(t *testing.T) {
+	entries := []os.FileInfo{
+		file(id1),
+		dir("."),
+		dir(".."),
+		dir(id2),
+		fakeFileInfo{name: id3, mode: os.ModeSymlink | 0o777},
+		file(id4[:63]),
+		file(id4 + "0"),
+		file(strings.ToUpper(id4)),
+		file(strings.Repeat("g", 64)),
+		file(id4 + ".tmp"),
+		file(".tmp-" + id4),
+		file(id4),
+	}
+	got := SnapshotFiles(entries)
+	if len(got) != 2 || got[0].Name() != id1 || got[1].Name() != id4 {
+		t.Errorf("kept %s, want %s and %s", names(got), id1, id4)
+	}
+	if got := SnapshotFiles(nil); len(got) != 0 {
+		t.Errorf("kept %d entries of an empty listing", len(got))
+	}
+}
+
+func TestIsID(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{id1, true},
+		{id2, true},
+		{"", false},
+		{id1[:63], false},
+		{id1 + "1", false},
+		{strings.ToUpper(id2), false},
+		{"xy" + id1[2:], false},
+	}
+	for _, tt := range tests {
+		if got := IsID(tt.name); got != tt.want {
+			t.Errorf("IsID(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestModTime(t *testing.T) {
+	// a file modified at 12:00 UTC as reported in the local zone of a server
+	// or client east and west of UTC
+	want := time.Date(2026, 1, 14, 12, 0, 0, 0, time.UTC)
+	for _, zone := range []*time.Location{time.UTC, time.FixedZone("CEST", 2*60*60), time.FixedZone("NDT", -(2*60*60 + 30*60))} {
+		fi := fakeFileInfo{name: id1, modTime: want.In(zone)}
+		got := ModTime(fi)
+		if !got.Equal(want) || got.Location() != time.UTC || got.String() != want.String() {
+			t.Errorf("in %s: got %s, want %s", zone, got, want)
+		}
+		listed := ListedSnapshots([]os.FileInfo{fi})
+		if listed[0].ModTime.Location() != time.UTC {
+			t.Errorf("in %s: listed snapshot modified %s", zone, listed[0].ModTime)
+		}
+		c := Checker{Thresholds: Thresholds{Warning: time.Hour}, Now: func() time.Time { return want.In(zone).Add(30 * time.Minute) }}
+		res, err := c.Check(context.Background(), SnapshotList(listed))
+		if err != nil || res.Age != 30*time.Minute || res.Status != OK {
+			t.Errorf("in %s: got age %s and status %d, %v", zone, res.Age, res.Status, err)
+		}
+	}
+}
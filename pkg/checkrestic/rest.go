@@ -0,0 +1,87 @@
+package checkrestic
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+)
+
+// restV2 selects the version of the REST API which also returns the sizes of
+// the files in listings.
+const restV2 = "application/vnd.x.restic.rest.v2"
+
+// RESTFS implements FS on top of the REST API of restic's rest-server. The
+// API does not report modification times, so the snapshots need to be
+// decoded to determine their age.
+type RESTFS struct {
+	Client *http.Client
+	// URL is the URL of the server, the paths of the repositories are
+	// relative to it.
+	URL string
+	// User and Password are sent via basic authentication if User is set.
+	User     string
+	Password string
+}
+
+func (fs RESTFS) url(name string) string {
+	segments := strings.Split(strings.TrimPrefix(path.Clean("/"+name), "/"), "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.TrimSuffix(fs.URL, "/") + "/" + strings.Join(segments, "/")
+}
+
+func (fs RESTFS) authorize(req *http.Request) error {
+	if fs.User != "" {
+		req.SetBasicAuth(fs.User, fs.Password)
+	}
+	return nil
+}
+
+// ReadDir lists the files of a directory of the repository. The server lists
+// all files of the type of the directory at once, even if they are stored
+// in subdirectories, so there are never any directories in the result.
+func (fs RESTFS) ReadDir(name string) ([]os.FileInfo, error) {
+	req, err := http.NewRequest(http.MethodGet, fs.url(name)+"/", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", restV2)
+	body, err := do(fs.Client, fs.authorize, req, "list "+name)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	var files []json.RawMessage
+	if err := json.NewDecoder(body).Decode(&files); err != nil {
+		return nil, fmt.Errorf("list %s: %s", name, err)
+	}
+	entries := make([]os.FileInfo, 0, len(files))
+	for _, raw := range files {
+		var f struct {
+			Name string `json:"name"`
+			Size int64  `json:"size"`
+		}
+		// servers only supporting version 1 list the names only
+		if err := json.Unmarshal(raw, &f.Name); err != nil {
+			if err := json.Unmarshal(raw, &f); err != nil {
+				return nil, fmt.Errorf("list %s: %s", name, err)
+			}
+		}
+		entries = append(entries, objectInfo{name: f.Name, size: f.Size})
+	}
+	return entries, nil
+}
+
+func (fs RESTFS) ReadFile(name string) ([]byte, error) {
+	return readRange(fs.Client, fs.authorize, fs.url(name), name, 0, -1)
+}
+
+func (fs RESTFS) ReadAt(name string, off int64, n int) ([]byte, error) {
+	return readRange(fs.Client, fs.authorize, fs.url(name), name, off, n)
+}
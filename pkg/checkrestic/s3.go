@@ -0,0 +1,274 @@
+package checkrestic
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3FS implements FS on top of a bucket of Amazon S3 or a compatible service
+// like MinIO.
+type S3FS struct {
+	Client *http.Client
+	// Endpoint is the URL of the service, e.g. https://s3.eu-west-1.amazonaws.com
+	// or https://minio.example.com:9000.
+	Endpoint string
+	Bucket   string
+	// PathStyle addresses the bucket as the first element of the path
+	// instead of as a subdomain of the endpoint, which is what most
+	// compatible services expect.
+	PathStyle bool
+	// Prefix is prepended to the paths of all objects, e.g. to keep
+	// several repositories below a common prefix.
+	Prefix string
+	// Authorize adds the credentials, see S3SigV4. It may be nil for public
+	// buckets.
+	Authorize Authorizer
+}
+
+func (fs S3FS) url(key, query string) string {
+	u := strings.TrimSuffix(fs.Endpoint, "/")
+	if fs.PathStyle {
+		u += "/" + s3Escape(fs.Bucket, false)
+	} else if i := strings.Index(u, "://"); i >= 0 {
+		u = u[:i+3] + fs.Bucket + "." + u[i+3:]
+	}
+	u += "/" + s3Escape(key, true)
+	if query != "" {
+		u += "?" + query
+	}
+	return u
+}
+
+type s3ListResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		LastModified time.Time `xml:"LastModified"`
+		Size         int64     `xml:"Size"`
+	} `xml:"Contents"`
+	CommonPrefixes []struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"CommonPrefixes"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// ReadDir lists the objects directly below the prefix of the directory,
+// common prefixes of deeper objects are returned as directories. Just like
+// for a directory without files, the result is empty if there are no such
+// objects.
+func (fs S3FS) ReadDir(name string) ([]os.FileInfo, error) {
+	prefix := objectPrefix(path.Join("/", fs.Prefix, name))
+	var entries []os.FileInfo
+	token := ""
+	for {
+		q := url.Values{}
+		q.Set("list-type", "2")
+		q.Set("delimiter", "/")
+		if prefix != "" {
+			q.Set("prefix", prefix)
+		}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		req, err := http.NewRequest(http.MethodGet, fs.url("", s3Query(q)), nil)
+		if err != nil {
+			return nil, err
+		}
+		body, err := do(fs.Client, fs.Authorize, req, "list "+name)
+		if err != nil {
+			return nil, err
+		}
+		var res s3ListResult
+		err = xml.NewDecoder(body).Decode(&res)
+		body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("list %s: %s", name, err)
+		}
+		for _, obj := range res.Contents {
+			entries = append(entries, objectInfo{name: path.Base(obj.Key), size: obj.Size, modTime: obj.LastModified})
+		}
+		for _, p := range res.CommonPrefixes {
+			entries = append(entries, objectInfo{name: path.Base(strings.TrimSuffix(p.Prefix, "/")), dir: true})
+		}
+		if !res.IsTruncated || res.NextContinuationToken == "" {
+			return entries, nil
+		}
+		token = res.NextContinuationToken
+	}
+}
+
+func (fs S3FS) ReadFile(name string) ([]byte, error) {
+	return readRange(fs.Client, fs.Authorize, fs.url(objectName(path.Join(fs.Prefix, name)), ""), name, 0, -1)
+}
+
+func (fs S3FS) ReadAt(name string, off int64, n int) ([]byte, error) {
+	return readRange(fs.Client, fs.Authorize, fs.url(objectName(path.Join(fs.Prefix, name)), ""), name, off, n)
+}
+
+// s3Escape encodes s the way signature version 4 expects it: everything but
+// the unreserved characters, and optionally slashes, is percent-encoded.
+func s3Escape(s string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (keepSlash && c == '/') {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// s3Query encodes the query parameters sorted by name, which is the
+// canonical form signed by signature version 4.
+func s3Query(q url.Values) string {
+	names := make([]string, 0, len(q))
+	for name := range q {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var parts []string
+	for _, name := range names {
+		values := append([]string(nil), q[name]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, s3Escape(name, false)+"="+s3Escape(v, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// S3Credentials are the access keys of an AWS account or user. SessionToken
+// is only set for temporary credentials.
+type S3Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// emptySHA256 is the hash of the empty payload of every request sent.
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// S3SigV4 authorizes requests with signature version 4 for the region.
+func S3SigV4(creds S3Credentials, region string) Authorizer {
+	return func(req *http.Request) error {
+		now := time.Now().UTC()
+		amzDate := now.Format("20060102T150405Z")
+		day := now.Format("20060102")
+
+		req.Header.Set("x-amz-date", amzDate)
+		req.Header.Set("x-amz-content-sha256", emptySHA256)
+		if creds.SessionToken != "" {
+			req.Header.Set("x-amz-security-token", creds.SessionToken)
+		}
+
+		headers := map[string]string{"host": req.URL.Host}
+		for name := range req.Header {
+			if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-amz-") || lower == "range" {
+				headers[lower] = strings.TrimSpace(req.Header.Get(name))
+			}
+		}
+		names := make([]string, 0, len(headers))
+		for name := range headers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		var canonicalHeaders strings.Builder
+		for _, name := range names {
+			canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+		}
+		signedHeaders := strings.Join(names, ";")
+
+		// the query needs to be sent exactly as signed
+		req.URL.RawQuery = s3Query(req.URL.Query())
+		canonicalRequest := strings.Join([]string{
+			req.Method,
+			req.URL.EscapedPath(),
+			req.URL.RawQuery,
+			canonicalHeaders.String(),
+			signedHeaders,
+			emptySHA256,
+		}, "\n")
+
+		scope := day + "/" + region + "/s3/aws4_request"
+		sum := sha256.Sum256([]byte(canonicalRequest))
+		stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+		key := []byte("AWS4" + creds.SecretAccessKey)
+		for _, part := range []string{day, region, "s3", "aws4_request"} {
+			key = hmacSHA256(key, part)
+		}
+		signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+		req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+			creds.AccessKeyID, scope, signedHeaders, signature))
+		return nil
+	}
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// LoadS3Credentials reads the credentials of the profile from a shared
+// credentials file as written by 'aws configure'.
+func LoadS3Credentials(name, profile string) (S3Credentials, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return S3Credentials{}, err
+	}
+	defer f.Close()
+
+	var creds S3Credentials
+	found := false
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		if section != profile {
+			continue
+		}
+		i := strings.Index(line, "=")
+		if i < 0 {
+			continue
+		}
+		found = true
+		value := strings.TrimSpace(line[i+1:])
+		switch strings.TrimSpace(line[:i]) {
+		case "aws_access_key_id":
+			creds.AccessKeyID = value
+		case "aws_secret_access_key":
+			creds.SecretAccessKey = value
+		case "aws_session_token":
+			creds.SessionToken = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return S3Credentials{}, err
+	}
+	if !found || creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return S3Credentials{}, fmt.Errorf("%s: no access keys found for profile %q", name, profile)
+	}
+	return creds, nil
+}